@@ -1,12 +1,9 @@
 package main
 
 import (
-	"flag"
-	"log"
 	"os"
 
-	"github.com/edfun317/ereader/internal/format/epub"
-	"github.com/edfun317/ereader/internal/viewer/cli"
+	"github.com/edfun317/ereader/internal/cli"
 )
 
 func main() {
@@ -17,17 +14,10 @@ func main() {
 
 func run() {
 
-	filePath := flag.String("file", "", "Path to EPUB file")
-	flag.Parse()
-
-	if *filePath == "" {
-		flag.Usage()
+	root := cli.InitCommands()
+	root.SetArgs(cli.NormalizeArgs(os.Args[1:]))
+	if err := root.Execute(); err != nil {
 		os.Exit(1)
 	}
-	reader := epub.NewEPUBReader()
-	viewer := cli.NewCLIViewer(reader)
-	if err := viewer.Start(*filePath); err != nil {
-		log.Fatal(err)
-	}
 
 }
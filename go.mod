@@ -5,7 +5,9 @@ go 1.23.4
 require (
 	github.com/eiannone/keyboard v0.0.0-20220611211555-0d226195f203
 	github.com/fatih/color v1.18.0
+	go.etcd.io/bbolt v1.3.11
 	golang.org/x/net v0.34.0
+	golang.org/x/text v0.21.0
 )
 
 require (
@@ -17,5 +19,5 @@ require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/spf13/cobra v1.8.1
-	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/sys v0.30.0
 )
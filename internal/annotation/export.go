@@ -0,0 +1,259 @@
+package annotation
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edfun317/ereader/internal/core"
+)
+
+// Export formats
+const (
+	FormatMarkdown = "markdown"
+	FormatJSON     = "json"
+	FormatCSV      = "csv"
+)
+
+// readwiseDate is the date layout of Readwise CSV imports
+const readwiseDate = "2006-01-02 15:04:05"
+
+// ExportBook is a book's annotations together with its metadata
+type ExportBook struct {
+	Metadata    core.BookMetadata
+	Annotations core.BookAnnotations
+}
+
+// Write exports books in the given format
+func Write(w io.Writer, format string, books []ExportBook) error {
+	switch format {
+	case FormatMarkdown:
+		return WriteMarkdown(w, books)
+	case FormatJSON:
+		return WriteJSON(w, books)
+	case FormatCSV:
+		return WriteCSV(w, books)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// Extension returns the file extension used for a format
+func Extension(format string) string {
+	if format == FormatMarkdown {
+		return ".md"
+	}
+	return "." + format
+}
+
+// WriteMarkdown writes one Markdown document per book, opening with YAML
+// front matter as used by Obsidian
+func WriteMarkdown(w io.Writer, books []ExportBook) error {
+	var sb strings.Builder
+	for i, book := range books {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		writeFrontMatter(&sb, book)
+
+		fmt.Fprintf(&sb, "\n# %s\n", book.title())
+		if book.Metadata.Subtitle != "" {
+			fmt.Fprintf(&sb, "\n*%s*\n", book.Metadata.Subtitle)
+		}
+
+		chapter := -1
+		for _, h := range book.Annotations.Highlights {
+			if h.Chapter != chapter {
+				chapter = h.Chapter
+				fmt.Fprintf(&sb, "\n## %s\n", chapterTitle(h))
+			}
+			sb.WriteString("\n")
+			for _, line := range strings.Split(h.Text, "\n") {
+				fmt.Fprintf(&sb, "> %s\n", line)
+			}
+			if h.Note != "" {
+				fmt.Fprintf(&sb, "\n%s\n", h.Note)
+			}
+			fmt.Fprintf(&sb, "\n*Chapter %d, offset %d", h.Chapter+1, h.Start)
+			if !h.Created.IsZero() { // Imported clippings may come without a date
+				fmt.Fprintf(&sb, " · %s", h.Created.Format("2006-01-02"))
+			}
+			sb.WriteString("*\n")
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func writeFrontMatter(sb *strings.Builder, book ExportBook) {
+	m := book.Metadata
+	sb.WriteString("---\n")
+	fmt.Fprintf(sb, "title: %s\n", yamlString(book.title()))
+	if m.Subtitle != "" {
+		fmt.Fprintf(sb, "subtitle: %s\n", yamlString(m.Subtitle))
+	}
+	if authors := book.authors(); len(authors) > 0 {
+		sb.WriteString("author:\n")
+		for _, a := range authors {
+			fmt.Fprintf(sb, "  - %s\n", yamlString(a))
+		}
+	}
+	writeYAMLField(sb, "publisher", m.Publisher)
+	writeYAMLField(sb, "published", m.Published)
+	writeYAMLField(sb, "language", m.Language)
+	writeYAMLField(sb, "isbn", m.ISBN())
+	writeYAMLField(sb, "series", m.Series)
+	writeYAMLField(sb, "series_index", m.SeriesIndex)
+	if len(m.Subjects) > 0 {
+		sb.WriteString("tags:\n")
+		for _, s := range m.Subjects {
+			fmt.Fprintf(sb, "  - %s\n", yamlString(s))
+		}
+	}
+	writeYAMLField(sb, "source", book.Annotations.FilePath)
+	fmt.Fprintf(sb, "highlights: %d\n", len(book.Annotations.Highlights))
+	fmt.Fprintf(sb, "exported: %s\n", time.Now().Format("2006-01-02"))
+	sb.WriteString("---\n")
+}
+
+func writeYAMLField(sb *strings.Builder, key, value string) {
+	if value != "" {
+		fmt.Fprintf(sb, "%s: %s\n", key, yamlString(value))
+	}
+}
+
+// yamlString quotes a value as a YAML double-quoted scalar
+func yamlString(s string) string {
+	return strconv.Quote(s)
+}
+
+type (
+	jsonBook struct {
+		FilePath   string          `json:"file_path"`
+		Title      string          `json:"title"`
+		Subtitle   string          `json:"subtitle,omitempty"`
+		Authors    []string        `json:"authors,omitempty"`
+		Publisher  string          `json:"publisher,omitempty"`
+		Published  string          `json:"published,omitempty"`
+		Language   string          `json:"language,omitempty"`
+		ISBN       string          `json:"isbn,omitempty"`
+		Identifier string          `json:"identifier,omitempty"`
+		Subjects   []string        `json:"subjects,omitempty"`
+		Highlights []jsonHighlight `json:"highlights"`
+	}
+
+	jsonHighlight struct {
+		core.Highlight
+		Location string `json:"location"`
+	}
+)
+
+// WriteJSON writes the books with their metadata and highlights as JSON
+func WriteJSON(w io.Writer, books []ExportBook) error {
+	result := make([]jsonBook, 0, len(books))
+	for _, book := range books {
+		m := book.Metadata
+		jb := jsonBook{
+			FilePath:   book.Annotations.FilePath,
+			Title:      book.title(),
+			Subtitle:   m.Subtitle,
+			Authors:    book.authors(),
+			Publisher:  m.Publisher,
+			Published:  m.Published,
+			Language:   m.Language,
+			ISBN:       m.ISBN(),
+			Identifier: m.UniqueIdentifier,
+			Subjects:   m.Subjects,
+			Highlights: make([]jsonHighlight, 0, len(book.Annotations.Highlights)),
+		}
+		for _, h := range book.Annotations.Highlights {
+			h.ChapterTitle = chapterTitle(h)
+			jb.Highlights = append(jb.Highlights, jsonHighlight{
+				Highlight: h,
+				Location:  fmt.Sprintf("chapter %d, offset %d", h.Chapter+1, h.Start),
+			})
+		}
+		result = append(result, jb)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	if err := encoder.Encode(result); err != nil {
+		return fmt.Errorf("failed to encode annotations: %w", err)
+	}
+	return nil
+}
+
+// WriteCSV writes highlights in the CSV layout accepted by Readwise. The
+// location is the highlight's position in reading order.
+func WriteCSV(w io.Writer, books []ExportBook) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"Highlight", "Title", "Author", "URL", "Note", "Location", "Date"}); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+
+	for _, book := range books {
+		author := strings.Join(book.authors(), ", ")
+		for i, h := range book.Annotations.Highlights {
+			date := "" // Imported clippings may come without one
+			if !h.Created.IsZero() {
+				date = h.Created.UTC().Format(readwiseDate)
+			}
+			record := []string{
+				h.Text,
+				book.title(),
+				author,
+				"",
+				h.Note,
+				strconv.Itoa(i + 1),
+				date,
+			}
+			if err := writer.Write(record); err != nil {
+				return fmt.Errorf("failed to write CSV: %w", err)
+			}
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return nil
+}
+
+// title prefers the book's metadata over the title stored with the highlights
+func (b ExportBook) title() string {
+	if b.Metadata.Title != "" {
+		return b.Metadata.Title
+	}
+	if b.Annotations.Title != "" {
+		return b.Annotations.Title
+	}
+	return "Untitled"
+}
+
+func (b ExportBook) authors() []string {
+	var names []string
+	for _, a := range b.Metadata.Authors() {
+		names = append(names, a.Name)
+	}
+	if len(names) == 0 && b.Metadata.Author != "" {
+		names = append(names, b.Metadata.Author)
+	}
+	if len(names) == 0 && b.Annotations.Author != "" {
+		names = append(names, b.Annotations.Author)
+	}
+	return names
+}
+
+func chapterTitle(h core.Highlight) string {
+	if h.ChapterTitle != "" {
+		return h.ChapterTitle
+	}
+	return fmt.Sprintf("Chapter %d", h.Chapter+1)
+}
@@ -0,0 +1,20 @@
+package annotation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Highlight colors offered by the reader
+var Colors = []string{"yellow", "green", "blue", "magenta", "red"}
+
+// NewID returns a random identifier for a new highlight
+func NewID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
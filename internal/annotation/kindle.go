@@ -0,0 +1,197 @@
+package annotation
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clipping is a highlight or note read from another reader, not yet
+// located in a local book
+type Clipping struct {
+	BookTitle    string
+	Author       string
+	Text         string
+	Note         string
+	Color        string
+	Created      time.Time
+	Chapter      int // Spine index when the source records it, otherwise -1
+	ChapterTitle string
+	Location     string // Position as given by the source, for reports
+}
+
+// kindleSeparator ends every entry of a Kindle clippings file
+const kindleSeparator = "=========="
+
+var (
+	// "- Your Highlight on page 12 | Location 180-182 | Added on Monday, 1 January 2020 10:00:00"
+	kindleKind     = regexp.MustCompile(`(?i)^-\s*(?:your\s+)?(highlight|note|bookmark)`)
+	kindleLocation = regexp.MustCompile(`(?i)(?:location|loc\.?)\s+([0-9]+)(?:-([0-9]+))?`)
+	kindleAdded    = regexp.MustCompile(`(?i)added on\s+(.+)$`)
+	kindleAuthor   = regexp.MustCompile(`^(.*)\(([^()]*)\)\s*$`)
+)
+
+// Date layouts used by Kindle firmware in English
+var kindleDateLayouts = []string{
+	"Monday, January 2, 2006 3:04:05 PM",
+	"Monday, 2 January 2006 15:04:05",
+	"Monday, January 2, 2006, 3:04 PM",
+	"Monday, 2 January 2006 15:04",
+}
+
+type kindleEntry struct {
+	title, author string
+	kind          string
+	start, end    int
+	location      string
+	added         time.Time
+	text          string
+}
+
+// ParseKindleClippings reads a Kindle "My Clippings.txt" file. Notes are
+// attached to the highlight they were written on; bookmarks are skipped.
+func ParseKindleClippings(r io.Reader) ([]Clipping, error) {
+	var entries []kindleEntry
+	var block []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == kindleSeparator {
+			if entry, ok := parseKindleEntry(block); ok {
+				entries = append(entries, entry)
+			}
+			block = nil
+			continue
+		}
+		block = append(block, text)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read clippings: %w", err)
+	}
+	if entry, ok := parseKindleEntry(block); ok {
+		entries = append(entries, entry)
+	}
+
+	var clippings []Clipping
+	index := make(map[string][]int) // Book title to clippings of that book
+	for _, e := range entries {
+		switch e.kind {
+		case "highlight":
+			index[e.title] = append(index[e.title], len(clippings))
+			clippings = append(clippings, Clipping{
+				BookTitle: e.title,
+				Author:    e.author,
+				Text:      e.text,
+				Color:     Colors[0],
+				Created:   e.added,
+				Chapter:   -1,
+				Location:  e.location,
+			})
+		case "note":
+			if i, ok := kindleNoteTarget(clippings, index[e.title], e); ok {
+				clippings[i].Note = joinNote(clippings[i].Note, e.text)
+			}
+		}
+	}
+	return clippings, nil
+}
+
+// kindleNoteTarget finds the highlight a note belongs to: the last one of
+// the same book whose location range ends at or contains the note
+func kindleNoteTarget(clippings []Clipping, candidates []int, note kindleEntry) (int, bool) {
+	for i := len(candidates) - 1; i >= 0; i-- {
+		c := clippings[candidates[i]]
+		start, end := kindleRange(c.Location)
+		if note.start >= start && note.start <= end {
+			return candidates[i], true
+		}
+	}
+	return 0, false
+}
+
+func kindleRange(location string) (int, int) {
+	m := kindleLocation.FindStringSubmatch(location)
+	if m == nil {
+		return -1, -1
+	}
+	start, _ := strconv.Atoi(m[1])
+	end := start
+	if m[2] != "" {
+		end, _ = strconv.Atoi(m[2])
+		// Kindle shortens ranges such as 1180-82
+		if end < start {
+			digits := len(m[2])
+			base := start - start%pow10(digits)
+			end += base
+		}
+	}
+	return start, end
+}
+
+func pow10(n int) int {
+	result := 1
+	for ; n > 0; n-- {
+		result *= 10
+	}
+	return result
+}
+
+func parseKindleEntry(lines []string) (kindleEntry, bool) {
+	// Skip blank lines left between entries
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) < 2 {
+		return kindleEntry{}, false
+	}
+
+	var e kindleEntry
+	titleLine := strings.TrimSpace(strings.TrimPrefix(lines[0], "\ufeff"))
+	e.title = titleLine
+	if m := kindleAuthor.FindStringSubmatch(titleLine); m != nil {
+		e.title = strings.TrimSpace(m[1])
+		e.author = strings.TrimSpace(m[2])
+	}
+
+	info := strings.TrimSpace(lines[1])
+	m := kindleKind.FindStringSubmatch(info)
+	if m == nil {
+		return kindleEntry{}, false
+	}
+	e.kind = strings.ToLower(m[1])
+	e.location = info
+	if loc := kindleLocation.FindString(info); loc != "" {
+		e.location = loc
+	}
+	e.start, e.end = kindleRange(info)
+	if m := kindleAdded.FindStringSubmatch(info); m != nil {
+		for _, layout := range kindleDateLayouts {
+			if t, err := time.ParseInLocation(layout, strings.TrimSpace(m[1]), time.Local); err == nil {
+				e.added = t
+				break
+			}
+		}
+	}
+	if e.added.IsZero() {
+		e.added = time.Now()
+	}
+
+	e.text = strings.TrimSpace(strings.Join(lines[2:], "\n"))
+	if e.text == "" && e.kind != "bookmark" {
+		return kindleEntry{}, false
+	}
+	return e, true
+}
+
+func joinNote(existing, note string) string {
+	if existing == "" {
+		return note
+	}
+	return existing + "\n\n" + note
+}
@@ -0,0 +1,491 @@
+package annotation
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// KOReader writes datetimes in local time without a zone
+const koreaderDate = "2006-01-02 15:04:05"
+
+// docFragment finds the 1-based spine position in a KOReader xpointer
+// such as /body/DocFragment[3]/body/p[2]/text().15
+var docFragment = regexp.MustCompile(`DocFragment\[([0-9]+)\]`)
+
+// KOReader drawer styles mapped to the reader's colors
+var koreaderColors = map[string]string{
+	"lighten":    "yellow",
+	"underscore": "blue",
+	"strikeout":  "red",
+	"invert":     "magenta",
+}
+
+// ParseKOReader reads a KOReader sidecar file (book.sdr/metadata.epub.lua).
+// Both the current "annotations" list and the older "highlight" and
+// "bookmarks" tables are understood.
+func ParseKOReader(r io.Reader) ([]Clipping, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KOReader metadata: %w", err)
+	}
+	value, err := parseLua(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse KOReader metadata: %w", err)
+	}
+	root, ok := value.(luaTable)
+	if !ok {
+		return nil, fmt.Errorf("KOReader metadata is not a table")
+	}
+
+	title, author := koreaderBook(root)
+	var clippings []Clipping
+	add := func(entry luaTable, note string) {
+		text := strings.TrimSpace(entry.str("text"))
+		if text == "" {
+			return
+		}
+		c := Clipping{
+			BookTitle:    title,
+			Author:       author,
+			Text:         text,
+			Note:         strings.TrimSpace(note),
+			Color:        koreaderColor(entry),
+			Chapter:      -1,
+			ChapterTitle: entry.str("chapter"),
+			Location:     entry.str("pos0"),
+			Created:      time.Now(),
+		}
+		if m := docFragment.FindStringSubmatch(entry.str("pos0")); m != nil {
+			if n, err := strconv.Atoi(m[1]); err == nil {
+				c.Chapter = n - 1
+			}
+		}
+		if t, err := time.ParseInLocation(koreaderDate, entry.str("datetime"), time.Local); err == nil {
+			c.Created = t
+		}
+		if c.Location == "" {
+			c.Location = entry.str("page")
+		}
+		clippings = append(clippings, c)
+	}
+
+	if annotations, ok := root["annotations"].(luaTable); ok {
+		for _, entry := range annotations.list() {
+			// Entries without pos0 are page bookmarks
+			if entry.str("pos0") != "" {
+				add(entry, entry.str("note"))
+			}
+		}
+		return clippings, nil
+	}
+
+	// Older sidecars keep highlights per page and notes in bookmarks
+	notes := make(map[string]string)
+	if bookmarks, ok := root["bookmarks"].(luaTable); ok {
+		for _, b := range bookmarks.list() {
+			if b.str("highlighted") == "true" && b.str("pos0") != "" {
+				// Bookmarks store the note in "text" and the passage in "notes"
+				text := b.str("text")
+				if text != "" && text != b.str("notes") && !strings.HasPrefix(text, "Page ") {
+					notes[b.str("pos0")] = text
+				}
+			}
+		}
+	}
+	if highlights, ok := root["highlight"].(luaTable); ok {
+		for _, page := range highlights.list() {
+			for _, entry := range page.list() {
+				add(entry, notes[entry.str("pos0")])
+			}
+		}
+	}
+	return clippings, nil
+}
+
+func koreaderBook(root luaTable) (string, string) {
+	for _, key := range []string{"doc_props", "stats"} {
+		if props, ok := root[key].(luaTable); ok && props.str("title") != "" {
+			return props.str("title"), strings.ReplaceAll(props.str("authors"), "\n", ", ")
+		}
+	}
+	path := root.str("doc_path")
+	if path == "" {
+		return "", ""
+	}
+	base := path[strings.LastIndexAny(path, `/\`)+1:]
+	return strings.TrimSuffix(base, ".epub"), ""
+}
+
+func koreaderColor(entry luaTable) string {
+	if c := strings.ToLower(entry.str("color")); c != "" {
+		for _, name := range Colors {
+			if c == name {
+				return c
+			}
+		}
+		if c == "orange" {
+			return "yellow"
+		}
+		if c == "purple" {
+			return "magenta"
+		}
+	}
+	if c, ok := koreaderColors[entry.str("drawer")]; ok {
+		return c
+	}
+	return Colors[0]
+}
+
+// luaTable is a Lua table literal. Positional and numeric keys are stored
+// as their decimal string.
+type luaTable map[string]any
+
+// str returns a field as a string, formatting numbers and booleans
+func (t luaTable) str(key string) string {
+	switch v := t[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+// list returns the table values that are tables, ordered by numeric key
+func (t luaTable) list() []luaTable {
+	type item struct {
+		index float64
+		table luaTable
+	}
+	var items []item
+	for key, value := range t {
+		table, ok := value.(luaTable)
+		if !ok {
+			continue
+		}
+		index, err := strconv.ParseFloat(key, 64)
+		if err != nil {
+			continue
+		}
+		items = append(items, item{index, table})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].index < items[j].index })
+
+	result := make([]luaTable, len(items))
+	for i, it := range items {
+		result[i] = it.table
+	}
+	return result
+}
+
+// luaParser reads the subset of Lua used by serialised tables: an optional
+// leading "return", table constructors, strings, numbers and booleans
+type luaParser struct {
+	src string
+	pos int
+}
+
+func parseLua(src string) (any, error) {
+	p := &luaParser{src: src}
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], "return") {
+		p.pos += len("return")
+	}
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return value, nil
+}
+
+func (p *luaParser) errorf(format string, args ...any) error {
+	line := strings.Count(p.src[:p.pos], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips whitespace and comments
+func (p *luaParser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch {
+		case unicode.IsSpace(rune(p.src[p.pos])):
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "--[["):
+			end := strings.Index(p.src[p.pos:], "]]")
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end + 2
+			}
+		case strings.HasPrefix(p.src[p.pos:], "--"):
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end + 1
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *luaParser) value() (any, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("unexpected end of input")
+	}
+
+	switch c := p.src[p.pos]; {
+	case c == '{':
+		return p.table()
+	case c == '"' || c == '\'':
+		return p.quoted()
+	case c == '[' && (strings.HasPrefix(p.src[p.pos:], "[[") || strings.HasPrefix(p.src[p.pos:], "[=")):
+		return p.longString()
+	case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return p.number()
+	default:
+		word := p.identifier()
+		switch word {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "nil":
+			return nil, nil
+		}
+		return nil, p.errorf("unexpected %q", word)
+	}
+}
+
+func (p *luaParser) table() (luaTable, error) {
+	p.pos++ // {
+	t := make(luaTable)
+	next := 1
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated table")
+		}
+		if p.src[p.pos] == '}' {
+			p.pos++
+			return t, nil
+		}
+
+		var key string
+		switch {
+		case p.src[p.pos] == '[' && !strings.HasPrefix(p.src[p.pos:], "[[") && !strings.HasPrefix(p.src[p.pos:], "[="):
+			p.pos++
+			k, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if !p.consume(']') {
+				return nil, p.errorf("expected ]")
+			}
+			key = luaKey(k)
+			if err := p.expectEquals(); err != nil {
+				return nil, err
+			}
+		case isIdentStart(p.src[p.pos]) && p.keyFollows():
+			key = p.identifier()
+			if err := p.expectEquals(); err != nil {
+				return nil, err
+			}
+		default:
+			key = strconv.Itoa(next)
+			next++
+		}
+
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		t[key] = v
+
+		p.skipSpace()
+		if !p.consume(',') && !p.consume(';') {
+			p.skipSpace()
+			if p.pos < len(p.src) && p.src[p.pos] != '}' {
+				return nil, p.errorf("expected , or }")
+			}
+		}
+	}
+}
+
+// keyFollows reports whether the identifier at the cursor is a field name
+// followed by "=" rather than a value such as true
+func (p *luaParser) keyFollows() bool {
+	save := p.pos
+	defer func() { p.pos = save }()
+	p.identifier()
+	p.skipSpace()
+	return p.pos < len(p.src) && p.src[p.pos] == '=' && !strings.HasPrefix(p.src[p.pos:], "==")
+}
+
+func (p *luaParser) expectEquals() error {
+	p.skipSpace()
+	if !p.consume('=') {
+		return p.errorf("expected =")
+	}
+	return nil
+}
+
+func (p *luaParser) consume(c byte) bool {
+	if p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func (p *luaParser) identifier() string {
+	start := p.pos
+	for p.pos < len(p.src) && (isIdentStart(p.src[p.pos]) || p.src[p.pos] >= '0' && p.src[p.pos] <= '9') {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *luaParser) number() (float64, error) {
+	start := p.pos
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.src) && strings.IndexByte("0123456789.eExXabcdefABCDEF+-", p.src[p.pos]) >= 0 {
+		// A sign only belongs to the number right after an exponent
+		if (p.src[p.pos] == '+' || p.src[p.pos] == '-') && !strings.ContainsAny(p.src[p.pos-1:p.pos], "eE") {
+			break
+		}
+		p.pos++
+	}
+	text := p.src[start:p.pos]
+	if n, err := strconv.ParseFloat(text, 64); err == nil {
+		return n, nil
+	}
+	if n, err := strconv.ParseInt(text, 0, 64); err == nil {
+		return float64(n), nil
+	}
+	return 0, p.errorf("invalid number %q", text)
+}
+
+func (p *luaParser) quoted() (string, error) {
+	quote := p.src[p.pos]
+	p.pos++
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return sb.String(), nil
+		case c == '\\' && p.pos+1 < len(p.src):
+			p.pos++
+			p.escape(&sb)
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// escape decodes the escape sequence after a backslash
+func (p *luaParser) escape(sb *strings.Builder) {
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case 'n':
+		sb.WriteByte('\n')
+	case 't':
+		sb.WriteByte('\t')
+	case 'r':
+		sb.WriteByte('\r')
+	case 'a':
+		sb.WriteByte('\a')
+	case 'b':
+		sb.WriteByte('\b')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'v':
+		sb.WriteByte('\v')
+	case '\n':
+		sb.WriteByte('\n')
+	case 'x':
+		if p.pos+2 <= len(p.src) {
+			if n, err := strconv.ParseUint(p.src[p.pos:p.pos+2], 16, 8); err == nil {
+				sb.WriteByte(byte(n))
+				p.pos += 2
+			}
+		}
+	case 'z':
+		for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+			p.pos++
+		}
+	default:
+		if c >= '0' && c <= '9' {
+			// Up to three decimal digits give a byte value
+			end := p.pos - 1
+			for end < len(p.src) && end < p.pos+2 && p.src[end] >= '0' && p.src[end] <= '9' {
+				end++
+			}
+			n, _ := strconv.Atoi(p.src[p.pos-1 : end])
+			sb.WriteByte(byte(n))
+			p.pos = end
+			return
+		}
+		sb.WriteByte(c)
+	}
+}
+
+// longString reads a [[...]] or [==[...]==] string
+func (p *luaParser) longString() (string, error) {
+	start := p.pos
+	p.pos++
+	level := 0
+	for p.pos < len(p.src) && p.src[p.pos] == '=' {
+		level++
+		p.pos++
+	}
+	if !p.consume('[') {
+		p.pos = start
+		return "", p.errorf("invalid long string")
+	}
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(p.src[p.pos:], closing)
+	if end < 0 {
+		return "", p.errorf("unterminated long string")
+	}
+	text := strings.TrimPrefix(p.src[p.pos:p.pos+end], "\n")
+	p.pos += end + len(closing)
+	return text, nil
+}
+
+func luaKey(k any) string {
+	switch v := k.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(k)
+}
@@ -0,0 +1,204 @@
+package annotation
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/edfun317/ereader/internal/core"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// minTitleScore is the similarity below which a book is not a match
+	minTitleScore = 0.6
+	// anchorLength is the number of letters used to find the ends of a
+	// passage whose middle differs from the book
+	anchorLength = 24
+)
+
+// fold lowers case and removes diacritics from a single rune
+func fold(r rune) rune {
+	if r >= utf8.RuneSelf {
+		if base, _ := utf8.DecodeRuneInString(norm.NFD.String(string(r))); base != utf8.RuneError {
+			r = base
+		}
+	}
+	return unicode.ToLower(r)
+}
+
+// normalize keeps only the folded letters and digits of s
+func normalize(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(fold(r))
+		}
+	}
+	return sb.String()
+}
+
+// words splits s into folded words
+func words(s string) []string {
+	return strings.FieldsFunc(strings.Map(fold, s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// MatchScore rates how well a title and author from another reader
+// describe a book, from 0 to 1
+func MatchScore(title, author string, m core.BookMetadata) float64 {
+	bookTitle := normalize(m.Title)
+	wanted := normalize(title)
+	if bookTitle == "" || wanted == "" {
+		return 0
+	}
+
+	var score float64
+	switch {
+	case bookTitle == wanted:
+		score = 1
+	// Titles often gain or lose a subtitle or series name
+	case strings.HasPrefix(wanted, bookTitle) || strings.HasPrefix(bookTitle, wanted):
+		score = 0.85
+	default:
+		score = overlap(words(title), words(m.Title+" "+m.Subtitle))
+	}
+
+	if author == "" {
+		return score
+	}
+	names := m.Author
+	for _, c := range m.Creators {
+		names += " " + c.Name + " " + c.FileAs
+	}
+	if overlap(words(author), words(names)) > 0 {
+		return min(score+0.1, 1)
+	}
+	return score * 0.8
+}
+
+// overlap is the share of the words in a that also appear in b
+func overlap(a, b []string) float64 {
+	if len(a) == 0 {
+		return 0
+	}
+	set := make(map[string]bool, len(b))
+	for _, w := range b {
+		set[w] = true
+	}
+	found := 0
+	for _, w := range a {
+		if set[w] {
+			found++
+		}
+	}
+	return float64(found) / float64(len(a))
+}
+
+// BestMatch returns the index of the book that the title and author
+// describe, or -1 when none is close enough
+func BestMatch(title, author string, books []core.BookMetadata) int {
+	best, bestScore := -1, minTitleScore
+	for i, m := range books {
+		if score := MatchScore(title, author, m); score >= bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// chapterIndex maps the folded letters and digits of a chapter's text to
+// text offsets
+type chapterIndex struct {
+	text    string // Normalised text, one byte per letter for ASCII
+	offsets []int  // Text offset of the rune starting at each byte
+}
+
+func newChapterIndex(doc *core.Document) chapterIndex {
+	var sb strings.Builder
+	var offsets []int
+	offset := 0
+	for _, r := range core.DocumentText(doc) {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			folded := fold(r)
+			for i := 0; i < utf8.RuneLen(folded); i++ {
+				offsets = append(offsets, offset)
+			}
+			sb.WriteRune(folded)
+		}
+		offset++
+	}
+	return chapterIndex{text: sb.String(), offsets: offsets}
+}
+
+// locate finds a passage in the chapter and returns its text offsets.
+// Exact matches of the normalised text are tried first; otherwise the
+// passage is found by its first and last letters, which tolerates changes
+// in the middle such as hyphenation or a different edition.
+func (c chapterIndex) locate(passage string) (int, int, bool) {
+	needle := normalize(passage)
+	if needle == "" {
+		return 0, 0, false
+	}
+	if i := strings.Index(c.text, needle); i >= 0 {
+		return c.span(i, i+len(needle))
+	}
+	runes := []rune(needle)
+	if len(runes) < 2*anchorLength {
+		return 0, 0, false
+	}
+
+	head := string(runes[:anchorLength])
+	tail := string(runes[len(runes)-anchorLength:])
+	start := strings.Index(c.text, head)
+	if start < 0 {
+		return 0, 0, false
+	}
+	// The end must lie within a generous distance of the expected length
+	limit := min(start+len(needle)*3/2, len(c.text))
+	if end := strings.LastIndex(c.text[start:limit], tail); end >= 0 {
+		return c.span(start, start+end+len(tail))
+	}
+	return 0, 0, false
+}
+
+func (c chapterIndex) span(from, to int) (int, int, bool) {
+	return c.offsets[from], c.offsets[to-1] + 1, true
+}
+
+// Locator finds passages in the chapters of one book
+type Locator struct {
+	chapters []chapterIndex
+}
+
+// NewLocator indexes the text of the chapters of a book
+func NewLocator(docs []*core.Document) *Locator {
+	l := &Locator{}
+	for _, doc := range docs {
+		l.chapters = append(l.chapters, newChapterIndex(doc))
+	}
+	return l
+}
+
+// Locate returns the chapter and text offsets of a passage, looking in the
+// hinted chapter first when it is known
+func (l *Locator) Locate(passage string, hint int) (chapter, start, end int, ok bool) {
+	if hint >= 0 && hint < len(l.chapters) {
+		if start, end, ok := l.chapters[hint].locate(passage); ok {
+			return hint, start, end, true
+		}
+	}
+	for i, c := range l.chapters {
+		if i == hint {
+			continue
+		}
+		if start, end, ok := c.locate(passage); ok {
+			return i, start, end, true
+		}
+	}
+	return 0, 0, 0, false
+}
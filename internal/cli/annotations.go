@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/edfun317/ereader/internal/annotation"
+	"github.com/edfun317/ereader/internal/core"
+	"github.com/edfun317/ereader/internal/format/epub"
+	"github.com/edfun317/ereader/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportFormat string
+	exportOutput string
+
+	annotationsCmd = &cobra.Command{
+		Use:   "annotations",
+		Short: "Manage highlights and notes",
+	}
+
+	exportCmd = &cobra.Command{
+		Use:   "export [book.epub]",
+		Short: "Export highlights and notes",
+		Long: `Export the highlights and notes of one book, or of every annotated book
+when no file is given, as Markdown with front matter, JSON or Readwise CSV.
+
+When --output names an existing directory, each book is written to its own file.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runExport,
+	}
+)
+
+func init() {
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", annotation.FormatMarkdown,
+		"Export format: markdown, json or csv")
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "",
+		"Output file or directory (default standard output)")
+	annotationsCmd.AddCommand(exportCmd)
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	format := strings.ToLower(exportFormat)
+	switch format {
+	case "md":
+		format = annotation.FormatMarkdown
+	case annotation.FormatMarkdown, annotation.FormatJSON, annotation.FormatCSV:
+	default:
+		return fmt.Errorf("unknown export format %q", exportFormat)
+	}
+
+	store, err := storage.OpenDefault()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	books, err := exportBooks(store, args)
+	if err != nil {
+		return err
+	}
+
+	if exportOutput == "" || exportOutput == "-" {
+		return annotation.Write(cmd.OutOrStdout(), format, books)
+	}
+
+	if info, err := os.Stat(exportOutput); err == nil && info.IsDir() {
+		for _, book := range books {
+			path := filepath.Join(exportOutput, fileName(book)+annotation.Extension(format))
+			if err := writeFile(path, format, []annotation.ExportBook{book}); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "Wrote %s\n", path)
+		}
+		return nil
+	}
+	return writeFile(exportOutput, format, books)
+}
+
+// exportBooks gathers the annotated books to export, ordered by title
+func exportBooks(store core.Storage, args []string) ([]annotation.ExportBook, error) {
+	var all []core.BookAnnotations
+	if len(args) == 1 {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %w", err)
+		}
+		annotations, err := store.Annotations(bookRef(path, bookMetadata(path)))
+		if err != nil {
+			return nil, err
+		}
+		if annotations == nil {
+			return nil, fmt.Errorf("no annotations for %s", args[0])
+		}
+		all = append(all, *annotations)
+	} else {
+		var err error
+		if all, err = store.AllAnnotations(); err != nil {
+			return nil, err
+		}
+	}
+
+	books := make([]annotation.ExportBook, 0, len(all))
+	for _, annotations := range all {
+		core.SortHighlights(annotations.Highlights)
+		books = append(books, annotation.ExportBook{
+			Metadata:    bookMetadata(annotations.FilePath),
+			Annotations: annotations,
+		})
+	}
+	sort.Slice(books, func(i, j int) bool {
+		return strings.ToLower(fileName(books[i])) < strings.ToLower(fileName(books[j]))
+	})
+	return books, nil
+}
+
+// bookRef names the book file at path in storage; books that cannot be
+// read are named by their path alone
+func bookRef(path string, metadata core.BookMetadata) core.BookRef {
+	id, _ := core.IdentifyBook(path, metadata)
+	return core.BookRef{ID: id, Path: path}
+}
+
+// bookMetadata reads a book's metadata, which is empty when the file can
+// no longer be opened
+func bookMetadata(path string) core.BookMetadata {
+	reader := epub.NewEPUBReader(epub.WithPrefetch(0))
+	if _, err := reader.Open(path); err != nil {
+		return core.BookMetadata{}
+	}
+	defer reader.Close()
+	return reader.GetMetadata()
+}
+
+func writeFile(path, format string, books []annotation.ExportBook) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if err := annotation.Write(file, format, books); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// fileName derives a file name from the book title
+func fileName(book annotation.ExportBook) string {
+	title := book.Metadata.Title
+	if title == "" {
+		title = book.Annotations.Title
+	}
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(book.Annotations.FilePath), filepath.Ext(book.Annotations.FilePath))
+	}
+
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case strings.ContainsRune(`/\:*?"<>|`, r), unicode.IsControl(r):
+			return '-'
+		}
+		return r
+	}, title)
+	return strings.TrimSpace(name)
+}
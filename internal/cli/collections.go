@@ -0,0 +1,227 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/edfun317/ereader/internal/library"
+	"github.com/spf13/cobra"
+)
+
+var (
+	collectionDelete bool
+
+	libraryTagCmd = &cobra.Command{
+		Use:   "tag <book> <tag>...",
+		Short: "Add tags to a book",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return tagBook(cmd, args[0], args[1:], true)
+		},
+	}
+
+	libraryUntagCmd = &cobra.Command{
+		Use:   "untag <book> <tag>...",
+		Short: "Remove tags from a book",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return tagBook(cmd, args[0], args[1:], false)
+		},
+	}
+
+	libraryTagsCmd = &cobra.Command{
+		Use:   "tags",
+		Short: "List the tags in use",
+		Args:  cobra.NoArgs,
+		RunE:  runLibraryTags,
+	}
+
+	libraryCollectCmd = &cobra.Command{
+		Use:   "collect <collection> <book>...",
+		Short: "Add books to a collection, creating it if needed",
+		Long: `Add books to the end of a collection, a named reading list kept with the
+library. The collection is created when it does not exist yet.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return collectBooks(cmd, args[0], args[1:], true)
+		},
+	}
+
+	libraryUncollectCmd = &cobra.Command{
+		Use:   "uncollect <collection> <book>...",
+		Short: "Take books out of a collection",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return collectBooks(cmd, args[0], args[1:], false)
+		},
+	}
+
+	libraryCollectionsCmd = &cobra.Command{
+		Use:   "collections [name]",
+		Short: "List collections, or the books of one",
+		Long: `List the collections with the number of books in each, or the books of
+one collection in reading order.
+
+Besides the collections made with "ereader library collect", books are
+sorted automatically into Currently Reading, Unread and Finished by how
+far they have been read.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runLibraryCollections,
+	}
+)
+
+func init() {
+	libraryCollectionsCmd.Flags().BoolVar(&collectionDelete, "delete", false,
+		"Delete the collection, leaving its books in the library")
+	libraryCmd.AddCommand(libraryTagCmd, libraryUntagCmd, libraryTagsCmd,
+		libraryCollectCmd, libraryUncollectCmd, libraryCollectionsCmd)
+}
+
+// tagBook adds tags to a book or, when add is false, removes them
+func tagBook(cmd *cobra.Command, ref string, tags []string, add bool) error {
+	cmd.SilenceUsage = true
+	var book library.Book
+	var changed int
+	err := library.Update(func(c *library.Catalog) error {
+		b, err := c.Find(ref)
+		if err != nil {
+			return err
+		}
+		if add {
+			changed = b.Tag(tags...)
+		} else {
+			changed = b.Untag(tags...)
+		}
+		book = *b
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	switch {
+	case changed == 0 && add:
+		fmt.Fprintf(out, "%s already has those tags\n", book.Title)
+	case changed == 0:
+		fmt.Fprintf(out, "%s has none of those tags\n", book.Title)
+	case len(book.Tags) == 0:
+		fmt.Fprintf(out, "%s has no tags now\n", book.Title)
+	default:
+		fmt.Fprintf(out, "%s is tagged %s\n", book.Title, strings.Join(book.Tags, ", "))
+	}
+	return nil
+}
+
+func runLibraryTags(cmd *cobra.Command, args []string) error {
+	catalog, err := library.Load()
+	if err != nil {
+		return err
+	}
+	counts := catalog.Tags()
+	out := cmd.OutOrStdout()
+	if len(counts) == 0 {
+		fmt.Fprintln(out, `No tags yet; add some with "ereader library tag <book> <tag>..."`)
+		return nil
+	}
+
+	tags := make([]string, 0, len(counts))
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return strings.ToLower(tags[i]) < strings.ToLower(tags[j]) })
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, tag := range tags {
+		fmt.Fprintf(w, "%s\t%d\n", tag, counts[tag])
+	}
+	w.Flush()
+	return nil
+}
+
+// collectBooks adds books to a collection or, when add is false, takes
+// them out of it
+func collectBooks(cmd *cobra.Command, name string, refs []string, add bool) error {
+	cmd.SilenceUsage = true
+	var changed int
+	err := library.Update(func(c *library.Catalog) error {
+		var paths []string
+		for _, ref := range refs {
+			b, err := c.Find(ref)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, b.Path)
+		}
+		var err error
+		if add {
+			changed, err = c.Collect(name, paths...)
+		} else {
+			changed, err = c.Uncollect(name, paths...)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if add {
+		fmt.Fprintf(cmd.OutOrStdout(), "Added %d books to %s\n", changed, name)
+	} else {
+		fmt.Fprintf(cmd.OutOrStdout(), "Took %d books out of %s\n", changed, name)
+	}
+	return nil
+}
+
+func runLibraryCollections(cmd *cobra.Command, args []string) error {
+	out := cmd.OutOrStdout()
+	if collectionDelete {
+		if len(args) == 0 {
+			return fmt.Errorf("give the collection to delete")
+		}
+		cmd.SilenceUsage = true
+		deleted := false
+		err := library.Update(func(c *library.Catalog) error {
+			deleted = c.DeleteCollection(args[0])
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("no collection named %q", args[0])
+		}
+		fmt.Fprintf(out, "Deleted %s\n", args[0])
+		return nil
+	}
+
+	cmd.SilenceUsage = true
+	catalog, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		books, err := catalog.Select(library.Filter{Collection: args[0]})
+		if err != nil {
+			return err
+		}
+		if len(books) == 0 {
+			fmt.Fprintln(out, "No books")
+			return nil
+		}
+		printBooks(out, books)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, shelf := range library.Shelves {
+		books, _ := catalog.Select(library.Filter{Collection: shelf})
+		fmt.Fprintf(w, "%s\t%d\t(automatic)\n", shelf, len(books))
+	}
+	for _, collection := range catalog.Collections {
+		fmt.Fprintf(w, "%s\t%d\n", collection.Name, len(collection.Books))
+	}
+	w.Flush()
+	return nil
+}
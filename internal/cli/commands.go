@@ -3,34 +3,103 @@ package cli
 import (
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/edfun317/ereader/internal/color"
+	"github.com/edfun317/ereader/internal/format/epub"
 	"github.com/edfun317/ereader/internal/reader"
+	"github.com/edfun317/ereader/internal/storage"
+	viewer "github.com/edfun317/ereader/internal/viewer/cli"
 	"github.com/spf13/cobra"
 )
 
 var (
 	schemeName string
+	bookFile   string
+	margin     int
+	maxWidth   int
 	rootCmd    = &cobra.Command{
-		Use:   "ereader",
+		Use:   "ereader [book.epub]",
 		Short: "A text reader with color support",
-		Long:  `A command line text reader that supports colored output and various text formats.`,
+		Long: `A command line text reader that supports colored output and various text formats.
+
+Without a book, the library added with "ereader library scan" is shown to
+pick one from.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: openBook,
 	}
 )
 
+// legacyFlags were accepted with a single dash before the reader used cobra
+var legacyFlags = []string{"file", "margin", "max-width", "scheme"}
+
 // InitCommands initializes all CLI commands
 func InitCommands() *cobra.Command {
 
 	// Add persistent flags
 	rootCmd.PersistentFlags().StringVarP(&schemeName, "scheme", "s", "default", "Use predefined color scheme")
+	rootCmd.Flags().StringVarP(&bookFile, "file", "f", "", "Path to EPUB file")
+	rootCmd.Flags().IntVar(&margin, "margin", 2, "Minimum blank columns on each side of the text")
+	rootCmd.Flags().IntVar(&maxWidth, "max-width", 80, "Maximum width of the text column (0 for the full terminal)")
 
 	// Add commands
 	rootCmd.AddCommand(readCmd)
 	rootCmd.AddCommand(schemesCmd)
+	rootCmd.AddCommand(annotationsCmd)
+	rootCmd.AddCommand(storageCmd)
+	rootCmd.AddCommand(syncCmd)
+	rootCmd.AddCommand(libraryCmd)
 
 	return rootCmd
 }
 
+// NormalizeArgs rewrites the single-dash long flags of earlier versions,
+// such as -file, to the double-dash form
+func NormalizeArgs(args []string) []string {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = arg
+		for _, name := range legacyFlags {
+			if arg == "-"+name || strings.HasPrefix(arg, "-"+name+"=") {
+				result[i] = "-" + arg
+				break
+			}
+		}
+	}
+	return result
+}
+
+// openBook opens the EPUB given as argument or with --file in the viewer,
+// or the library browser when no book is given
+func openBook(cmd *cobra.Command, args []string) error {
+	path := bookFile
+	if len(args) == 1 {
+		path = args[0]
+	}
+
+	cmd.SilenceUsage = true
+	store, err := storage.OpenDefault()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	read := func(path string) error {
+		reader := epub.NewEPUBReader()
+		v := viewer.NewCLIViewer(reader,
+			viewer.WithMargin(margin),
+			viewer.WithMaxWidth(maxWidth),
+			viewer.WithColorScheme(schemeName),
+			viewer.WithStorage(store),
+		)
+		return v.Start(path)
+	}
+	if path == "" {
+		return viewer.NewBrowser(loadCatalog, read).Run()
+	}
+	return read(path)
+}
+
 var readCmd = &cobra.Command{
 	Use:   "read [filepath]",
 	Short: "Read a text file",
@@ -0,0 +1,267 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/edfun317/ereader/internal/annotation"
+	"github.com/edfun317/ereader/internal/core"
+	"github.com/edfun317/ereader/internal/format/epub"
+	"github.com/edfun317/ereader/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	importBooks  []string
+	importDryRun bool
+
+	importCmd = &cobra.Command{
+		Use:   "import <file>...",
+		Short: "Import highlights from Kindle or KOReader",
+		Long: `Import highlights and notes from a Kindle "My Clippings.txt" file or a
+KOReader sidecar (book.sdr/metadata.epub.lua).
+
+Clippings are matched to books by title and author, and to passages by
+comparing their text with the chapters. Books are looked for among those
+given with --book (files or directories), the books that already have
+annotations, and the book next to a KOReader sidecar.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: runImport,
+	}
+)
+
+func init() {
+	importCmd.Flags().StringArrayVarP(&importBooks, "book", "b", nil,
+		"EPUB file or directory of EPUB files to match against (repeatable)")
+	importCmd.Flags().BoolVarP(&importDryRun, "dry-run", "n", false,
+		"Report what would be imported without saving")
+	annotationsCmd.AddCommand(importCmd)
+}
+
+// candidateBook is a local book clippings may belong to
+type candidateBook struct {
+	path     string
+	metadata core.BookMetadata
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	out := cmd.OutOrStdout()
+
+	store, err := storage.OpenDefault()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	annotated, err := store.AllAnnotations()
+	if err != nil {
+		return err
+	}
+
+	var clippings []annotation.Clipping
+	paths := append([]string{}, importBooks...)
+	for _, a := range annotated {
+		paths = append(paths, a.FilePath)
+	}
+	for _, arg := range args {
+		parsed, err := parseClippings(arg)
+		if err != nil {
+			return err
+		}
+		clippings = append(clippings, parsed...)
+		if book, ok := sidecarBook(arg); ok {
+			paths = append(paths, book)
+		}
+	}
+
+	books, err := findBooks(paths)
+	if err != nil {
+		return err
+	}
+	metadata := make([]core.BookMetadata, len(books))
+	for i, b := range books {
+		metadata[i] = b.metadata
+	}
+
+	// Group the clippings by the book they match
+	byBook := make(map[int][]annotation.Clipping)
+	unmatched := make(map[string]int)
+	for _, c := range clippings {
+		index := annotation.BestMatch(c.BookTitle, c.Author, metadata)
+		if index < 0 {
+			unmatched[c.BookTitle]++
+			continue
+		}
+		byBook[index] = append(byBook[index], c)
+	}
+
+	imported, duplicates, missing := 0, 0, 0
+	for index, bookClippings := range byBook {
+		book := books[index]
+		locator, titles, err := bookLocator(book.path)
+		if err != nil {
+			return err
+		}
+
+		ref := bookRef(book.path, book.metadata)
+		entry := core.BookAnnotations{}
+		if saved, err := store.Annotations(ref); err != nil {
+			return err
+		} else if saved != nil {
+			entry = *saved
+		}
+		entry.Title = book.metadata.Title
+		entry.Author = book.metadata.Author
+
+		for _, c := range bookClippings {
+			chapter, start, end, ok := locator.Locate(c.Text, c.Chapter)
+			if !ok {
+				missing++
+				fmt.Fprintf(out, "Not found in %q: %s\n", book.metadata.Title, excerpt(c.Text))
+				continue
+			}
+			if c.ChapterTitle == "" {
+				c.ChapterTitle = titles[chapter]
+			}
+			h := core.Highlight{
+				ID:           annotation.NewID(),
+				Chapter:      chapter,
+				ChapterTitle: c.ChapterTitle,
+				Start:        start,
+				End:          end,
+				Text:         c.Text,
+				Color:        c.Color,
+				Note:         c.Note,
+				Created:      c.Created,
+				Updated:      time.Now(),
+			}
+			if entry.Add(h) {
+				imported++
+			} else {
+				duplicates++
+			}
+		}
+		if !importDryRun && len(entry.Highlights) > 0 {
+			if _, err := store.SaveAnnotations(ref, entry, time.Time{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for title, n := range unmatched {
+		fmt.Fprintf(out, "No local book matches %q (%d highlights)\n", title, n)
+	}
+	fmt.Fprintf(out, "Imported %d highlights, %d already present, %d not found in their book\n",
+		imported, duplicates, missing)
+	return nil
+}
+
+// parseClippings reads a Kindle or KOReader file, chosen by its name
+func parseClippings(path string) ([]annotation.Clipping, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var parse func(io.Reader) ([]annotation.Clipping, error)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".lua":
+		parse = annotation.ParseKOReader
+	case ".txt":
+		parse = annotation.ParseKindleClippings
+	default:
+		return nil, fmt.Errorf("unknown clippings format: %s", path)
+	}
+
+	clippings, err := parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return clippings, nil
+}
+
+// sidecarBook returns the book a KOReader sidecar file belongs to
+func sidecarBook(path string) (string, bool) {
+	dir := filepath.Dir(path)
+	if filepath.Ext(dir) != ".sdr" {
+		return "", false
+	}
+	book := strings.TrimSuffix(dir, ".sdr") + ".epub"
+	if _, err := os.Stat(book); err != nil {
+		return "", false
+	}
+	return book, true
+}
+
+// findBooks reads the metadata of the EPUB files among paths, descending
+// into directories. Books that cannot be opened are skipped.
+func findBooks(paths []string) ([]candidateBook, error) {
+	var books []candidateBook
+	seen := make(map[string]bool)
+
+	add := func(path string) {
+		abs, err := filepath.Abs(path)
+		if err != nil || seen[abs] {
+			return
+		}
+		seen[abs] = true
+		if m := bookMetadata(abs); m.Title != "" {
+			books = append(books, candidateBook{path: abs, metadata: m})
+		}
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			add(path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && strings.EqualFold(filepath.Ext(p), ".epub") {
+				add(p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", path, err)
+		}
+	}
+	return books, nil
+}
+
+// bookLocator indexes the chapters of a book for finding passages and
+// returns the chapter titles alongside
+func bookLocator(path string) (*annotation.Locator, []string, error) {
+	reader := epub.NewEPUBReader(epub.WithPrefetch(0))
+	if _, err := reader.Open(path); err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer reader.Close()
+
+	docs := make([]*core.Document, reader.GetTotalChapters())
+	titles := make([]string, len(docs))
+	for i := range docs {
+		chapter, err := reader.GetChapter(i)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		docs[i] = chapter.Document
+		titles[i] = chapter.Title
+	}
+	return annotation.NewLocator(docs), titles, nil
+}
+
+func excerpt(text string) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) > 60 {
+		return string(runes[:60]) + "…"
+	}
+	return string(runes)
+}
@@ -0,0 +1,460 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/edfun317/ereader/internal/config"
+	"github.com/edfun317/ereader/internal/core"
+	"github.com/edfun317/ereader/internal/format/epub"
+	"github.com/edfun317/ereader/internal/library"
+	"github.com/edfun317/ereader/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+// bookExtensions are the file types the library catalogs
+var bookExtensions = []string{".epub"}
+
+var (
+	librarySort       string
+	libraryTag        string
+	libraryCollection string
+	removeMissing     bool
+	libraryForget     bool
+
+	libraryCmd = &cobra.Command{
+		Use:   "library",
+		Short: "Catalog the books in your reading directories",
+		Long: `Keep a catalog of the books found in the directories added with
+"ereader library scan", with their metadata, cover, size, when they were
+added and last opened, and how far they have been read.
+
+Rescanning picks up new and changed files, follows books that were moved
+to another place in the directories and marks those that are gone as
+missing. Books are referred to by path, by a prefix of their hash or by
+words of their title or author.
+
+Books can be tagged and gathered into collections; see "ereader library
+tag" and "ereader library collect".`,
+		Args: cobra.NoArgs,
+		RunE: runLibrary,
+	}
+
+	libraryScanCmd = &cobra.Command{
+		Use:   "scan [dir...]",
+		Short: "Add directories to the library and rescan it",
+		RunE:  runLibraryScan,
+	}
+
+	libraryListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the books in the library",
+		Long: `List the books in the library. With --collection, only the books of a
+collection are listed, in reading order unless --sort is given; the
+automatic collections Currently Reading, Unread and Finished work too.`,
+		Args: cobra.NoArgs,
+		RunE: runLibraryList,
+	}
+
+	librarySearchCmd = &cobra.Command{
+		Use:   "search <query>",
+		Short: "Find books by title, author, series, subject or file name",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  runLibrarySearch,
+	}
+
+	libraryInfoCmd = &cobra.Command{
+		Use:   "info <book>",
+		Short: "Show the details of a book",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  runLibraryInfo,
+	}
+
+	libraryRemoveCmd = &cobra.Command{
+		Use:   "remove <book>...",
+		Short: "Drop books from the library, leaving the files alone",
+		Long: `Drop books from the catalog. The files themselves are not deleted, so a
+book in a scanned directory comes back at the next scan.
+
+With --missing, every book whose file was gone at the last scan is dropped.
+With --dir, a directory is no longer scanned and its books are dropped.`,
+		RunE: runLibraryRemove,
+	}
+)
+
+func init() {
+	libraryListCmd.Flags().StringVar(&librarySort, "sort", "title",
+		"Order of the books: title, author, added, opened or progress")
+	for _, cmd := range []*cobra.Command{libraryListCmd, librarySearchCmd} {
+		cmd.Flags().StringVarP(&libraryTag, "tag", "t", "", "Only list books with this tag")
+		cmd.Flags().StringVarP(&libraryCollection, "collection", "c", "", "Only list books in this collection")
+	}
+	libraryRemoveCmd.Flags().BoolVar(&removeMissing, "missing", false, "Drop all books whose file is missing")
+	libraryRemoveCmd.Flags().BoolVar(&libraryForget, "dir", false, "Stop scanning the given directories and drop their books")
+	libraryCmd.AddCommand(libraryScanCmd, libraryListCmd, librarySearchCmd, libraryInfoCmd, libraryRemoveCmd)
+}
+
+func runLibrary(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	catalog, err := library.Load()
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	if len(cfg.Library) == 0 {
+		fmt.Fprintln(out, `No library directories; add one with "ereader library scan <dir>"`)
+		return nil
+	}
+	fmt.Fprintln(out, "Directories:")
+	for _, dir := range cfg.Library {
+		fmt.Fprintf(out, "  %s\n", dir)
+	}
+	missing := 0
+	for _, b := range catalog.Books {
+		if b.Missing {
+			missing++
+		}
+	}
+	fmt.Fprintf(out, "Books: %d", len(catalog.Books))
+	if missing > 0 {
+		fmt.Fprintf(out, " (%d missing)", missing)
+	}
+	fmt.Fprintln(out)
+	return nil
+}
+
+func runLibraryScan(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	added := false
+	for _, arg := range args {
+		dir, err := filepath.Abs(arg)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path: %w", err)
+		}
+		if !slices.Contains(cfg.Library, dir) {
+			cfg.Library = append(cfg.Library, dir)
+			added = true
+		}
+	}
+	if len(cfg.Library) == 0 {
+		return errors.New(`no library directories; give one to scan, as in "ereader library scan ~/Books"`)
+	}
+
+	cmd.SilenceUsage = true
+	cache, err := config.CacheDir()
+	if err != nil {
+		return err
+	}
+	scanner := &library.Scanner{
+		Extensions: bookExtensions,
+		NewReader:  func(string) core.BookReader { return epub.NewEPUBReader() },
+		CoverDir:   filepath.Join(cache, "covers"),
+	}
+
+	var result library.ScanResult
+	err = library.Update(func(c *library.Catalog) error {
+		if result, err = scanner.Scan(c, cfg.Library); err != nil {
+			return err
+		}
+		return refresh(c)
+	})
+	if err != nil {
+		return err
+	}
+	if added {
+		if err := cfg.Save(); err != nil {
+			return err
+		}
+	}
+
+	out := cmd.OutOrStdout()
+	for _, err := range result.Errors {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %v\n", err)
+	}
+	fmt.Fprintf(out, "Scanned %d directories: %d added, %d updated, %d moved, %d missing, %d unchanged\n",
+		len(cfg.Library), result.Added, result.Updated, result.Moved, result.Missing, result.Unchanged)
+	return nil
+}
+
+// refresh brings the last opened dates and progress of the catalog up to
+// date with the reading state
+func refresh(c *library.Catalog) error {
+	store, err := storage.OpenLocal()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return c.Refresh(store)
+}
+
+// loadCatalog loads the catalog with current reading progress
+func loadCatalog() (*library.Catalog, error) {
+	catalog, err := library.Load()
+	if err != nil {
+		return nil, err
+	}
+	if err := refresh(catalog); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+func runLibraryList(cmd *cobra.Command, args []string) error {
+	less, err := bookOrder(librarySort)
+	if err != nil {
+		return err
+	}
+	cmd.SilenceUsage = true
+	catalog, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+	if len(catalog.Books) == 0 {
+		fmt.Fprintln(cmd.OutOrStdout(), `The library is empty; add books with "ereader library scan <dir>"`)
+		return nil
+	}
+	books, err := catalog.Select(library.Filter{Tag: libraryTag, Collection: libraryCollection})
+	if err != nil {
+		return err
+	}
+	if len(books) == 0 {
+		fmt.Fprintln(cmd.OutOrStdout(), "No books found")
+		return nil
+	}
+	// A collection keeps its reading order unless asked otherwise
+	if catalog.Collection(libraryCollection) == nil || cmd.Flags().Changed("sort") {
+		sort.SliceStable(books, func(i, j int) bool { return less(books[i], books[j]) })
+	}
+	printBooks(cmd.OutOrStdout(), books)
+	return nil
+}
+
+// bookOrder returns the comparison for a --sort value
+func bookOrder(name string) (func(a, b library.Book) bool, error) {
+	switch name {
+	case "title":
+		return func(a, b library.Book) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) }, nil
+	case "author":
+		return func(a, b library.Book) bool {
+			if x, y := strings.ToLower(a.Author), strings.ToLower(b.Author); x != y {
+				return x < y
+			}
+			return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+		}, nil
+	case "added":
+		return func(a, b library.Book) bool { return a.Added.After(b.Added) }, nil
+	case "opened":
+		return func(a, b library.Book) bool { return a.LastOpened.After(b.LastOpened) }, nil
+	case "progress":
+		return func(a, b library.Book) bool { return a.Progress > b.Progress }, nil
+	}
+	return nil, fmt.Errorf("unknown sort order %q (available: title, author, added, opened, progress)", name)
+}
+
+func runLibrarySearch(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	catalog, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+	selected, err := catalog.Select(library.Filter{Tag: libraryTag, Collection: libraryCollection})
+	if err != nil {
+		return err
+	}
+	inFilter := make(map[string]bool, len(selected))
+	for _, b := range selected {
+		inFilter[b.Path] = true
+	}
+	var books []library.Book
+	for _, b := range catalog.Search(strings.Join(args, " ")) {
+		if inFilter[b.Path] {
+			books = append(books, b)
+		}
+	}
+	if len(books) == 0 {
+		fmt.Fprintln(cmd.OutOrStdout(), "No books found")
+		return nil
+	}
+	printBooks(cmd.OutOrStdout(), books)
+	return nil
+}
+
+// printBooks writes a table of books
+func printBooks(out io.Writer, books []library.Book) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HASH\tTITLE\tAUTHOR\tREAD\tOPENED")
+	for _, b := range books {
+		title := b.Title
+		if b.Missing {
+			title += " (missing)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%3.0f%%\t%s\n",
+			shortHash(b.ID.Hash), truncate(title, 40), truncate(b.Author, 24), b.Progress*100, formatDate(b.LastOpened))
+	}
+	w.Flush()
+}
+
+func runLibraryInfo(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	catalog, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+	b, err := catalog.Find(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 1, ' ', 0)
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "%s:\t%s\n", name, value)
+		}
+	}
+	field("Title", b.Title)
+	field("Author", b.Author)
+	if b.Series != "" {
+		field("Series", strings.TrimSpace(b.Series+" "+b.SeriesIndex))
+	}
+	field("Publisher", b.Publisher)
+	field("Published", b.Published)
+	field("Language", b.Language)
+	field("ISBN", b.ISBN)
+	field("Subjects", strings.Join(b.Subjects, ", "))
+	field("Tags", strings.Join(b.Tags, ", "))
+	field("Shelf", b.Shelf())
+	field("Collections", strings.Join(catalog.CollectionsOf(b.Path), ", "))
+	field("File", b.Path)
+	if b.Missing {
+		field("Status", "missing since the last scan")
+	}
+	field("Hash", b.ID.Hash)
+	field("Size", formatSize(b.Size))
+	field("Chapters", fmt.Sprint(b.Chapters))
+	field("Cover", b.Cover)
+	field("Added", formatDate(b.Added))
+	field("Opened", formatDate(b.LastOpened))
+	field("Read", fmt.Sprintf("%.0f%%", b.Progress*100))
+	w.Flush()
+	if b.Description != "" {
+		fmt.Fprintf(cmd.OutOrStdout(), "\n%s\n", b.Description)
+	}
+	return nil
+}
+
+func runLibraryRemove(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 && !removeMissing {
+		return errors.New("give the books to remove, or --missing")
+	}
+	cmd.SilenceUsage = true
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	var removed []string
+	forgotten := false
+	err = library.Update(func(c *library.Catalog) error {
+		var paths []string
+		for _, arg := range args {
+			if libraryForget {
+				dir, err := filepath.Abs(arg)
+				if err != nil {
+					return fmt.Errorf("failed to get absolute path: %w", err)
+				}
+				if i := slices.Index(cfg.Library, dir); i >= 0 {
+					cfg.Library = slices.Delete(cfg.Library, i, i+1)
+					forgotten = true
+				}
+				for _, b := range c.Books {
+					if strings.HasPrefix(b.Path, dir+string(filepath.Separator)) {
+						paths = append(paths, b.Path)
+					}
+				}
+				continue
+			}
+			b, err := c.Find(arg)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, b.Path)
+		}
+		if removeMissing {
+			for _, b := range c.Books {
+				if b.Missing {
+					paths = append(paths, b.Path)
+				}
+			}
+		}
+		for _, path := range paths {
+			if c.Remove(path) {
+				removed = append(removed, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if forgotten {
+		if err := cfg.Save(); err != nil {
+			return err
+		}
+	}
+
+	out := cmd.OutOrStdout()
+	for _, path := range removed {
+		fmt.Fprintf(out, "Removed %s\n", path)
+	}
+	if len(removed) == 0 {
+		fmt.Fprintln(out, "No books removed")
+	}
+	return nil
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
+
+// truncate shortens s to at most n runes, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/edfun317/ereader/internal/config"
+	"github.com/edfun317/ereader/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keepConfig bool
+
+	storageCmd = &cobra.Command{
+		Use:   "storage",
+		Short: "Show where reading state is kept",
+		Long: `Show the backend reading progress, bookmarks, highlights and history are
+kept in. The backend is set by "storage" in the config file: "json" for
+plain JSON files, or "bolt" for an embedded database.
+
+Files follow the XDG base directories: $XDG_CONFIG_HOME/ereader for the
+config file, $XDG_STATE_HOME/ereader for reading state and
+$XDG_CACHE_HOME/ereader for caches. Setting EREADER_HOME keeps them all in
+that one directory instead.`,
+		Args: cobra.NoArgs,
+		RunE: runStorage,
+	}
+
+	migrateCmd = &cobra.Command{
+		Use:   "migrate <from> <to>",
+		Short: "Copy reading state from one backend to another",
+		Long: `Copy all reading state from one storage backend to another, merging it
+with any state already there, and switch the config file to the new backend.`,
+		Args: cobra.ExactArgs(2),
+		RunE: runMigrate,
+	}
+)
+
+func init() {
+	migrateCmd.Flags().BoolVar(&keepConfig, "keep-config", false,
+		"Copy the state without switching the config to the new backend")
+	storageCmd.AddCommand(migrateCmd)
+}
+
+func runStorage(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	dir, err := config.StateDir()
+	if err != nil {
+		return err
+	}
+	path, err := config.Path()
+	if err != nil {
+		return err
+	}
+	cache, err := config.CacheDir()
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Backend: %s\n", cfg.Storage)
+	fmt.Fprintf(out, "State:   %s\n", dir)
+	fmt.Fprintf(out, "Config:  %s\n", path)
+	fmt.Fprintf(out, "Cache:   %s\n", cache)
+	return nil
+}
+
+func runMigrate(cmd *cobra.Command, args []string) error {
+	from, to := args[0], args[1]
+	for _, name := range args {
+		if !slices.Contains(storage.Backends, name) {
+			return fmt.Errorf("unknown storage backend %q (available: json, bolt)", name)
+		}
+	}
+	if from == to {
+		return fmt.Errorf("source and destination are both %s", from)
+	}
+
+	dir, err := config.StateDir()
+	if err != nil {
+		return err
+	}
+	source, err := storage.Open(from, dir)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	dest, err := storage.Open(to, dir)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	stats, err := storage.Migrate(source, dest)
+	if err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Copied %d books, %d bookmarks, %d highlights and %d history entries from %s to %s\n",
+		stats.Books, stats.Bookmarks, stats.Highlights, stats.History, from, to)
+
+	if keepConfig {
+		return nil
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	cfg.Storage = to
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Now using the %s backend\n", to)
+	return nil
+}
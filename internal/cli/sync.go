@@ -0,0 +1,336 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/edfun317/ereader/internal/config"
+	"github.com/edfun317/ereader/internal/dirsync"
+	"github.com/edfun317/ereader/internal/kosync"
+	"github.com/edfun317/ereader/internal/storage"
+	"github.com/eiannone/keyboard"
+	"github.com/spf13/cobra"
+)
+
+// defaultSyncServer is the public server KOReader uses by default
+const defaultSyncServer = "https://sync.koreader.rocks"
+
+var (
+	syncServer   string
+	syncUser     string
+	syncPassword string
+	syncDevice   string
+	serverAddr   string
+	serverData   string
+	folderOff    bool
+
+	syncCmd = &cobra.Command{
+		Use:   "sync",
+		Short: "Sync reading progress with KOReader devices",
+		Long: `Sync reading progress through a KOReader sync server, the service behind
+KOReader's "Progress sync" plugin. Once an account is set up with register
+or login, the position in a book is fetched when it is opened and sent when
+it is closed. Positions are matched by file content, so both sides must read
+the same file, and the most recently saved position wins.
+
+Run "ereader sync server" to host a compatible server yourself. To sync
+bookmarks and highlights as well, without a server, see "ereader sync folder".`,
+		Args: cobra.NoArgs,
+		RunE: runSyncStatus,
+	}
+
+	syncRegisterCmd = &cobra.Command{
+		Use:   "register",
+		Short: "Create an account on a sync server and use it",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return setupSync(cmd, true)
+		},
+	}
+
+	syncLoginCmd = &cobra.Command{
+		Use:   "login",
+		Short: "Use an existing account on a sync server",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return setupSync(cmd, false)
+		},
+	}
+
+	syncLogoutCmd = &cobra.Command{
+		Use:   "logout",
+		Short: "Stop syncing progress",
+		Args:  cobra.NoArgs,
+		RunE:  runSyncLogout,
+	}
+
+	syncFolderCmd = &cobra.Command{
+		Use:   "folder [dir]",
+		Short: "Sync progress, bookmarks and highlights through a shared directory",
+		Long: `Sync progress, bookmarks and highlights with other machines through a
+directory they share, such as a Syncthing or Dropbox folder or a network
+file system. Each machine appends its changes to a log of its own there and
+merges the logs of the others whenever the reader starts and whenever it
+saves a book's state; the most recent change of each position, bookmark and
+highlight wins.
+
+Without a directory, the current setup is shown and a merge is run.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runSyncFolder,
+	}
+
+	syncServerCmd = &cobra.Command{
+		Use:   "server",
+		Short: "Run a KOReader compatible sync server",
+		Long: `Run a sync server speaking the KOReader sync protocol, keeping accounts and
+progress in a JSON file. KOReader devices can use it by setting it as a
+custom sync server.`,
+		Args: cobra.NoArgs,
+		RunE: runSyncServer,
+	}
+)
+
+func init() {
+	for _, cmd := range []*cobra.Command{syncRegisterCmd, syncLoginCmd} {
+		cmd.Flags().StringVar(&syncServer, "server", defaultSyncServer, "Sync server URL")
+		cmd.Flags().StringVarP(&syncUser, "user", "u", "", "Username")
+		cmd.Flags().StringVarP(&syncPassword, "password", "p", "", "Password (prompted for when not given)")
+		cmd.Flags().StringVar(&syncDevice, "device", "", "Name this device reports (default the host name)")
+		cmd.MarkFlagRequired("user")
+	}
+	syncFolderCmd.Flags().BoolVar(&folderOff, "off", false, "Stop syncing through the directory")
+	syncServerCmd.Flags().StringVar(&serverAddr, "addr", "127.0.0.1:7200", "Address to listen on")
+	syncServerCmd.Flags().StringVar(&serverData, "data", "", "Data file (default kosync-server.json in the state directory)")
+
+	syncCmd.AddCommand(syncRegisterCmd, syncLoginCmd, syncLogoutCmd, syncFolderCmd, syncServerCmd)
+}
+
+func runSyncStatus(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	out := cmd.OutOrStdout()
+	if cfg.Folder != nil {
+		fmt.Fprintf(out, "Folder: %s\n", cfg.Folder.Dir)
+	}
+	if cfg.Sync == nil {
+		fmt.Fprintln(out, `Progress sync is off; set it up with "ereader sync register" or "ereader sync login"`)
+		return nil
+	}
+
+	s := cfg.Sync
+	fmt.Fprintf(out, "Server: %s\n", s.Server)
+	fmt.Fprintf(out, "User:   %s\n", s.Username)
+	fmt.Fprintf(out, "Device: %s (%s)\n", s.Device, s.DeviceID)
+	if err := kosync.NewClient(s.Server, s.Username, s.UserKey).Authorize(); err != nil {
+		fmt.Fprintf(out, "Status: %v\n", err)
+		return nil
+	}
+	fmt.Fprintln(out, "Status: authorized")
+	return nil
+}
+
+// setupSync checks an account, creating it first when register is set, and
+// saves it to the config file
+func setupSync(cmd *cobra.Command, register bool) error {
+	cmd.SilenceUsage = true
+	password := syncPassword
+	if password == "" {
+		var err error
+		if password, err = readPassword("Password: "); err != nil {
+			return err
+		}
+	}
+	if password == "" {
+		return errors.New("a password is required")
+	}
+
+	key := kosync.Key(password)
+	client := kosync.NewClient(syncServer, syncUser, key)
+	if register {
+		if err := client.Register(); err != nil {
+			return err
+		}
+	}
+	if err := client.Authorize(); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	sync := &config.SyncConfig{
+		Server:   syncServer,
+		Username: syncUser,
+		UserKey:  key,
+		Device:   syncDevice,
+	}
+	if cfg.Sync != nil {
+		sync.DeviceID = cfg.Sync.DeviceID
+	}
+	if sync.Device == "" {
+		if sync.Device, err = os.Hostname(); err != nil || sync.Device == "" {
+			sync.Device = "ereader"
+		}
+	}
+	if sync.DeviceID == "" {
+		sync.DeviceID = newDeviceID()
+	}
+	cfg.Sync = sync
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+
+	if register {
+		fmt.Fprintf(cmd.OutOrStdout(), "Registered %s on %s; progress will be synced\n", syncUser, syncServer)
+	} else {
+		fmt.Fprintf(cmd.OutOrStdout(), "Logged in as %s on %s; progress will be synced\n", syncUser, syncServer)
+	}
+	return nil
+}
+
+func runSyncLogout(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if cfg.Sync == nil {
+		fmt.Fprintln(cmd.OutOrStdout(), "Progress sync is already off")
+		return nil
+	}
+	cfg.Sync = nil
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "Progress sync is off")
+	return nil
+}
+
+func runSyncFolder(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	out := cmd.OutOrStdout()
+
+	switch {
+	case folderOff:
+		if cfg.Folder == nil {
+			fmt.Fprintln(out, "Folder sync is already off")
+			return nil
+		}
+		cfg.Folder = nil
+		if err := cfg.Save(); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "Folder sync is off; the logs in the directory are kept")
+		return nil
+	case len(args) == 1:
+		dir, err := filepath.Abs(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path: %w", err)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+		folder := &config.FolderConfig{Dir: dir, DeviceID: newDeviceID()}
+		if cfg.Folder != nil {
+			folder.DeviceID = cfg.Folder.DeviceID
+		}
+		cfg.Folder = folder
+		if err := cfg.Save(); err != nil {
+			return err
+		}
+	case cfg.Folder == nil:
+		fmt.Fprintln(out, `Folder sync is off; set it up with "ereader sync folder <dir>"`)
+		return nil
+	}
+
+	dir, err := config.StateDir()
+	if err != nil {
+		return err
+	}
+	store, err := storage.Open(cfg.Storage, dir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	cmd.SilenceUsage = true
+	stats, err := dirsync.NewStorage(store, cfg.Folder.Dir, cfg.Folder.DeviceID).Merge()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Syncing through %s as device %s\n", cfg.Folder.Dir, cfg.Folder.DeviceID)
+	fmt.Fprintf(out, "Merged the logs of %d devices: applied %d changes, recorded %d\n",
+		stats.Devices, stats.Applied, stats.Recorded)
+	return nil
+}
+
+func runSyncServer(cmd *cobra.Command, args []string) error {
+	path := serverData
+	if path == "" {
+		dir, err := config.StateDir()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(dir, "kosync-server.json")
+	}
+
+	server, err := kosync.NewServer(path)
+	if err != nil {
+		return err
+	}
+	cmd.SilenceUsage = true
+	fmt.Fprintf(cmd.OutOrStdout(), "Sync server listening on http://%s (data in %s)\n", serverAddr, path)
+	return http.ListenAndServe(serverAddr, server)
+}
+
+// newDeviceID returns a random identifier telling this device's progress
+// apart from that of other devices
+func newDeviceID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// readPassword reads a line from the terminal without echoing it
+func readPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
+	defer fmt.Println()
+	if err := keyboard.Open(); err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	defer keyboard.Close()
+
+	var password []rune
+	for {
+		char, key, err := keyboard.GetKey()
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		switch key {
+		case keyboard.KeyEnter:
+			return string(password), nil
+		case keyboard.KeyCtrlC, keyboard.KeyEsc:
+			return "", errors.New("cancelled")
+		case keyboard.KeyBackspace, keyboard.KeyBackspace2:
+			if len(password) > 0 {
+				password = password[:len(password)-1]
+			}
+		case keyboard.KeySpace:
+			password = append(password, ' ')
+		default:
+			if char != 0 {
+				password = append(password, char)
+			}
+		}
+	}
+}
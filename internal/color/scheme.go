@@ -8,6 +8,17 @@ type ColorScheme struct {
 	TextColor   string
 	BgColor     string
 	Description string
+	Syntax      SyntaxColors
+}
+
+// SyntaxColors names the colors used to highlight source code
+type SyntaxColors struct {
+	Code    string // Text that is not otherwise highlighted
+	Keyword string
+	Type    string
+	String  string
+	Comment string
+	Number  string
 }
 
 // Available colors mapping
@@ -41,18 +52,42 @@ var (
 			TextColor:   "white",
 			BgColor:     "black",
 			Description: "Classic terminal style, suitable for long-term use",
+			Syntax: SyntaxColors{
+				Code:    "green",
+				Keyword: "magenta",
+				Type:    "cyan",
+				String:  "yellow",
+				Comment: "blue",
+				Number:  "red",
+			},
 		},
 		"paper": {
 			Name:        "paper",
 			TextColor:   "black",
 			BgColor:     "white",
 			Description: "Paper-like effect, suitable for reading long texts",
+			Syntax: SyntaxColors{
+				Code:    "black",
+				Keyword: "blue",
+				Type:    "magenta",
+				String:  "red",
+				Comment: "green",
+				Number:  "cyan",
+			},
 		},
 		"night": {
 			Name:        "night",
 			TextColor:   "cyan",
 			BgColor:     "black",
 			Description: "Night mode, reduces eye strain",
+			Syntax: SyntaxColors{
+				Code:    "cyan",
+				Keyword: "yellow",
+				Type:    "green",
+				String:  "magenta",
+				Comment: "blue",
+				Number:  "red",
+			},
 		},
 	}
 )
@@ -0,0 +1,88 @@
+// Package config locates the reader's config, state and cache directories
+// and reads its settings file.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/edfun317/ereader/internal/fileutil"
+)
+
+// Config holds the settings kept in config.json
+type Config struct {
+	Storage string        `json:"storage,omitempty"`     // Backend for reading state: "json" or "bolt"
+	Sync    *SyncConfig   `json:"sync,omitempty"`        // Progress sync, nil when off
+	Folder  *FolderConfig `json:"folder_sync,omitempty"` // Directory sync, nil when off
+	Library []string      `json:"library,omitempty"`     // Directories scanned for books
+}
+
+// FolderConfig holds the shared directory reading state is synced through
+type FolderConfig struct {
+	Dir      string `json:"dir"`
+	DeviceID string `json:"device_id"` // Names this device's change log
+}
+
+// SyncConfig holds the account used for KOReader progress sync
+type SyncConfig struct {
+	Server   string `json:"server"`
+	Username string `json:"username"`
+	UserKey  string `json:"userkey"` // MD5 of the password, as KOReader stores it
+	Device   string `json:"device"`
+	DeviceID string `json:"device_id"`
+}
+
+const configFileName = "config.json"
+
+// Default returns the settings used when there is no config file
+func Default() Config {
+	return Config{Storage: "json"}
+}
+
+// Path returns the location of the config file
+func Path() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, configFileName), nil
+}
+
+// Load reads the config file, filling in defaults for missing settings
+func Load() (Config, error) {
+	cfg := Default()
+	path, err := Path()
+	if err != nil {
+		return cfg, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return cfg, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Default(), fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if cfg.Storage == "" {
+		cfg.Storage = Default().Storage
+	}
+	return cfg, nil
+}
+
+// Save writes the config file, readable only by the user as it can hold
+// the key of a sync account
+func (c Config) Save() error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return fileutil.WriteAtomic(path, append(data, '\n'), 0600)
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// HomeEnv names the environment variable that, when set, keeps all of the
+// reader's files in one directory instead of the XDG base directories
+const HomeEnv = "EREADER_HOME"
+
+// appName is the subdirectory of each base directory used by the reader
+const appName = "ereader"
+
+// directories are where the reader keeps its files: settings in config,
+// reading state in state, and files that can be rebuilt in cache
+type directories struct {
+	config, state, cache string
+}
+
+var (
+	dirsOnce sync.Once
+	dirs     directories
+	dirsErr  error
+)
+
+// ConfigDir returns the directory holding the config file
+func ConfigDir() (string, error) {
+	d, err := resolveDirs()
+	return d.config, err
+}
+
+// StateDir returns the directory holding reading progress, bookmarks,
+// annotations and history
+func StateDir() (string, error) {
+	d, err := resolveDirs()
+	return d.state, err
+}
+
+// CacheDir returns the directory for data that can be rebuilt, such as
+// layout and search indexes
+func CacheDir() (string, error) {
+	d, err := resolveDirs()
+	return d.cache, err
+}
+
+// resolveDirs locates the directories once per process, moving the files
+// of the legacy ~/.ereader directory into them on first use
+func resolveDirs() (directories, error) {
+	dirsOnce.Do(func() {
+		if home := os.Getenv(HomeEnv); home != "" {
+			dirs = directories{config: home, state: home, cache: filepath.Join(home, "cache")}
+			return
+		}
+		if dirs, dirsErr = baseDirs(); dirsErr != nil {
+			return
+		}
+		migrateLegacy(dirs)
+	})
+	return dirs, dirsErr
+}
+
+// baseDirs follows the XDG Base Directory specification, falling back to
+// the platform's conventional locations where it does not apply
+func baseDirs() (directories, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return directories{}, fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	configHome, err := os.UserConfigDir()
+	if err != nil {
+		configHome = filepath.Join(homeDir, ".config")
+	}
+	cacheHome, err := os.UserCacheDir()
+	if err != nil {
+		cacheHome = filepath.Join(homeDir, ".cache")
+	}
+	stateHome := xdgDir("XDG_STATE_HOME")
+	if stateHome == "" {
+		switch runtime.GOOS {
+		case "windows", "darwin", "ios", "plan9":
+			stateHome = configHome
+		default:
+			stateHome = filepath.Join(homeDir, ".local", "state")
+		}
+	}
+
+	return directories{
+		config: filepath.Join(configHome, appName),
+		state:  filepath.Join(stateHome, appName),
+		cache:  filepath.Join(cacheHome, appName),
+	}, nil
+}
+
+// xdgDir returns the directory named by an XDG variable; the specification
+// requires relative paths to be ignored
+func xdgDir(name string) string {
+	dir := os.Getenv(name)
+	if !filepath.IsAbs(dir) {
+		return ""
+	}
+	return dir
+}
+
+// legacyDir returns the directory used by earlier versions
+func legacyDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".ereader"), nil
+}
+
+// migrateLegacy moves the files of ~/.ereader to their new directories and
+// removes it. Files that already exist in the new place are left behind.
+// Failures are reported but do not stop the reader, which then starts
+// afresh in the new directories.
+func migrateLegacy(d directories) {
+	legacy, err := legacyDir()
+	if err != nil {
+		return
+	}
+	entries, err := os.ReadDir(legacy)
+	if err != nil {
+		return // Nothing to migrate
+	}
+
+	moved := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		src := filepath.Join(legacy, name)
+		if strings.HasSuffix(name, ".lock") {
+			os.Remove(src) // Recreated on demand
+			continue
+		}
+
+		dst := filepath.Join(d.state, name)
+		if name == configFileName {
+			dst = filepath.Join(d.config, name)
+		}
+		if _, err := os.Lstat(dst); err == nil {
+			continue
+		}
+		if err := moveFile(src, dst); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to move %s: %v\n", src, err)
+			continue
+		}
+		moved++
+	}
+
+	if moved > 0 {
+		fmt.Fprintf(os.Stderr, "Moved reader files from %s to %s\n", legacy, d.state)
+	}
+	os.Remove(legacy) // Only succeeds once it is empty
+}
+
+// moveFile renames src to dst, copying when they are on different file
+// systems
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("cannot copy directory across file systems")
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,218 @@
+// internal/core/document.go
+package core
+
+import "strings"
+
+// Document is the format-neutral content of a chapter, produced by the
+// format readers so that viewers and exporters can render semantics
+type Document struct {
+	Blocks []Block
+}
+
+// Block is a block-level element of a document
+type Block interface {
+	isBlock()
+}
+
+// Inline is an inline element inside a block
+type Inline interface {
+	isInline()
+}
+
+type (
+	Heading struct {
+		Level   int // 1 to 6
+		Content []Inline
+	}
+
+	Paragraph struct {
+		Content []Inline
+	}
+
+	List struct {
+		Ordered bool
+		Start   int // Number of the first item of ordered lists
+		Items   []ListItem
+	}
+
+	ListItem struct {
+		Blocks []Block
+	}
+
+	BlockQuote struct {
+		Blocks []Block
+	}
+
+	// CodeBlock is preformatted text whose whitespace must be preserved
+	CodeBlock struct {
+		Language string // Declared language, e.g. "go", empty if unknown
+		Text     string
+	}
+
+	Table struct {
+		Caption []Inline
+		Rows    []TableRow
+	}
+
+	TableRow struct {
+		Cells []TableCell
+	}
+
+	TableCell struct {
+		Header  bool
+		ColSpan int
+		RowSpan int
+		Content []Inline
+	}
+
+	HorizontalRule struct{}
+
+	// Footnote is the body of a note referenced by a FootnoteRef
+	Footnote struct {
+		ID     string
+		Blocks []Block
+	}
+)
+
+type (
+	Text struct {
+		Text string
+	}
+
+	Emphasis struct {
+		Content []Inline
+	}
+
+	Strong struct {
+		Content []Inline
+	}
+
+	// Code is inline code such as an identifier in running text
+	Code struct {
+		Text string
+	}
+
+	Link struct {
+		Href    string
+		Content []Inline
+	}
+
+	FootnoteRef struct {
+		Target string // ID of the referenced Footnote
+		Label  string
+	}
+
+	LineBreak struct{}
+)
+
+// Image may appear both as a block and inline
+type Image struct {
+	Src string // Path of the image inside the book container
+	Alt string
+}
+
+// Anchor marks a position that links can target, both in block and inline context
+type Anchor struct {
+	ID string
+}
+
+func (Heading) isBlock()        {}
+func (Paragraph) isBlock()      {}
+func (List) isBlock()           {}
+func (BlockQuote) isBlock()     {}
+func (CodeBlock) isBlock()      {}
+func (Table) isBlock()          {}
+func (HorizontalRule) isBlock() {}
+func (Footnote) isBlock()       {}
+func (Image) isBlock()          {}
+func (Anchor) isBlock()         {}
+
+func (Text) isInline()        {}
+func (Emphasis) isInline()    {}
+func (Strong) isInline()      {}
+func (Code) isInline()        {}
+func (Link) isInline()        {}
+func (FootnoteRef) isInline() {}
+func (LineBreak) isInline()   {}
+func (Image) isInline()       {}
+func (Anchor) isInline()      {}
+
+// PlainText flattens inline content into text
+func PlainText(inlines []Inline) string {
+	var sb strings.Builder
+	writeInlineText(&sb, inlines)
+	return sb.String()
+}
+
+// DocumentText flattens a document into plain text, one block per paragraph.
+// Text offsets, as used by locations and annotations, count the non-space
+// characters of this text.
+func DocumentText(doc *Document) string {
+	if doc == nil {
+		return ""
+	}
+
+	var paragraphs []string
+	var walk func([]Block)
+	walk = func(blocks []Block) {
+		for _, block := range blocks {
+			switch b := block.(type) {
+			case Heading:
+				paragraphs = append(paragraphs, PlainText(b.Content))
+			case Paragraph:
+				paragraphs = append(paragraphs, PlainText(b.Content))
+			case List:
+				for _, item := range b.Items {
+					walk(item.Blocks)
+				}
+			case BlockQuote:
+				walk(b.Blocks)
+			case Footnote:
+				walk(b.Blocks)
+			case CodeBlock:
+				paragraphs = append(paragraphs, b.Text)
+			case Table:
+				if len(b.Caption) > 0 {
+					paragraphs = append(paragraphs, PlainText(b.Caption))
+				}
+				for _, row := range b.Rows {
+					var cells []string
+					for _, cell := range row.Cells {
+						cells = append(cells, PlainText(cell.Content))
+					}
+					paragraphs = append(paragraphs, strings.Join(cells, "\t"))
+				}
+			case Image:
+				if b.Alt != "" {
+					paragraphs = append(paragraphs, b.Alt)
+				}
+			}
+		}
+	}
+	walk(doc.Blocks)
+
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func writeInlineText(sb *strings.Builder, inlines []Inline) {
+	for _, inline := range inlines {
+		switch in := inline.(type) {
+		case Text:
+			sb.WriteString(in.Text)
+		case Emphasis:
+			writeInlineText(sb, in.Content)
+		case Strong:
+			writeInlineText(sb, in.Content)
+		case Code:
+			sb.WriteString(in.Text)
+		case Link:
+			writeInlineText(sb, in.Content)
+		case FootnoteRef:
+			sb.WriteString(in.Label)
+		case LineBreak:
+			sb.WriteString("\n")
+		case Image:
+			sb.WriteString(in.Alt)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// BookID identifies a book independently of where its file is stored
+type BookID struct {
+	UniqueID string `json:"unique_id,omitempty"` // Identifier the book declares as unique
+	Hash     string `json:"hash"`                // Partial MD5 of the file, see PartialMD5
+}
+
+// Key returns the string books are stored under
+func (id BookID) Key() string {
+	return id.Hash
+}
+
+// IdentifyBook computes the identity of the book file at path
+func IdentifyBook(path string, metadata BookMetadata) (BookID, error) {
+	hash, err := PartialMD5(path)
+	if err != nil {
+		return BookID{}, err
+	}
+	return BookID{UniqueID: metadata.UniqueIdentifier, Hash: hash}, nil
+}
+
+// PartialMD5 hashes 1 KiB samples taken at growing offsets through the file,
+// which is quick for large files and matches the document hash KOReader
+// uses for progress sync
+func PartialMD5(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	const sample = 1024
+	hash := md5.New()
+	buf := make([]byte, sample)
+	for i := -1; i <= 10; i++ {
+		// The start, then 1 KiB, 4 KiB, 16 KiB, ... up to 1 GiB
+		var offset int64
+		if i >= 0 {
+			offset = sample << (2 * i)
+		}
+		n, err := file.ReadAt(buf, offset)
+		if n > 0 {
+			hash.Write(buf[:n])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -6,6 +6,7 @@ type BookReader interface {
 	Open(path string) (*Book, error)
 	Close() error
 	GetMetadata() BookMetadata
+	GetNavigation() Navigation
 	GetChapter(index int) (*Chapter, error)
 	GetTotalChapters() int
 }
@@ -18,3 +19,16 @@ type Viewer interface {
 	HandleUserInput() error
 	Cleanup() error
 }
+
+// CoverReader is implemented by readers of formats that carry a cover image
+type CoverReader interface {
+	// Cover returns the image data and its media type
+	Cover() ([]byte, string, error)
+}
+
+// ChapterLoader is implemented by readers that cache chapters, to load one
+// without caching it or loading its neighbours, as when going through the
+// whole book would push out the chapters around the reading position
+type ChapterLoader interface {
+	LoadChapter(index int) (*Chapter, error)
+}
@@ -0,0 +1,39 @@
+package core
+
+import "fmt"
+
+// Location is a reading position that does not depend on the layout: a
+// spine index and a text offset within that chapter, counted in the
+// non-space characters of DocumentText
+type Location struct {
+	Chapter int `json:"chapter"`
+	Offset  int `json:"offset"`
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("chapter %d, offset %d", l.Chapter, l.Offset)
+}
+
+// Before reports whether l comes earlier in the book than other
+func (l Location) Before(other Location) bool {
+	if l.Chapter != other.Chapter {
+		return l.Chapter < other.Chapter
+	}
+	return l.Offset < other.Offset
+}
+
+// LocationEncoder is implemented by readers that can express locations in
+// their format's own notation, such as an EPUB CFI, so that positions can
+// be exchanged with other reading systems
+type LocationEncoder interface {
+	EncodeLocation(loc Location) (string, error)
+	DecodeLocation(s string) (Location, error)
+}
+
+// XPointerEncoder is implemented by readers that can express locations as
+// the XPointers KOReader uses for reflowable documents, which is how
+// progress is exchanged through KOReader sync
+type XPointerEncoder interface {
+	XPointer(loc Location) (string, error)
+	ResolveXPointer(xpointer string) (Location, error)
+}
@@ -2,19 +2,156 @@
 package core
 
 type Book struct {
-	Metadata BookMetadata
-	Chapters []Chapter
+	Metadata   BookMetadata
+	Spine      []SpineItem // Readable documents in reading order, loaded on demand
+	Navigation Navigation
 }
 
 type BookMetadata struct {
 	Title     string
-	Author    string
+	Author    string // Display string of the primary authors
 	Publisher string
 	Language  string
+
+	Subtitle         string
+	Creators         []Contributor
+	Contributors     []Contributor
+	Identifiers      []Identifier
+	UniqueIdentifier string // Value of the identifier the package declares as unique
+	Description      string
+	Subjects         []string
+	Published        string
+	Modified         string
+	Rights           string
+	Series           string
+	SeriesIndex      string
+	Refinements      []Refinement
+}
+
+// Contributor is a person or organisation responsible for the book
+type Contributor struct {
+	Name   string
+	FileAs string // Sort name, e.g. "Doe, Jane"
+	Role   string // MARC relator code such as aut, trl or edt
+}
+
+// Identifier is a book identifier together with its scheme (ISBN, UUID, ...)
+type Identifier struct {
+	Value  string
+	Scheme string
+}
+
+// Refinement is a metadata property attached to another metadata element
+type Refinement struct {
+	Refines  string // ID of the refined element, without the leading '#'
+	Property string
+	Scheme   string
+	Value    string
+}
+
+// Authors returns the creators with the author role, or every creator
+// when no roles are declared
+func (m BookMetadata) Authors() []Contributor {
+	var authors []Contributor
+	for _, c := range m.Creators {
+		if c.Role == "" || c.Role == RoleAuthor {
+			authors = append(authors, c)
+		}
+	}
+	if len(authors) == 0 {
+		return m.Creators
+	}
+	return authors
+}
+
+// ContributorsWithRole returns creators and contributors having the given role
+func (m BookMetadata) ContributorsWithRole(role string) []Contributor {
+	var result []Contributor
+	for _, c := range append(append([]Contributor{}, m.Creators...), m.Contributors...) {
+		if c.Role == role {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// ISBN returns the first ISBN identifier, if any
+func (m BookMetadata) ISBN() string {
+	for _, id := range m.Identifiers {
+		if id.Scheme == SchemeISBN {
+			return id.Value
+		}
+	}
+	return ""
+}
+
+// Common MARC relator codes
+const (
+	RoleAuthor      = "aut"
+	RoleTranslator  = "trl"
+	RoleEditor      = "edt"
+	RoleIllustrator = "ill"
+)
+
+// Common identifier schemes
+const (
+	SchemeISBN = "ISBN"
+	SchemeUUID = "UUID"
+	SchemeURI  = "URI"
+	SchemeDOI  = "DOI"
+)
+
+// SpineItem describes a chapter without holding its content
+type SpineItem struct {
+	Index     int
+	Title     string
+	Href      string
+	MediaType string
 }
 
 type Chapter struct {
-	Index   int
-	Title   string
-	Content string
+	Index    int
+	Title    string
+	Href     string // Path of the chapter document inside the book container
+	Content  string // Raw source of the chapter document
+	Document *Document
+}
+
+// Navigation holds the navigation structures declared by the book itself
+type Navigation struct {
+	TOC       []TOCEntry
+	Landmarks []TOCEntry
+	PageList  []TOCEntry
+}
+
+// TOCEntry is a single node of the table of contents tree
+type TOCEntry struct {
+	Title      string
+	SpineIndex int    // Chapter index as accepted by BookReader.GetChapter, -1 if unresolved
+	Fragment   string // Anchor inside the chapter document, empty for the start
+	Type       string // Semantic type (epub:type), mainly used by landmarks
+	Children   []TOCEntry
+}
+
+// HasTarget reports whether the entry points to a readable chapter
+func (e TOCEntry) HasTarget() bool {
+	return e.SpineIndex >= 0
+}
+
+// FlattenTOC returns the entries of a TOC tree in reading order along with their depth
+func FlattenTOC(entries []TOCEntry) ([]TOCEntry, []int) {
+	var flat []TOCEntry
+	var depths []int
+
+	var walk func([]TOCEntry, int)
+	walk = func(list []TOCEntry, depth int) {
+		for _, entry := range list {
+			flat = append(flat, entry)
+			depths = append(depths, depth)
+			walk(entry.Children, depth+1)
+		}
+	}
+
+	walk(entries, 0)
+	return flat, depths
 }
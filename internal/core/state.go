@@ -0,0 +1,228 @@
+package core
+
+import (
+	"sort"
+	"time"
+)
+
+// BookRef names a book in storage: by identity, and by the path it was
+// opened from for books that could not be identified or were stored by
+// earlier versions under their path
+type BookRef struct {
+	ID   BookID
+	Path string
+}
+
+// Key returns the key the book's state is stored under
+func (b BookRef) Key() string {
+	if key := b.ID.Key(); key != "" {
+		return key
+	}
+	return b.Path
+}
+
+// Progress is the saved reading position in a book
+type Progress struct {
+	Book     BookID    `json:"book"`
+	FilePath string    `json:"file_path"`
+	Location *Location `json:"location,omitempty"`
+	CFI      string    `json:"cfi,omitempty"`
+	XPointer string    `json:"xpointer,omitempty"` // KOReader location, for sync
+	// Share of the book read, from 0 to 1
+	Percentage float64 `json:"percentage,omitempty"`
+	// Page based position written by earlier versions, only ever read
+	Position *PagePosition `json:"position,omitempty"`
+	// Device the progress was synced from, empty for this one
+	Device  string    `json:"device,omitempty"`
+	Updated time.Time `json:"updated,omitempty"`
+}
+
+// PagePosition is a chapter and page of a particular layout
+type PagePosition struct {
+	Chapter int `json:"chapter"`
+	Page    int `json:"page"`
+}
+
+// Bookmark is a named position in a book
+type Bookmark struct {
+	Name    string    `json:"name"`
+	Chapter int       `json:"chapter"`
+	Offset  int       `json:"offset"`
+	Excerpt string    `json:"excerpt,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// BookAnnotations are the highlights made in one book
+type BookAnnotations struct {
+	Book       BookID      `json:"book"`
+	FilePath   string      `json:"file_path"`
+	Title      string      `json:"title,omitempty"`
+	Author     string      `json:"author,omitempty"`
+	Highlights []Highlight `json:"highlights"`
+}
+
+// Highlight is a highlighted passage with an optional note. Start and End
+// are text offsets within the chapter.
+type Highlight struct {
+	ID           string    `json:"id"`
+	Chapter      int       `json:"chapter"`
+	ChapterTitle string    `json:"chapter_title,omitempty"`
+	Start        int       `json:"start"`
+	End          int       `json:"end"`
+	Text         string    `json:"text"`
+	Color        string    `json:"color"`
+	Note         string    `json:"note,omitempty"`
+	Created      time.Time `json:"created"`
+	Updated      time.Time `json:"updated"`
+}
+
+// HistoryEntry records a book being opened
+type HistoryEntry struct {
+	Book     BookID    `json:"book"`
+	FilePath string    `json:"file_path"`
+	Title    string    `json:"title,omitempty"`
+	Author   string    `json:"author,omitempty"`
+	Opened   time.Time `json:"opened"`
+}
+
+// Storage persists reading state. Implementations must be safe to use from
+// several reader processes at once.
+type Storage interface {
+	// Books lists every book that has any saved state
+	Books() ([]BookRef, error)
+
+	// Progress returns the saved position of a book, or nil when there is none
+	Progress(book BookRef) (*Progress, error)
+	SaveProgress(book BookRef, progress Progress) error
+
+	Bookmarks(book BookRef) ([]Bookmark, error)
+	// SaveBookmarks stores the bookmarks of a session that last read or
+	// wrote them at since, keeping changes other sessions made meanwhile,
+	// and returns the stored bookmarks
+	SaveBookmarks(book BookRef, bookmarks []Bookmark, since time.Time) ([]Bookmark, error)
+
+	// Annotations returns the highlights of a book, or nil when there are none
+	Annotations(book BookRef) (*BookAnnotations, error)
+	AllAnnotations() ([]BookAnnotations, error)
+	// SaveAnnotations stores the highlights of a session that last read or
+	// wrote them at since, keeping changes other sessions made meanwhile,
+	// and returns the stored annotations; when none are left the book's
+	// entry is removed and nil returned
+	SaveAnnotations(book BookRef, annotations BookAnnotations, since time.Time) (*BookAnnotations, error)
+
+	AddHistory(entry HistoryEntry) error
+	// History returns the books opened, most recent first
+	History() ([]HistoryEntry, error)
+
+	Close() error
+}
+
+// Add stores a highlight unless the book already has one of the same
+// passage, in which case a missing note is filled in. It reports whether
+// the highlight was new.
+func (b *BookAnnotations) Add(h Highlight) bool {
+	for i, existing := range b.Highlights {
+		if existing.Chapter == h.Chapter && existing.Start == h.Start && existing.End == h.End {
+			if existing.Note == "" && h.Note != "" {
+				b.Highlights[i].Note = h.Note
+				b.Highlights[i].Updated = time.Now()
+			}
+			return false
+		}
+	}
+	b.Highlights = append(b.Highlights, h)
+	SortHighlights(b.Highlights)
+	return true
+}
+
+// SortHighlights orders highlights by their place in the book
+func SortHighlights(highlights []Highlight) {
+	sort.SliceStable(highlights, func(i, j int) bool {
+		a, b := highlights[i], highlights[j]
+		if a.Chapter != b.Chapter {
+			return a.Chapter < b.Chapter
+		}
+		return a.Start < b.Start
+	})
+}
+
+// SortBookmarks orders bookmarks by their place in the book
+func SortBookmarks(bookmarks []Bookmark) {
+	sort.SliceStable(bookmarks, func(i, j int) bool {
+		a, b := bookmarks[i], bookmarks[j]
+		if a.Chapter != b.Chapter {
+			return a.Chapter < b.Chapter
+		}
+		return a.Offset < b.Offset
+	})
+}
+
+// MergeBookmarks combines a session's bookmarks with the saved ones, which
+// other sessions may have changed since the session last synced: bookmarks
+// added elsewhere since are kept, and older ones removed elsewhere are
+// dropped
+func MergeBookmarks(ours, saved []Bookmark, since time.Time) []Bookmark {
+	stored := make(map[bookmarkKey]bool, len(saved))
+	for _, b := range saved {
+		stored[b.key()] = true
+	}
+	have := make(map[bookmarkKey]bool, len(ours))
+
+	var merged []Bookmark
+	for _, b := range ours {
+		have[b.key()] = true
+		if stored[b.key()] || b.Created.After(since) {
+			merged = append(merged, b)
+		}
+	}
+	for _, b := range saved {
+		if !have[b.key()] && b.Created.After(since) {
+			merged = append(merged, b)
+		}
+	}
+	SortBookmarks(merged)
+	return merged
+}
+
+// MergeHighlights combines a session's highlights with the saved ones,
+// which other sessions may have changed since the session last synced.
+// Of a highlight both have, the latest change wins; highlights added or
+// changed elsewhere since are kept, and older ones removed on either side
+// are dropped.
+func MergeHighlights(ours, saved []Highlight, since time.Time) []Highlight {
+	stored := make(map[string]Highlight, len(saved))
+	for _, h := range saved {
+		stored[h.ID] = h
+	}
+	have := make(map[string]bool, len(ours))
+
+	var merged []Highlight
+	for _, h := range ours {
+		have[h.ID] = true
+		if s, ok := stored[h.ID]; ok {
+			if s.Updated.After(h.Updated) {
+				h = s
+			}
+			merged = append(merged, h)
+		} else if h.Updated.After(since) {
+			merged = append(merged, h)
+		}
+	}
+	for _, h := range saved {
+		if !have[h.ID] && h.Updated.After(since) {
+			merged = append(merged, h)
+		}
+	}
+	SortHighlights(merged)
+	return merged
+}
+
+// bookmarkKey tells bookmarks apart across sessions
+type bookmarkKey struct {
+	created         int64
+	chapter, offset int
+}
+
+func (b Bookmark) key() bookmarkKey {
+	return bookmarkKey{created: b.Created.UnixNano(), chapter: b.Chapter, offset: b.Offset}
+}
@@ -0,0 +1,167 @@
+package dirsync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/edfun317/ereader/internal/core"
+)
+
+// logExt is the extension of the change logs in the sync directory
+const logExt = ".jsonl"
+
+// Field names of the state of a book. Bookmarks and highlights are fields
+// of their own, so changes to different ones never conflict.
+const (
+	progressField   = "progress"
+	bookmarkPrefix  = "bookmark/"
+	highlightPrefix = "highlight/"
+)
+
+// change is one line of a change log: the new value of a field of a book's
+// state, or its removal when Value is empty
+type change struct {
+	Time   time.Time       `json:"time"`
+	Device string          `json:"device"`
+	Book   core.BookID     `json:"book"`
+	Path   string          `json:"path,omitempty"` // Where the writing device keeps the book
+	Title  string          `json:"title,omitempty"`
+	Author string          `json:"author,omitempty"`
+	Field  string          `json:"field"`
+	Value  json.RawMessage `json:"value,omitempty"`
+}
+
+// deleted reports whether the change removes its field
+func (c change) deleted() bool {
+	return len(c.Value) == 0 || string(c.Value) == "null"
+}
+
+// newer reports whether c supersedes other: the later change wins, and the
+// device identifier breaks ties so that every device picks the same one
+func (c change) newer(other change) bool {
+	if !c.Time.Equal(other.Time) {
+		return c.Time.After(other.Time)
+	}
+	return c.Device > other.Device
+}
+
+// bookLog is the latest change of every field of a book
+type bookLog struct {
+	id     core.BookID
+	title  string
+	author string
+	fields map[string]change
+}
+
+func bookmarkField(b core.Bookmark) string {
+	return fmt.Sprintf("%s%d-%d-%d", bookmarkPrefix, b.Created.UnixNano(), b.Chapter, b.Offset)
+}
+
+func highlightField(h core.Highlight) string {
+	return highlightPrefix + h.ID
+}
+
+// logPath is this device's change log
+func (s *Storage) logPath() string {
+	return filepath.Join(s.dir, s.deviceID+logExt)
+}
+
+// record appends changes to this device's log in a single write, so other
+// readers of the directory never see part of a line
+func (s *Storage) record(changes ...change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, c := range changes {
+		c.Device = s.deviceID
+		data, err := json.Marshal(c)
+		if err != nil {
+			return fmt.Errorf("failed to encode change: %w", err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create sync directory: %w", err)
+	}
+	file, err := os.OpenFile(s.logPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open change log: %w", err)
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write change log: %w", err)
+	}
+	return file.Close()
+}
+
+// readLogs reads the change logs of every device, returning the latest
+// change of each field by book key and the number of logs read
+func (s *Storage) readLogs() (map[string]*bookLog, int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read sync directory: %w", err)
+	}
+
+	books := make(map[string]*bookLog)
+	logs := 0
+	for _, entry := range entries {
+		// Sync tools leave temporary and conflict copies; only logs count
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, logExt) || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if err := readLog(filepath.Join(s.dir, name), books); err != nil {
+			return nil, 0, err
+		}
+		logs++
+	}
+	return books, logs, nil
+}
+
+func readLog(path string, books map[string]*bookLog) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filepath.Base(path), err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var c change
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil || c.Book.Key() == "" || c.Field == "" {
+			continue // A line still being copied in, or damaged
+		}
+
+		book := books[c.Book.Key()]
+		if book == nil {
+			book = &bookLog{fields: make(map[string]change)}
+			books[c.Book.Key()] = book
+		}
+		if existing, ok := book.fields[c.Field]; !ok || c.newer(existing) {
+			book.fields[c.Field] = c
+		}
+		if book.id.UniqueID == "" || c.Book.UniqueID != "" {
+			book.id = c.Book
+		}
+		if c.Title != "" {
+			book.title, book.author = c.Title, c.Author
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
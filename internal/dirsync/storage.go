@@ -0,0 +1,383 @@
+// Package dirsync mirrors reading state between machines through a shared
+// directory kept in step by a tool such as Syncthing or Dropbox, or on a
+// network file system. Each device appends the changes it makes to its own
+// log in the directory, so no file is ever written by two devices, and
+// every device merges all logs into its storage: for each field of a
+// book's state (the position, each bookmark, each highlight) the most
+// recent change wins.
+package dirsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/edfun317/ereader/internal/core"
+)
+
+// Storage records the changes made to the wrapped storage in this device's
+// log and merges the changes of other devices into it
+type Storage struct {
+	core.Storage
+	dir      string
+	deviceID string
+}
+
+// NewStorage wraps base, keeping the logs in dir
+func NewStorage(base core.Storage, dir, deviceID string) *Storage {
+	return &Storage{Storage: base, dir: dir, deviceID: deviceID}
+}
+
+// MergeStats counts what Merge did
+type MergeStats struct {
+	Devices  int // Change logs read
+	Applied  int // Changes from the logs applied to the storage
+	Recorded int // Changes in the storage missing from the logs, now recorded
+}
+
+// Merge brings the storage and the logs in step: changes from the logs
+// newer than the stored state are applied, and stored state newer than the
+// logs, such as state from before syncing was set up, is recorded
+func (s *Storage) Merge() (MergeStats, error) {
+	var stats MergeStats
+	logs, devices, err := s.readLogs()
+	if err != nil {
+		return stats, err
+	}
+	stats.Devices = devices
+
+	books, err := s.Storage.Books()
+	if err != nil {
+		return stats, err
+	}
+	for _, book := range books {
+		if book.ID.Key() == "" {
+			continue // Only identified books can be matched across devices
+		}
+		log := logs[book.ID.Key()]
+		delete(logs, book.ID.Key())
+		if err := s.mergeBook(book, log, &stats); err != nil {
+			return stats, err
+		}
+	}
+
+	// Books with no state here yet. The path in the log is where another
+	// device keeps the book, so it is left for opening the book here to set.
+	for _, log := range logs {
+		book := core.BookRef{ID: log.id}
+		if err := s.mergeBook(book, log, &stats); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+func (s *Storage) mergeBook(book core.BookRef, log *bookLog, stats *MergeStats) error {
+	if log == nil {
+		log = &bookLog{id: book.ID, fields: make(map[string]change)}
+	}
+	var changes []change
+	record := func(field string, t time.Time, v any) error {
+		c := change{Time: t, Book: book.ID, Path: book.Path, Field: field}
+		if v != nil {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("failed to encode %s: %w", field, err)
+			}
+			c.Value = data
+		}
+		changes = append(changes, c)
+		return nil
+	}
+
+	// Position
+	progress, err := s.Storage.Progress(book)
+	if err != nil {
+		return err
+	}
+	if c, ok := log.fields[progressField]; ok && !c.deleted() && (progress == nil || c.Time.After(progress.Updated)) {
+		var remote core.Progress
+		if err := json.Unmarshal(c.Value, &remote); err != nil {
+			return fmt.Errorf("failed to decode synced progress: %w", err)
+		}
+		remote.Updated = c.Time
+		if err := s.Storage.SaveProgress(book, remote); err != nil {
+			return err
+		}
+		stats.Applied++
+	} else if progress != nil && (!ok || progress.Updated.After(c.Time)) {
+		if err := record(progressField, progress.Updated, progress); err != nil {
+			return err
+		}
+	}
+
+	// Bookmarks
+	bookmarks, err := s.Storage.Bookmarks(book)
+	if err != nil {
+		return err
+	}
+	merged := make([]core.Bookmark, 0, len(bookmarks))
+	local := make(map[string]bool, len(bookmarks))
+	changed := false
+	for _, b := range bookmarks {
+		field := bookmarkField(b)
+		local[field] = true
+		c, ok := log.fields[field]
+		switch {
+		case !ok:
+			if err := record(field, b.Created, b); err != nil {
+				return err
+			}
+		case c.deleted() && !c.Time.Before(b.Created):
+			changed = true
+			stats.Applied++
+			continue
+		}
+		merged = append(merged, b)
+	}
+	for field, c := range log.fields {
+		if !isBookmark(field) || local[field] || c.deleted() {
+			continue
+		}
+		var b core.Bookmark
+		if err := json.Unmarshal(c.Value, &b); err != nil {
+			return fmt.Errorf("failed to decode synced bookmark: %w", err)
+		}
+		merged = append(merged, b)
+		changed = true
+		stats.Applied++
+	}
+	if changed {
+		if err := replaceBookmarks(s.Storage, book, merged); err != nil {
+			return err
+		}
+	}
+
+	// Highlights
+	annotations, err := s.Storage.Annotations(book)
+	if err != nil {
+		return err
+	}
+	if annotations == nil {
+		annotations = &core.BookAnnotations{Title: log.title, Author: log.author}
+	}
+	highlights := make([]core.Highlight, 0, len(annotations.Highlights))
+	local = make(map[string]bool, len(annotations.Highlights))
+	changed = false
+	for _, h := range annotations.Highlights {
+		field := highlightField(h)
+		local[field] = true
+		c, ok := log.fields[field]
+		switch {
+		case !ok || h.Updated.After(c.Time):
+			if err := record(field, h.Updated, h); err != nil {
+				return err
+			}
+		case c.Time.After(h.Updated) || c.deleted():
+			changed = true
+			stats.Applied++
+			if c.deleted() {
+				continue
+			}
+			if err := json.Unmarshal(c.Value, &h); err != nil {
+				return fmt.Errorf("failed to decode synced highlight: %w", err)
+			}
+			h.Updated = c.Time
+		}
+		highlights = append(highlights, h)
+	}
+	for field, c := range log.fields {
+		if !isHighlight(field) || local[field] || c.deleted() {
+			continue
+		}
+		var h core.Highlight
+		if err := json.Unmarshal(c.Value, &h); err != nil {
+			return fmt.Errorf("failed to decode synced highlight: %w", err)
+		}
+		h.Updated = c.Time
+		highlights = append(highlights, h)
+		changed = true
+		stats.Applied++
+	}
+	if changed {
+		annotations.Highlights = highlights
+		core.SortHighlights(annotations.Highlights)
+		if err := replaceHighlights(s.Storage, book, *annotations); err != nil {
+			return err
+		}
+	}
+
+	for i := range changes {
+		changes[i].Title, changes[i].Author = annotations.Title, annotations.Author
+	}
+	stats.Recorded += len(changes)
+	return s.record(changes...)
+}
+
+func isBookmark(field string) bool {
+	return strings.HasPrefix(field, bookmarkPrefix)
+}
+
+func isHighlight(field string) bool {
+	return strings.HasPrefix(field, highlightPrefix)
+}
+
+// replaceBookmarks stores exactly the given bookmarks. SaveBookmarks keeps
+// stored bookmarks created after the sync time and drops older ones the
+// session no longer has, so the bookmarks are first added with the zero
+// time and then the others dropped with a time after all of them.
+func replaceBookmarks(store core.Storage, book core.BookRef, bookmarks []core.Bookmark) error {
+	all, err := store.SaveBookmarks(book, bookmarks, time.Time{})
+	if err != nil {
+		return err
+	}
+	var latest time.Time
+	for _, b := range all {
+		if b.Created.After(latest) {
+			latest = b.Created
+		}
+	}
+	_, err = store.SaveBookmarks(book, bookmarks, latest.Add(time.Nanosecond))
+	return err
+}
+
+// replaceHighlights stores exactly the given highlights, the same way
+// replaceBookmarks does
+func replaceHighlights(store core.Storage, book core.BookRef, annotations core.BookAnnotations) error {
+	saved, err := store.SaveAnnotations(book, annotations, time.Time{})
+	if err != nil || saved == nil {
+		return err
+	}
+	var latest time.Time
+	for _, h := range saved.Highlights {
+		if h.Updated.After(latest) {
+			latest = h.Updated
+		}
+	}
+	_, err = store.SaveAnnotations(book, annotations, latest.Add(time.Nanosecond))
+	return err
+}
+
+// SaveProgress implements core.Storage, recording the new position and
+// merging in the changes of other devices to the book
+func (s *Storage) SaveProgress(book core.BookRef, progress core.Progress) error {
+	if err := s.Storage.SaveProgress(book, progress); err != nil {
+		return err
+	}
+	if book.ID.Key() == "" {
+		return nil
+	}
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("failed to encode progress: %w", err)
+	}
+	if err := s.record(change{Time: progress.Updated, Book: book.ID, Path: book.Path, Field: progressField, Value: data}); err != nil {
+		return err
+	}
+	return s.mergeLogged(book)
+}
+
+// mergeLogged merges the changes of other devices to a book into the storage
+func (s *Storage) mergeLogged(book core.BookRef) error {
+	logs, _, err := s.readLogs()
+	if err != nil {
+		return err
+	}
+	return s.mergeBook(book, logs[book.ID.Key()], &MergeStats{})
+}
+
+// SaveBookmarks implements core.Storage, recording the bookmarks added and
+// removed and merging in those of other devices
+func (s *Storage) SaveBookmarks(book core.BookRef, bookmarks []core.Bookmark, since time.Time) ([]core.Bookmark, error) {
+	if book.ID.Key() == "" {
+		return s.Storage.SaveBookmarks(book, bookmarks, since)
+	}
+	before, err := s.Storage.Bookmarks(book)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := s.Storage.SaveBookmarks(book, bookmarks, since)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	kept := make(map[string]bool, len(saved))
+	for _, b := range saved {
+		kept[bookmarkField(b)] = true
+	}
+	var changes []change
+	for _, b := range before {
+		if !kept[bookmarkField(b)] {
+			changes = append(changes, change{Time: now, Book: book.ID, Path: book.Path, Field: bookmarkField(b)})
+		}
+	}
+	if err := s.record(changes...); err != nil {
+		return nil, err
+	}
+
+	// Bookmarks added here are recorded by the merge
+	if err := s.mergeLogged(book); err != nil {
+		return nil, err
+	}
+	return s.Storage.Bookmarks(book)
+}
+
+// SaveAnnotations implements core.Storage, recording the highlights added,
+// changed and removed and merging in those of other devices. Only what the
+// save changed in the storage is recorded: a highlight the caller does not
+// have, as one merged in from another device after the caller last synced,
+// is kept by the save and so not taken for removed.
+func (s *Storage) SaveAnnotations(book core.BookRef, annotations core.BookAnnotations, since time.Time) (*core.BookAnnotations, error) {
+	if book.ID.Key() == "" {
+		return s.Storage.SaveAnnotations(book, annotations, since)
+	}
+	before, err := s.Storage.Annotations(book)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := s.Storage.SaveAnnotations(book, annotations, since)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := make(map[string][]byte)
+	if before != nil {
+		for _, h := range before.Highlights {
+			previous[h.ID], _ = json.Marshal(h)
+		}
+	}
+	var highlights []core.Highlight
+	if saved != nil {
+		highlights = saved.Highlights
+	}
+	var changes []change
+	for _, h := range highlights {
+		data, err := json.Marshal(h)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode highlight: %w", err)
+		}
+		old, existed := previous[h.ID]
+		delete(previous, h.ID)
+		if existed && bytes.Equal(old, data) {
+			continue
+		}
+		changes = append(changes, change{Time: h.Updated, Book: book.ID, Path: book.Path, Field: highlightField(h), Value: data})
+	}
+	now := time.Now()
+	for id := range previous {
+		changes = append(changes, change{Time: now, Book: book.ID, Path: book.Path, Field: highlightPrefix + id})
+	}
+	for i := range changes {
+		changes[i].Title, changes[i].Author = annotations.Title, annotations.Author
+	}
+	if err := s.record(changes...); err != nil {
+		return nil, err
+	}
+	if err := s.mergeLogged(book); err != nil {
+		return nil, err
+	}
+	return s.Storage.Annotations(book)
+}
@@ -0,0 +1,120 @@
+package dirsync_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/edfun317/ereader/internal/core"
+	"github.com/edfun317/ereader/internal/dirsync"
+	"github.com/edfun317/ereader/internal/storage"
+)
+
+var testBook = core.BookRef{ID: core.BookID{Hash: "0123456789abcdef"}, Path: "/books/a.epub"}
+
+// session keeps highlights in memory between saves, as the viewer does
+type session struct {
+	t          *testing.T
+	store      core.Storage
+	highlights []core.Highlight
+	synced     time.Time
+}
+
+func openSession(t *testing.T, store core.Storage) *session {
+	t.Helper()
+	s := &session{t: t, store: store, synced: time.Now()}
+	annotations, err := store.Annotations(testBook)
+	if err != nil {
+		t.Fatalf("Annotations: %v", err)
+	}
+	if annotations != nil {
+		s.highlights = annotations.Highlights
+	}
+	return s
+}
+
+func (s *session) add(id string) {
+	now := time.Now()
+	s.highlights = append(s.highlights, core.Highlight{ID: id, Text: id, Created: now, Updated: now})
+	s.save()
+}
+
+func (s *session) remove(id string) {
+	s.highlights = slices.DeleteFunc(s.highlights, func(h core.Highlight) bool { return h.ID == id })
+	s.save()
+}
+
+func (s *session) save() {
+	s.t.Helper()
+	now := time.Now()
+	saved, err := s.store.SaveAnnotations(testBook, core.BookAnnotations{Highlights: s.highlights}, s.synced)
+	if err != nil {
+		s.t.Fatalf("SaveAnnotations: %v", err)
+	}
+	s.highlights = nil
+	if saved != nil {
+		s.highlights = saved.Highlights
+	}
+	s.synced = now
+}
+
+// highlightIDs returns the IDs of the highlights stored for the book
+func highlightIDs(t *testing.T, store core.Storage) []string {
+	t.Helper()
+	annotations, err := store.Annotations(testBook)
+	if err != nil {
+		t.Fatalf("Annotations: %v", err)
+	}
+	var ids []string
+	if annotations != nil {
+		for _, h := range annotations.Highlights {
+			ids = append(ids, h.ID)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestHighlightsAcrossDevices(t *testing.T) {
+	shared := t.TempDir()
+	a := dirsync.NewStorage(storage.NewJSONStorage(t.TempDir()), shared, "a")
+	b := dirsync.NewStorage(storage.NewJSONStorage(t.TempDir()), shared, "b")
+
+	reading := openSession(t, a)
+	openSession(t, b).add("hb")
+
+	// Saving on A takes in B's highlight without taking it for removed
+	reading.add("ha")
+	reading.save()
+	if _, err := b.Merge(); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if got := highlightIDs(t, b); !slices.Equal(got, []string{"ha", "hb"}) {
+		t.Errorf("B after A saved: %v, want [ha hb]", got)
+	}
+
+	// A highlight merged in by another process while the session is open
+	openSession(t, b).add("hc")
+	if _, err := a.Merge(); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	reading.save()
+	if _, err := b.Merge(); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if got := highlightIDs(t, b); !slices.Equal(got, []string{"ha", "hb", "hc"}) {
+		t.Errorf("B after A saved again: %v, want [ha hb hc]", got)
+	}
+
+	// Removing a highlight on A removes it on B
+	reading.remove("hb")
+	if _, err := b.Merge(); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if got := highlightIDs(t, b); !slices.Equal(got, []string{"ha", "hc"}) {
+		t.Errorf("B after A removed hb: %v, want [ha hc]", got)
+	}
+	if got := highlightIDs(t, a); !slices.Equal(got, []string{"ha", "hc"}) {
+		t.Errorf("A after removing hb: %v, want [ha hc]", got)
+	}
+}
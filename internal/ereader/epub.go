@@ -10,7 +10,8 @@ import (
 	"path/filepath"
 	"strings"
 
-	"golang.org/x/net/html"
+	"github.com/edfun317/ereader/internal/core"
+	"github.com/edfun317/ereader/internal/format/epub"
 )
 
 // XML structure definitions for EPUB parsing
@@ -188,24 +189,10 @@ func findOPFFile(file *zip.ReadCloser) (string, error) {
 // extractTextFromHTML extracts plain text content from HTML
 func extractTextFromHTML(htmlContent []byte) (string, error) {
 
-	doc, err := html.Parse(bytes.NewReader(htmlContent))
+	doc, err := epub.ParseDocument(bytes.NewReader(htmlContent), "")
 	if err != nil {
 		return "", err
 	}
 
-	var textContent strings.Builder
-	var traverse func(*html.Node)
-
-	// Define recursive traversal function
-	traverse = func(n *html.Node) {
-		if n.Type == html.TextNode {
-			textContent.WriteString(n.Data)
-		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			traverse(c)
-		}
-	}
-
-	traverse(doc)
-	return textContent.String(), nil
+	return core.DocumentText(doc), nil
 }
@@ -0,0 +1,52 @@
+package fileutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WriteAtomic replaces the file at path with data. The data is written to
+// a uniquely named temporary file in the same directory first, so readers
+// never see a partial file and concurrent writers never share a temp file.
+func WriteAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	temp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tempName := temp.Name()
+
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempName, perm)
+	}
+	if err == nil {
+		err = os.Rename(tempName, path)
+	}
+	if err != nil {
+		os.Remove(tempName)
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// Backup moves a damaged file aside, returning the name it was kept under
+func Backup(path string) (string, error) {
+	backup := fmt.Sprintf("%s.corrupt-%s", path, time.Now().Format("20060102-150405"))
+	if err := os.Rename(path, backup); err != nil {
+		return "", fmt.Errorf("failed to back up %s: %w", filepath.Base(path), err)
+	}
+	return backup, nil
+}
@@ -0,0 +1,41 @@
+// Package fileutil provides the file handling shared by the reader's data
+// stores: advisory locks and atomic replacement of files.
+package fileutil
+
+import (
+	"fmt"
+	"os"
+)
+
+// Lock is an exclusive advisory lock held on a lock file. It only excludes
+// other processes that take the same lock.
+type Lock struct {
+	file *os.File
+}
+
+// Acquire locks the file at path, creating it if needed, and waits while
+// another process holds the lock
+func Acquire(path string) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return &Lock{file: file}, nil
+}
+
+// Release unlocks and closes the lock file
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
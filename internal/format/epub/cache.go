@@ -0,0 +1,72 @@
+package epub
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/edfun317/ereader/internal/core"
+)
+
+// chapterCache is a bounded LRU cache of decompressed chapters
+type chapterCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // Front is the most recently used chapter
+	items    map[int]*list.Element
+}
+
+func newChapterCache(capacity int) *chapterCache {
+	return &chapterCache{
+		capacity: max(capacity, 1),
+		order:    list.New(),
+		items:    make(map[int]*list.Element),
+	}
+}
+
+func (c *chapterCache) get(index int) (*core.Chapter, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[index]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*core.Chapter), true
+}
+
+func (c *chapterCache) contains(index int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.items[index]
+	return ok
+}
+
+func (c *chapterCache) put(chapter *core.Chapter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[chapter.Index]; ok {
+		elem.Value = chapter
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[chapter.Index] = c.order.PushFront(chapter)
+
+	// Evict least recently used chapters beyond capacity
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*core.Chapter).Index)
+	}
+}
+
+func (c *chapterCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.items = make(map[int]*list.Element)
+}
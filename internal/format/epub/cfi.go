@@ -0,0 +1,370 @@
+package epub
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CFI is a parsed EPUB Canonical Fragment Identifier. A point CFI is held
+// entirely in Path; a range CFI has a common parent Path and Start and End
+// paths relative to it.
+type CFI struct {
+	Path  CFIPath
+	Start *CFIPath
+	End   *CFIPath
+}
+
+// CFIPath is a sequence of steps ending in an optional offset
+type CFIPath struct {
+	Steps  []CFIStep
+	Offset *CFIOffset
+}
+
+// CFIStep selects a child node: even indexes are elements, odd indexes the
+// text between them
+type CFIStep struct {
+	Index    int
+	ID       string // ID assertion, empty when absent
+	Params   string // Assertion parameters after ';', still escaped
+	Indirect bool   // The step follows a '!' into the referenced document
+}
+
+// CFIOffset is the terminating offset of a path
+type CFIOffset struct {
+	Indirect  bool // The offset follows a '!' instead of a step
+	Character int  // Character offset in UTF-16 code units, -1 when absent
+	Assertion string
+	Temporal  float64 // Seconds into audio or video, -1 when absent
+	Spatial   bool
+	X, Y      float64 // Spatial position in percent, used when Spatial is set
+}
+
+const (
+	cfiPrefix = "epubcfi("
+	// Characters escaped with '^' inside assertions
+	cfiSpecial = "^[](),;="
+)
+
+// IsRange reports whether the CFI describes a range rather than a point
+func (c CFI) IsRange() bool {
+	return c.Start != nil && c.End != nil
+}
+
+// StartPath returns the absolute path of the point or of the range start
+func (c CFI) StartPath() CFIPath {
+	if !c.IsRange() {
+		return c.Path
+	}
+	return c.Path.join(*c.Start)
+}
+
+// EndPath returns the absolute path of the point or of the range end
+func (c CFI) EndPath() CFIPath {
+	if !c.IsRange() {
+		return c.Path
+	}
+	return c.Path.join(*c.End)
+}
+
+func (p CFIPath) join(rel CFIPath) CFIPath {
+	steps := append(append([]CFIStep{}, p.Steps...), rel.Steps...)
+	return CFIPath{Steps: steps, Offset: rel.Offset}
+}
+
+func (c CFI) String() string {
+	var sb strings.Builder
+	sb.WriteString(cfiPrefix)
+	c.Path.write(&sb)
+	if c.IsRange() {
+		sb.WriteByte(',')
+		c.Start.write(&sb)
+		sb.WriteByte(',')
+		c.End.write(&sb)
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+func (p CFIPath) String() string {
+	var sb strings.Builder
+	p.write(&sb)
+	return sb.String()
+}
+
+func (p CFIPath) write(sb *strings.Builder) {
+	for _, step := range p.Steps {
+		if step.Indirect {
+			sb.WriteByte('!')
+		}
+		sb.WriteByte('/')
+		sb.WriteString(strconv.Itoa(step.Index))
+		if step.ID != "" || step.Params != "" {
+			sb.WriteByte('[')
+			sb.WriteString(escapeCFI(step.ID))
+			if step.Params != "" {
+				sb.WriteByte(';')
+				sb.WriteString(step.Params)
+			}
+			sb.WriteByte(']')
+		}
+	}
+	if o := p.Offset; o != nil {
+		if o.Indirect {
+			sb.WriteByte('!')
+		}
+		switch {
+		case o.Character >= 0:
+			sb.WriteByte(':')
+			sb.WriteString(strconv.Itoa(o.Character))
+			if o.Assertion != "" {
+				sb.WriteString("[" + o.Assertion + "]")
+			}
+		case o.Temporal >= 0:
+			sb.WriteByte('~')
+			sb.WriteString(formatCFINumber(o.Temporal))
+			if o.Spatial {
+				sb.WriteString("@" + formatCFINumber(o.X) + ":" + formatCFINumber(o.Y))
+			}
+		case o.Spatial:
+			sb.WriteString("@" + formatCFINumber(o.X) + ":" + formatCFINumber(o.Y))
+		}
+	}
+}
+
+func formatCFINumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func escapeCFI(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(cfiSpecial, r) {
+			sb.WriteByte('^')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func unescapeCFI(s string) string {
+	var sb strings.Builder
+	escaped := false
+	for _, r := range s {
+		if r == '^' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// ParseCFI parses a CFI such as "epubcfi(/6/4[chap01]!/4/2/1:10)". The
+// "epubcfi(...)" wrapper may be omitted, and a leading '#' is ignored.
+func ParseCFI(s string) (CFI, error) {
+	text := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if strings.HasPrefix(text, cfiPrefix) {
+		if !strings.HasSuffix(text, ")") {
+			return CFI{}, fmt.Errorf("invalid CFI %q: missing closing parenthesis", s)
+		}
+		text = text[len(cfiPrefix) : len(text)-1]
+	}
+
+	p := &cfiParser{text: text}
+	var cfi CFI
+	var err error
+	if cfi.Path, err = p.path(); err != nil {
+		return CFI{}, fmt.Errorf("invalid CFI %q: %w", s, err)
+	}
+	if len(cfi.Path.Steps) == 0 {
+		return CFI{}, fmt.Errorf("invalid CFI %q: no steps", s)
+	}
+	if p.peek() == ',' {
+		if cfi.Path.Offset != nil {
+			return CFI{}, fmt.Errorf("invalid CFI %q: range parent ends with an offset", s)
+		}
+		var start, end CFIPath
+		p.pos++
+		if start, err = p.path(); err == nil && p.expect(',') == nil {
+			end, err = p.path()
+		} else if err == nil {
+			err = errors.New("range needs a start and an end")
+		}
+		if err != nil {
+			return CFI{}, fmt.Errorf("invalid CFI %q: %w", s, err)
+		}
+		cfi.Start, cfi.End = &start, &end
+	}
+	if p.pos < len(p.text) {
+		return CFI{}, fmt.Errorf("invalid CFI %q: unexpected %q at %d", s, p.text[p.pos:], p.pos)
+	}
+	return cfi, nil
+}
+
+type cfiParser struct {
+	text string
+	pos  int
+}
+
+func (p *cfiParser) peek() byte {
+	if p.pos < len(p.text) {
+		return p.text[p.pos]
+	}
+	return 0
+}
+
+func (p *cfiParser) expect(c byte) error {
+	if p.peek() != c {
+		return fmt.Errorf("expected %q at %d", c, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+// path reads steps, indirections and a final offset
+func (p *cfiParser) path() (CFIPath, error) {
+	var path CFIPath
+	for {
+		indirect := false
+		if p.peek() == '!' {
+			indirect = true
+			p.pos++
+		}
+		switch p.peek() {
+		case '/':
+			p.pos++
+			step, err := p.step()
+			if err != nil {
+				return path, err
+			}
+			step.Indirect = indirect
+			path.Steps = append(path.Steps, step)
+		case ':', '~', '@':
+			offset, err := p.offset()
+			if err != nil {
+				return path, err
+			}
+			offset.Indirect = indirect
+			path.Offset = &offset
+			return path, nil
+		default:
+			if indirect {
+				return path, fmt.Errorf("expected a step after '!' at %d", p.pos)
+			}
+			return path, nil
+		}
+	}
+}
+
+func (p *cfiParser) step() (CFIStep, error) {
+	index, err := p.integer()
+	if err != nil {
+		return CFIStep{}, err
+	}
+	step := CFIStep{Index: index}
+	if p.peek() == '[' {
+		assertion, err := p.assertion()
+		if err != nil {
+			return CFIStep{}, err
+		}
+		id, params := splitAssertion(assertion)
+		step.ID, step.Params = unescapeCFI(id), params
+	}
+	return step, nil
+}
+
+func (p *cfiParser) offset() (CFIOffset, error) {
+	offset := CFIOffset{Character: -1, Temporal: -1}
+	var err error
+	switch p.peek() {
+	case ':':
+		p.pos++
+		if offset.Character, err = p.integer(); err != nil {
+			return offset, err
+		}
+		if p.peek() == '[' {
+			if offset.Assertion, err = p.assertion(); err != nil {
+				return offset, err
+			}
+		}
+		return offset, nil
+	case '~':
+		p.pos++
+		if offset.Temporal, err = p.number(); err != nil {
+			return offset, err
+		}
+		if p.peek() != '@' {
+			return offset, nil
+		}
+	}
+
+	p.pos++ // '@'
+	offset.Spatial = true
+	if offset.X, err = p.number(); err != nil {
+		return offset, err
+	}
+	if err := p.expect(':'); err != nil {
+		return offset, err
+	}
+	offset.Y, err = p.number()
+	return offset, err
+}
+
+func (p *cfiParser) integer() (int, error) {
+	start := p.pos
+	for p.pos < len(p.text) && p.text[p.pos] >= '0' && p.text[p.pos] <= '9' {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, fmt.Errorf("expected a number at %d", start)
+	}
+	return strconv.Atoi(p.text[start:p.pos])
+}
+
+func (p *cfiParser) number() (float64, error) {
+	start := p.pos
+	for p.pos < len(p.text) && (p.text[p.pos] >= '0' && p.text[p.pos] <= '9' || p.text[p.pos] == '.') {
+		p.pos++
+	}
+	f, err := strconv.ParseFloat(p.text[start:p.pos], 64)
+	if err != nil {
+		return 0, fmt.Errorf("expected a number at %d", start)
+	}
+	return f, nil
+}
+
+// assertion returns the escaped text between square brackets
+func (p *cfiParser) assertion() (string, error) {
+	p.pos++ // '['
+	start := p.pos
+	for p.pos < len(p.text) {
+		switch p.text[p.pos] {
+		case '^':
+			p.pos += 2
+			continue
+		case ']':
+			text := p.text[start:p.pos]
+			p.pos++
+			return text, nil
+		}
+		p.pos++
+	}
+	return "", fmt.Errorf("unterminated assertion at %d", start-1)
+}
+
+// splitAssertion separates an ID from the parameters following an
+// unescaped ';'
+func splitAssertion(s string) (string, string) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '^':
+			i++
+		case ';':
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
@@ -0,0 +1,58 @@
+package epub
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// findCover picks the manifest item of the cover image: the one EPUB 3
+// marks as cover-image, else the one an EPUB 2 cover meta names, else an
+// image whose ID or file name says it is the cover
+func findCover(pkg *Package) (Item, bool) {
+	for _, item := range pkg.Manifest.Items {
+		for _, property := range strings.Fields(item.Properties) {
+			if property == "cover-image" {
+				return item, true
+			}
+		}
+	}
+
+	for _, meta := range pkg.Metadata.Metas {
+		if meta.Name != "cover" {
+			continue
+		}
+		for _, item := range pkg.Manifest.Items {
+			if item.ID == meta.Content && strings.HasPrefix(item.MediaType, "image/") {
+				return item, true
+			}
+		}
+	}
+
+	for _, item := range pkg.Manifest.Items {
+		name := strings.ToLower(item.ID + " " + path.Base(item.Href))
+		if strings.HasPrefix(item.MediaType, "image/") && strings.Contains(name, "cover") {
+			return item, true
+		}
+	}
+	return Item{}, false
+}
+
+// Cover implements core.CoverReader
+func (r *EPUBReader) Cover() ([]byte, string, error) {
+	if r.coverItem.Href == "" {
+		return nil, "", errors.New("book has no cover image")
+	}
+	file, err := r.findFile(path.Join(r.contentPath, r.coverItem.Href))
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read cover: %w", err)
+	}
+	return data, r.coverItem.MediaType, nil
+}
@@ -0,0 +1,430 @@
+package epub
+
+import (
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/edfun317/ereader/internal/core"
+	"golang.org/x/net/html"
+)
+
+// Elements rendered as containers whose children are laid out as blocks
+var containerElements = map[string]bool{
+	"body": true, "div": true, "section": true, "article": true, "main": true,
+	"header": true, "footer": true, "nav": true, "aside": true, "figure": true,
+	"dl": true, "dt": true, "dd": true, "hgroup": true, "center": true,
+	"address": true, "details": true, "summary": true, "figcaption": true,
+}
+
+// Elements whose content is never shown
+var skippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "template": true, "noscript": true,
+}
+
+var footnoteTypes = map[string]bool{
+	"footnote": true, "endnote": true, "rearnote": true, "note": true,
+}
+
+// ParseDocument converts an XHTML content document into the core document
+// model. href is the document's path in the container and is used to
+// resolve relative image sources.
+func ParseDocument(r io.Reader, href string) (*core.Document, error) {
+	root, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &documentBuilder{baseDir: path.Dir(href)}
+	body := findElement(root, "body")
+	if body == nil {
+		body = root
+	}
+	return &core.Document{Blocks: b.blocks(body)}, nil
+}
+
+type documentBuilder struct {
+	baseDir string
+}
+
+// blocks converts the children of n, wrapping runs of inline content into
+// implicit paragraphs
+func (b *documentBuilder) blocks(n *html.Node) []core.Block {
+	var blocks []core.Block
+	var pending []core.Inline
+
+	flush := func() {
+		if hasVisibleContent(pending) {
+			blocks = append(blocks, paragraphBlock(trimInlines(pending)))
+		} else {
+			// Keep anchors that would otherwise vanish with the whitespace
+			for _, in := range pending {
+				if anchor, ok := in.(core.Anchor); ok {
+					blocks = append(blocks, anchor)
+				}
+			}
+		}
+		pending = nil
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && isBlockElement(c) {
+			flush()
+			blocks = append(blocks, b.block(c)...)
+			continue
+		}
+		pending = append(pending, b.inline(c)...)
+	}
+	flush()
+
+	return blocks
+}
+
+func isBlockElement(n *html.Node) bool {
+	switch n.Data {
+	case "p", "h1", "h2", "h3", "h4", "h5", "h6", "ul", "ol", "blockquote",
+		"pre", "table", "hr", "li":
+		return true
+	}
+	return containerElements[n.Data] || skippedElements[n.Data]
+}
+
+// block converts a block-level element, returning nothing for skipped ones
+func (b *documentBuilder) block(n *html.Node) []core.Block {
+	if skippedElements[n.Data] {
+		return nil
+	}
+
+	var blocks []core.Block
+	if id := attr(n, "id"); id != "" {
+		blocks = append(blocks, core.Anchor{ID: id})
+	}
+
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		blocks = append(blocks, core.Heading{
+			Level:   int(n.Data[1] - '0'),
+			Content: trimInlines(b.inlines(n)),
+		})
+	case "p", "li":
+		// Stray list items are treated like paragraphs
+		blocks = append(blocks, b.paragraphs(n)...)
+	case "ul", "ol":
+		blocks = append(blocks, b.list(n))
+	case "blockquote":
+		blocks = append(blocks, core.BlockQuote{Blocks: b.blocks(n)})
+	case "pre":
+		blocks = append(blocks, core.CodeBlock{
+			Language: codeLanguage(n),
+			Text:     strings.TrimRight(strings.TrimPrefix(rawText(n), "\n"), "\n\t "),
+		})
+	case "table":
+		blocks = append(blocks, b.table(n))
+	case "hr":
+		blocks = append(blocks, core.HorizontalRule{})
+	default:
+		if footnoteTypes[navType(n)] || attr(n, "role") == "doc-footnote" || attr(n, "role") == "doc-endnote" {
+			// The ID anchor belongs to the footnote itself
+			return []core.Block{core.Footnote{ID: attr(n, "id"), Blocks: b.blocks(n)}}
+		}
+		blocks = append(blocks, b.blocks(n)...)
+	}
+	return blocks
+}
+
+// paragraphs handles paragraphs that (invalidly but commonly) nest blocks
+func (b *documentBuilder) paragraphs(n *html.Node) []core.Block {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && isBlockElement(c) {
+			return b.blocks(n)
+		}
+	}
+
+	content := trimInlines(b.inlines(n))
+	if !hasVisibleContent(content) {
+		return nil
+	}
+
+	return []core.Block{paragraphBlock(content)}
+}
+
+// paragraphBlock wraps inline content, turning a lone image into an illustration
+func paragraphBlock(content []core.Inline) core.Block {
+	if len(content) == 1 {
+		if img, ok := content[0].(core.Image); ok {
+			return img
+		}
+	}
+	return core.Paragraph{Content: content}
+}
+
+func (b *documentBuilder) list(n *html.Node) core.List {
+	list := core.List{Ordered: n.Data == "ol", Start: 1}
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+		list.Start = start
+	}
+
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.Data != "li" {
+			continue
+		}
+		list.Items = append(list.Items, core.ListItem{Blocks: b.blocks(li)})
+	}
+	return list
+}
+
+func (b *documentBuilder) table(n *html.Node) core.Table {
+	var table core.Table
+
+	var collectRows func(*html.Node, bool)
+	collectRows = func(n *html.Node, header bool) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.Data {
+			case "caption":
+				table.Caption = trimInlines(b.inlines(c))
+			case "thead":
+				collectRows(c, true)
+			case "tbody", "tfoot":
+				collectRows(c, false)
+			case "tr":
+				table.Rows = append(table.Rows, b.tableRow(c, header))
+			}
+		}
+	}
+	collectRows(n, false)
+
+	return table
+}
+
+func (b *documentBuilder) tableRow(tr *html.Node, header bool) core.TableRow {
+	var row core.TableRow
+	for c := tr.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || (c.Data != "td" && c.Data != "th") {
+			continue
+		}
+		cell := core.TableCell{
+			Header:  header || c.Data == "th",
+			ColSpan: 1,
+			RowSpan: 1,
+			Content: trimInlines(b.inlines(c)),
+		}
+		if span, err := strconv.Atoi(attr(c, "colspan")); err == nil && span > 1 {
+			cell.ColSpan = span
+		}
+		if span, err := strconv.Atoi(attr(c, "rowspan")); err == nil && span > 1 {
+			cell.RowSpan = span
+		}
+		row.Cells = append(row.Cells, cell)
+	}
+	return row
+}
+
+// inlines converts the children of n to inline content
+func (b *documentBuilder) inlines(n *html.Node) []core.Inline {
+	var result []core.Inline
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		result = append(result, b.inline(c)...)
+	}
+	return result
+}
+
+func (b *documentBuilder) inline(n *html.Node) []core.Inline {
+	switch n.Type {
+	case html.TextNode:
+		return []core.Inline{core.Text{Text: collapseSpace(n.Data)}}
+	case html.ElementNode:
+	default:
+		return nil
+	}
+
+	if skippedElements[n.Data] {
+		return nil
+	}
+
+	var result []core.Inline
+	if id := attr(n, "id"); id != "" {
+		result = append(result, core.Anchor{ID: id})
+	}
+	if n.Data == "a" {
+		if name := attr(n, "name"); name != "" && name != attr(n, "id") {
+			result = append(result, core.Anchor{ID: name})
+		}
+	}
+
+	switch n.Data {
+	case "em", "i", "cite", "dfn", "var":
+		result = append(result, core.Emphasis{Content: b.inlines(n)})
+	case "strong", "b":
+		result = append(result, core.Strong{Content: b.inlines(n)})
+	case "code", "kbd", "samp", "tt":
+		result = append(result, core.Code{Text: rawText(n)})
+	case "br":
+		result = append(result, core.LineBreak{})
+	case "img", "image":
+		result = append(result, b.image(n))
+	case "a":
+		href := attr(n, "href")
+		if href == "" {
+			result = append(result, b.inlines(n)...)
+			break
+		}
+		if isNoteRef(n) {
+			_, target, _ := strings.Cut(href, "#")
+			result = append(result, core.FootnoteRef{Target: target, Label: nodeText(n)})
+			break
+		}
+		result = append(result, core.Link{Href: href, Content: b.inlines(n)})
+	default:
+		result = append(result, b.inlines(n)...)
+	}
+	return result
+}
+
+func (b *documentBuilder) image(n *html.Node) core.Image {
+	src := attr(n, "src")
+	if src == "" {
+		src = attr(n, "xlink:href") // SVG <image>
+	}
+	if unescaped, err := url.PathUnescape(src); err == nil {
+		src = unescaped
+	}
+	if src != "" && !strings.Contains(src, ":") {
+		src = path.Join(b.baseDir, src)
+	}
+	return core.Image{Src: src, Alt: strings.TrimSpace(attr(n, "alt"))}
+}
+
+func isNoteRef(n *html.Node) bool {
+	if !strings.Contains(attr(n, "href"), "#") {
+		return false
+	}
+	return strings.Contains(navType(n), "noteref") || attr(n, "role") == "doc-noteref"
+}
+
+// codeLanguage extracts the language declared on a <pre> or its <code>,
+// using the usual "language-x", "lang-x" and "brush: x" conventions
+func codeLanguage(pre *html.Node) string {
+	candidates := []*html.Node{pre}
+	if code := findChildElement(pre, "code"); code != nil {
+		candidates = append(candidates, code)
+	}
+
+	for _, n := range candidates {
+		if lang := attr(n, "data-lang"); lang != "" {
+			return strings.ToLower(lang)
+		}
+		class := attr(n, "class")
+		if i := strings.Index(class, "brush:"); i >= 0 {
+			fields := strings.Fields(strings.TrimSuffix(class[i+len("brush:"):], ";"))
+			if len(fields) > 0 {
+				return strings.ToLower(strings.TrimSuffix(fields[0], ";"))
+			}
+		}
+		for _, c := range strings.Fields(class) {
+			for _, prefix := range []string{"language-", "lang-"} {
+				if strings.HasPrefix(c, prefix) {
+					return strings.ToLower(c[len(prefix):])
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// rawText returns the text of n with whitespace preserved
+func rawText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			sb.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.Data == "br":
+			sb.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+// collapseSpace folds whitespace runs into single spaces like HTML rendering does
+func collapseSpace(s string) string {
+	var sb strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' {
+			if !space {
+				sb.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		sb.WriteRune(r)
+		space = false
+	}
+	return sb.String()
+}
+
+// trimInlines removes leading and trailing whitespace of an inline run
+func trimInlines(inlines []core.Inline) []core.Inline {
+	result := append([]core.Inline(nil), inlines...)
+	for len(result) > 0 {
+		text, ok := result[0].(core.Text)
+		if !ok {
+			break
+		}
+		if text.Text = strings.TrimLeft(text.Text, " "); text.Text != "" {
+			result[0] = text
+			break
+		}
+		result = result[1:]
+	}
+	for len(result) > 0 {
+		text, ok := result[len(result)-1].(core.Text)
+		if !ok {
+			break
+		}
+		if text.Text = strings.TrimRight(text.Text, " "); text.Text != "" {
+			result[len(result)-1] = text
+			break
+		}
+		result = result[:len(result)-1]
+	}
+	return result
+}
+
+// hasVisibleContent reports whether inlines contain anything but whitespace and anchors
+func hasVisibleContent(inlines []core.Inline) bool {
+	for _, in := range inlines {
+		switch v := in.(type) {
+		case core.Text:
+			if strings.TrimSpace(v.Text) != "" {
+				return true
+			}
+		case core.Anchor:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func findElement(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
@@ -0,0 +1,496 @@
+package epub
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/edfun317/ereader/internal/core"
+)
+
+// xmlNode is an element or text chunk of a document parsed with its XML
+// structure intact, which CFI steps refer to
+type xmlNode struct {
+	tag      string // Local element name, empty for text
+	attrs    map[string]string
+	text     string // Text of a chunk, alt text of an image
+	parent   *xmlNode
+	children []*xmlNode
+
+	// Text offsets at the start and end of the node; see indexText
+	offset, end int
+	counted     bool // The node's text counts towards text offsets
+}
+
+// parseXMLTree reads a document into a tree. Adjacent text, including text
+// split by comments, forms a single chunk as it does for CFI. XHTML content
+// is read leniently, tolerating unclosed void elements and HTML entities.
+func parseXMLTree(r io.Reader, xhtml bool) (*xmlNode, error) {
+	decoder := xml.NewDecoder(r)
+	if xhtml {
+		decoder.Strict = false
+		decoder.AutoClose = xml.HTMLAutoClose
+		decoder.Entity = xml.HTMLEntity
+	}
+
+	doc := &xmlNode{}
+	current := doc
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := &xmlNode{tag: strings.ToLower(t.Name.Local), attrs: make(map[string]string), parent: current}
+			for _, a := range t.Attr {
+				n.attrs[a.Name.Local] = a.Value
+			}
+			current.children = append(current.children, n)
+			current = n
+		case xml.EndElement:
+			if current.parent != nil {
+				current = current.parent
+			}
+		case xml.CharData:
+			if last := len(current.children) - 1; last >= 0 && current.children[last].tag == "" {
+				current.children[last].text += string(t)
+				continue
+			}
+			current.children = append(current.children, &xmlNode{text: string(t), parent: current})
+		}
+	}
+
+	for _, n := range doc.children {
+		if n.tag != "" {
+			n.parent = nil
+			return n, nil
+		}
+	}
+	return nil, errors.New("document has no root element")
+}
+
+func (n *xmlNode) find(match func(*xmlNode) bool) *xmlNode {
+	if match(n) {
+		return n
+	}
+	for _, c := range n.children {
+		if found := c.find(match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func (n *xmlNode) byID(id string) *xmlNode {
+	return n.find(func(n *xmlNode) bool { return n.tag != "" && n.attrs["id"] == id })
+}
+
+// child returns the node a CFI step index selects, or nil for an empty or
+// missing text chunk
+func (n *xmlNode) child(index int) *xmlNode {
+	elements := 0
+	for _, c := range n.children {
+		if c.tag == "" {
+			if index == 2*elements+1 {
+				return c
+			}
+			continue
+		}
+		elements++
+		if index == 2*elements {
+			return c
+		}
+	}
+	return nil
+}
+
+// stepIndex is the CFI index of n among its siblings
+func (n *xmlNode) stepIndex() int {
+	elements := 0
+	for _, c := range n.parent.children {
+		if c == n {
+			break
+		}
+		if c.tag != "" {
+			elements++
+		}
+	}
+	if n.tag == "" {
+		return 2*elements + 1
+	}
+	return 2 * (elements + 1)
+}
+
+// steps returns the CFI steps leading from the root down to n
+func (n *xmlNode) steps() []CFIStep {
+	var steps []CFIStep
+	for ; n.parent != nil; n = n.parent {
+		step := CFIStep{Index: n.stepIndex()}
+		if n.tag != "" {
+			step.ID = n.attrs["id"]
+		}
+		steps = append([]CFIStep{step}, steps...)
+	}
+	return steps
+}
+
+// contentDocument is a chapter indexed for converting between text offsets
+// and CFI paths
+type contentDocument struct {
+	root   *xmlNode
+	leaves []*xmlNode // Counted text chunks and images, in document order
+}
+
+func newContentDocument(content string) (*contentDocument, error) {
+	root, err := parseXMLTree(strings.NewReader(content), true)
+	if err != nil {
+		return nil, err
+	}
+	d := &contentDocument{root: root}
+	d.indexText()
+	return d, nil
+}
+
+// textWeight is the number of text offsets a node spans
+func textWeight(s string) int {
+	n := 0
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// indexText assigns text offsets to the nodes, following what ParseDocument
+// keeps of each element so that offsets agree with core.DocumentText
+func (d *contentDocument) indexText() {
+	const (
+		normal = iota
+		rawText
+		tableRows
+		listItems
+		skipped
+	)
+
+	offset := 0
+	var walk func(n *xmlNode, mode int)
+	walk = func(n *xmlNode, mode int) {
+		n.offset = offset
+		defer func() { n.end = offset }()
+
+		if n.tag == "" {
+			if mode == normal || mode == rawText {
+				n.counted = true
+				offset += textWeight(n.text)
+				d.leaves = append(d.leaves, n)
+			}
+			return
+		}
+
+		childMode := mode
+		switch {
+		case mode == skipped:
+		case mode == tableRows && n.tag != "thead" && n.tag != "tbody" && n.tag != "tfoot" && n.tag != "tr":
+			if n.tag == "caption" || n.tag == "td" || n.tag == "th" {
+				childMode = normal
+				break
+			}
+			childMode = skipped
+		case mode == listItems:
+			childMode = skipped
+			if n.tag == "li" {
+				childMode = normal
+			}
+		case mode == rawText:
+		case skippedElements[n.tag]:
+			childMode = skipped
+		case n.tag == "img" || n.tag == "image":
+			n.text = strings.TrimSpace(n.attrs["alt"])
+			n.counted = true
+			offset += textWeight(n.text)
+			d.leaves = append(d.leaves, n)
+			return
+		case n.tag == "pre" || n.tag == "code" || n.tag == "kbd" || n.tag == "samp" || n.tag == "tt":
+			childMode = rawText
+		case n.tag == "a" && isNoteRefAttrs(n.attrs):
+			childMode = rawText
+		case n.tag == "table":
+			childMode = tableRows
+		case n.tag == "ul" || n.tag == "ol":
+			childMode = listItems
+		}
+
+		for _, c := range n.children {
+			// Text directly inside tables and lists is dropped
+			if c.tag == "" && (childMode == tableRows || childMode == listItems) {
+				c.offset, c.end = offset, offset
+				continue
+			}
+			walk(c, childMode)
+		}
+	}
+
+	body := d.root.find(func(n *xmlNode) bool { return n.tag == "body" })
+	if body == nil {
+		body = d.root
+	}
+	// Nodes outside the body, such as the head, stay at offset 0
+	walk(d.root, skipped)
+	walk(body, normal)
+}
+
+func isNoteRefAttrs(attrs map[string]string) bool {
+	if !strings.Contains(attrs["href"], "#") {
+		return false
+	}
+	return strings.Contains(attrs["type"], "noteref") || attrs["role"] == "doc-noteref"
+}
+
+// pathFor returns the content document path of the character at a text
+// offset, clamped to the text of the chapter
+func (d *contentDocument) pathFor(offset int) CFIPath {
+	if len(d.leaves) == 0 {
+		body := d.root.find(func(n *xmlNode) bool { return n.tag == "body" })
+		if body == nil {
+			return CFIPath{}
+		}
+		return CFIPath{Steps: body.steps()}
+	}
+
+	leaf := d.leafAt(offset)
+	path := CFIPath{Steps: leaf.steps()}
+	if leaf.tag != "" {
+		return path
+	}
+	path.Offset = &CFIOffset{Character: utf16Index(leaf.text, offset-leaf.offset), Temporal: -1}
+	return path
+}
+
+// leafAt returns the text chunk or image holding a text offset, or the last
+// one for offsets past the end; the document must have leaves
+func (d *contentDocument) leafAt(offset int) *xmlNode {
+	for _, l := range d.leaves {
+		if offset < l.end {
+			return l
+		}
+	}
+	return d.leaves[len(d.leaves)-1]
+}
+
+// utf16Index returns the position, in UTF-16 code units, of the n-th
+// non-space character of s, or of the end of s when there are fewer
+func utf16Index(s string, n int) int {
+	units := 0
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			if n <= 0 {
+				return units
+			}
+			n--
+		}
+		units += utf16.RuneLen(r)
+	}
+	return units
+}
+
+// offsetFor resolves content document steps and an optional character
+// offset to a text offset
+func (d *contentDocument) offsetFor(steps []CFIStep, offset *CFIOffset) (int, error) {
+	node := d.root
+	for i, step := range steps {
+		next := node.child(step.Index)
+		if step.ID != "" && (next == nil || next.attrs["id"] != step.ID) {
+			// The ID assertion wins over an index from another revision
+			if byID := d.root.byID(step.ID); byID != nil {
+				next = byID
+			}
+		}
+		if next == nil {
+			if step.Index%2 == 1 && i == len(steps)-1 {
+				// An empty text chunk lies before the following element
+				return emptyChunkOffset(node, step.Index), nil
+			}
+			return 0, fmt.Errorf("step /%d does not exist", step.Index)
+		}
+		node = next
+	}
+
+	if node.tag != "" || !node.counted || offset == nil || offset.Character < 0 {
+		return node.offset, nil
+	}
+	return node.offset + textWeight(utf16Prefix(node.text, offset.Character)), nil
+}
+
+func emptyChunkOffset(parent *xmlNode, index int) int {
+	if next := parent.child(index + 1); next != nil {
+		return next.offset
+	}
+	return parent.end
+}
+
+// utf16Prefix returns the start of s spanning the given UTF-16 code units
+func utf16Prefix(s string, units int) string {
+	for i, r := range s {
+		if units <= 0 {
+			return s[:i]
+		}
+		units -= utf16.RuneLen(r)
+	}
+	return s
+}
+
+// spineRef is the package document step of a spine item
+type spineRef struct {
+	spineStep CFIStep
+	itemStep  CFIStep
+}
+
+// spineRefs locates the itemref of every readable spine item within the
+// package document
+func (r *EPUBReader) spineRefs() ([]spineRef, error) {
+	if r.cfiRefs != nil {
+		return r.cfiRefs, nil
+	}
+
+	file, err := r.findFile(r.rootFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	pkg, err := parseXMLTree(file, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse package document: %w", err)
+	}
+
+	var spine *xmlNode
+	for _, c := range pkg.children {
+		if c.tag == "spine" {
+			spine = c
+			break
+		}
+	}
+	if spine == nil {
+		return nil, errors.New("package document has no spine")
+	}
+
+	refs := make([]spineRef, len(r.spineIDRefs))
+	for i, idref := range r.spineIDRefs {
+		item := spine.find(func(n *xmlNode) bool { return n.tag == "itemref" && n.attrs["idref"] == idref })
+		if item == nil {
+			return nil, fmt.Errorf("spine item %q not found", idref)
+		}
+		refs[i] = spineRef{
+			spineStep: CFIStep{Index: spine.stepIndex(), ID: spine.attrs["id"]},
+			itemStep:  CFIStep{Index: item.stepIndex(), ID: item.attrs["id"]},
+		}
+	}
+	r.cfiRefs = refs
+	return refs, nil
+}
+
+// contentDocument indexes the chapter at a spine index
+func (r *EPUBReader) contentDocument(index int) (*contentDocument, error) {
+	chapter, err := r.GetChapter(index)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := newContentDocument(chapter.Content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", chapter.Href, err)
+	}
+	return doc, nil
+}
+
+// CFI returns the canonical fragment identifier of a location
+func (r *EPUBReader) CFI(loc core.Location) (CFI, error) {
+	if loc.Chapter < 0 || loc.Chapter >= r.GetTotalChapters() {
+		return CFI{}, fmt.Errorf("chapter %d out of range", loc.Chapter)
+	}
+	refs, err := r.spineRefs()
+	if err != nil {
+		return CFI{}, err
+	}
+	doc, err := r.contentDocument(loc.Chapter)
+	if err != nil {
+		return CFI{}, err
+	}
+
+	content := doc.pathFor(loc.Offset)
+	if len(content.Steps) > 0 {
+		content.Steps[0].Indirect = true
+	}
+	ref := refs[loc.Chapter]
+	return CFI{Path: CFIPath{
+		Steps:  append([]CFIStep{ref.spineStep, ref.itemStep}, content.Steps...),
+		Offset: content.Offset,
+	}}, nil
+}
+
+// ResolveCFI returns the location a CFI points to; for a range, the start
+func (r *EPUBReader) ResolveCFI(cfi CFI) (core.Location, error) {
+	path := cfi.StartPath()
+	if len(path.Steps) < 2 {
+		return core.Location{}, errors.New("CFI does not reach a spine item")
+	}
+	refs, err := r.spineRefs()
+	if err != nil {
+		return core.Location{}, err
+	}
+
+	chapter := -1
+	item := path.Steps[1]
+	for i, ref := range refs {
+		if item.ID != "" && ref.itemStep.ID == item.ID {
+			chapter = i
+			break
+		}
+		if chapter < 0 && ref.itemStep.Index == item.Index {
+			chapter = i
+		}
+	}
+	if chapter < 0 {
+		return core.Location{}, fmt.Errorf("spine step /%d is not a readable chapter", item.Index)
+	}
+
+	loc := core.Location{Chapter: chapter}
+	content := path.Steps[2:]
+	if len(content) == 0 || !content[0].Indirect {
+		return loc, nil
+	}
+	doc, err := r.contentDocument(chapter)
+	if err != nil {
+		return core.Location{}, err
+	}
+	if loc.Offset, err = doc.offsetFor(content, path.Offset); err != nil {
+		return core.Location{}, fmt.Errorf("failed to resolve %s: %w", cfi, err)
+	}
+	return loc, nil
+}
+
+// EncodeLocation implements core.LocationEncoder with EPUB CFIs
+func (r *EPUBReader) EncodeLocation(loc core.Location) (string, error) {
+	cfi, err := r.CFI(loc)
+	if err != nil {
+		return "", err
+	}
+	return cfi.String(), nil
+}
+
+// DecodeLocation implements core.LocationEncoder with EPUB CFIs
+func (r *EPUBReader) DecodeLocation(s string) (core.Location, error) {
+	cfi, err := ParseCFI(s)
+	if err != nil {
+		return core.Location{}, err
+	}
+	return r.ResolveCFI(cfi)
+}
@@ -0,0 +1,271 @@
+package epub
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/edfun317/ereader/internal/core"
+	"golang.org/x/net/html"
+)
+
+// onixIdentifierTypes maps ONIX codelist 5 values used by EPUB 3
+// identifier-type refinements onto identifier schemes
+var onixIdentifierTypes = map[string]string{
+	"02": core.SchemeISBN,
+	"06": core.SchemeDOI,
+	"15": core.SchemeISBN,
+}
+
+var isbnPattern = regexp.MustCompile(`^(97[89][- ]?)?\d{1,5}[- ]?\d+[- ]?\d+[- ]?[\dXx]$`)
+
+// buildMetadata converts the OPF metadata section into the core model,
+// applying EPUB 3 refinements on top of the EPUB 2 attributes
+func buildMetadata(pkg *Package) core.BookMetadata {
+	md := pkg.Metadata
+	refinements := collectRefinements(md.Metas)
+
+	metadata := core.BookMetadata{
+		Publisher:   strings.TrimSpace(md.Publisher),
+		Description: plainText(md.Description),
+		Rights:      strings.TrimSpace(md.Rights),
+		Refinements: refinements,
+	}
+
+	if len(md.Languages) > 0 {
+		metadata.Language = strings.TrimSpace(md.Languages[0])
+	}
+	for _, subject := range md.Subjects {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			metadata.Subjects = append(metadata.Subjects, subject)
+		}
+	}
+
+	// Titles: the one refined as "main" wins, otherwise the first one
+	for _, title := range md.Titles {
+		value := strings.TrimSpace(title.Value)
+		switch refinementValue(refinements, title.ID, "title-type") {
+		case "main":
+			metadata.Title = value
+		case "subtitle":
+			metadata.Subtitle = value
+		default:
+			if metadata.Title == "" {
+				metadata.Title = value
+			}
+		}
+	}
+
+	for _, creator := range md.Creators {
+		c := buildContributor(creator, refinements)
+		if c.Role == "" {
+			c.Role = core.RoleAuthor
+		}
+		metadata.Creators = append(metadata.Creators, c)
+	}
+	for _, contributor := range md.Contributors {
+		metadata.Contributors = append(metadata.Contributors, buildContributor(contributor, refinements))
+	}
+
+	var names []string
+	for _, author := range metadata.Authors() {
+		names = append(names, author.Name)
+	}
+	metadata.Author = strings.Join(names, ", ")
+
+	for _, id := range md.Identifiers {
+		identifier := buildIdentifier(id, refinements)
+		metadata.Identifiers = append(metadata.Identifiers, identifier)
+		if id.ID != "" && id.ID == pkg.UniqueIdentifier {
+			metadata.UniqueIdentifier = identifier.Value
+		}
+	}
+
+	for _, date := range md.Dates {
+		value := strings.TrimSpace(date.Value)
+		switch strings.ToLower(date.Event) {
+		case "modification":
+			metadata.Modified = value
+		case "", "publication", "issued":
+			if metadata.Published == "" {
+				metadata.Published = value
+			}
+		}
+	}
+
+	for _, meta := range md.Metas {
+		switch {
+		case meta.Refines != "":
+			continue
+		case meta.Property == "dcterms:modified":
+			metadata.Modified = strings.TrimSpace(meta.Value)
+		case meta.Property == "belongs-to-collection":
+			collectionType := refinementValue(refinements, meta.ID, "collection-type")
+			if collectionType == "" || collectionType == "series" {
+				metadata.Series = strings.TrimSpace(meta.Value)
+				metadata.SeriesIndex = refinementValue(refinements, meta.ID, "group-position")
+			}
+		}
+	}
+
+	// Calibre series metadata only fills in what EPUB 3 did not declare
+	for _, meta := range md.Metas {
+		switch meta.Name {
+		case "calibre:series":
+			if metadata.Series == "" {
+				metadata.Series = strings.TrimSpace(meta.Content)
+			}
+		case "calibre:series_index":
+			if metadata.SeriesIndex == "" {
+				metadata.SeriesIndex = strings.TrimSpace(meta.Content)
+			}
+		}
+	}
+
+	return metadata
+}
+
+func collectRefinements(metas []MetaElement) []core.Refinement {
+	var refinements []core.Refinement
+	for _, meta := range metas {
+		if meta.Refines == "" || meta.Property == "" {
+			continue
+		}
+		refinements = append(refinements, core.Refinement{
+			Refines:  strings.TrimPrefix(meta.Refines, "#"),
+			Property: meta.Property,
+			Scheme:   meta.Scheme,
+			Value:    strings.TrimSpace(meta.Value),
+		})
+	}
+	return refinements
+}
+
+func findRefinement(refinements []core.Refinement, id, property string) (core.Refinement, bool) {
+	if id == "" {
+		return core.Refinement{}, false
+	}
+	for _, ref := range refinements {
+		if ref.Refines == id && ref.Property == property {
+			return ref, true
+		}
+	}
+	return core.Refinement{}, false
+}
+
+func refinementValue(refinements []core.Refinement, id, property string) string {
+	ref, _ := findRefinement(refinements, id, property)
+	return ref.Value
+}
+
+func buildContributor(el DCElement, refinements []core.Refinement) core.Contributor {
+	c := core.Contributor{
+		Name:   strings.TrimSpace(el.Value),
+		FileAs: strings.TrimSpace(el.FileAs),
+		Role:   strings.TrimSpace(el.Role),
+	}
+	if role := refinementValue(refinements, el.ID, "role"); role != "" {
+		c.Role = role
+	}
+	if fileAs := refinementValue(refinements, el.ID, "file-as"); fileAs != "" {
+		c.FileAs = fileAs
+	}
+	return c
+}
+
+func buildIdentifier(el DCElement, refinements []core.Refinement) core.Identifier {
+	value := strings.TrimSpace(el.Value)
+	scheme := strings.ToUpper(strings.TrimSpace(el.Scheme))
+
+	if ref, ok := findRefinement(refinements, el.ID, "identifier-type"); ok {
+		if mapped, known := onixIdentifierTypes[ref.Value]; known && strings.HasPrefix(ref.Scheme, "onix:") {
+			scheme = mapped
+		} else if scheme == "" {
+			scheme = strings.ToUpper(ref.Value)
+		}
+	}
+
+	// Identifiers are often URNs carrying their own scheme
+	lower := strings.ToLower(value)
+	switch {
+	case strings.HasPrefix(lower, "urn:isbn:"):
+		value, scheme = value[len("urn:isbn:"):], core.SchemeISBN
+	case strings.HasPrefix(lower, "isbn:"):
+		value, scheme = value[len("isbn:"):], core.SchemeISBN
+	case strings.HasPrefix(lower, "urn:uuid:"):
+		value, scheme = value[len("urn:uuid:"):], core.SchemeUUID
+	case strings.HasPrefix(lower, "doi:"):
+		value, scheme = value[len("doi:"):], core.SchemeDOI
+	case scheme == "" && isbnPattern.MatchString(value) && validISBN(value):
+		scheme = core.SchemeISBN
+	case scheme == "" && (strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")):
+		scheme = core.SchemeURI
+	}
+
+	return core.Identifier{Value: value, Scheme: scheme}
+}
+
+// validISBN reports whether an ISBN, with separators, has 10 or 13 digits
+// and a matching check digit. The check digit of an ISBN-10 may be X.
+func validISBN(value string) bool {
+	digits := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(value))
+	sum := 0
+	switch len(digits) {
+	case 10:
+		for i, r := range digits {
+			d := int(r - '0')
+			if r == 'X' && i == 9 {
+				d = 10
+			} else if d < 0 || d > 9 {
+				return false
+			}
+			sum += (10 - i) * d
+		}
+		return sum%11 == 0
+	case 13:
+		for i, r := range digits {
+			d := int(r - '0')
+			if d < 0 || d > 9 {
+				return false
+			}
+			sum += d * (1 + 2*(i%2))
+		}
+		return sum%10 == 0
+	}
+	return false
+}
+
+// plainText strips markup from descriptions, which frequently contain escaped HTML
+func plainText(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "<") {
+		return s
+	}
+
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return s
+	}
+
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && (n.Data == "p" || n.Data == "br" || n.Data == "div") {
+			sb.WriteString("\n")
+		}
+	}
+	walk(doc)
+
+	var paragraphs []string
+	for _, line := range strings.Split(sb.String(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			paragraphs = append(paragraphs, line)
+		}
+	}
+	return strings.Join(paragraphs, "\n")
+}
@@ -0,0 +1,249 @@
+package epub
+
+import (
+	"encoding/xml"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/edfun317/ereader/internal/core"
+	"golang.org/x/net/html"
+)
+
+// readNavigation builds the book navigation from the EPUB 3 navigation
+// document, falling back to the EPUB 2 NCX when no usable nav is present
+func (r *EPUBReader) readNavigation(pkg *Package) {
+	if item, ok := findNavItem(pkg); ok {
+		if nav, err := r.readNavDocument(item); err == nil && len(nav.TOC) > 0 {
+			r.book.Navigation = nav
+			return
+		}
+	}
+
+	if item, ok := findNCXItem(pkg); ok {
+		if nav, err := r.readNCX(item); err == nil {
+			r.book.Navigation = nav
+		}
+	}
+}
+
+// applyTOCTitles replaces scraped chapter titles with the ones from the TOC
+func (r *EPUBReader) applyTOCTitles() {
+	titled := make(map[int]bool)
+
+	flat, _ := core.FlattenTOC(r.book.Navigation.TOC)
+	for _, entry := range flat {
+		if !entry.HasTarget() || titled[entry.SpineIndex] || entry.Title == "" {
+			continue
+		}
+		r.book.Spine[entry.SpineIndex].Title = entry.Title
+		titled[entry.SpineIndex] = true
+	}
+}
+
+func findNavItem(pkg *Package) (Item, bool) {
+	for _, item := range pkg.Manifest.Items {
+		for _, prop := range strings.Fields(item.Properties) {
+			if prop == "nav" {
+				return item, true
+			}
+		}
+	}
+	return Item{}, false
+}
+
+func findNCXItem(pkg *Package) (Item, bool) {
+	for _, item := range pkg.Manifest.Items {
+		if pkg.Spine.Toc != "" && item.ID == pkg.Spine.Toc {
+			return item, true
+		}
+	}
+	for _, item := range pkg.Manifest.Items {
+		if item.MediaType == "application/x-dtbncx+xml" {
+			return item, true
+		}
+	}
+	return Item{}, false
+}
+
+func (r *EPUBReader) readNavDocument(item Item) (core.Navigation, error) {
+	navPath := path.Join(r.contentPath, item.Href)
+	navFile, err := r.findFile(navPath)
+	if err != nil {
+		return core.Navigation{}, err
+	}
+	defer navFile.Close()
+
+	doc, err := html.Parse(navFile)
+	if err != nil {
+		return core.Navigation{}, err
+	}
+
+	var nav core.Navigation
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "nav" {
+			list := findChildElement(n, "ol")
+			if list == nil {
+				list = findChildElement(n, "ul")
+			}
+			entries := r.parseNavList(list, path.Dir(navPath))
+
+			switch navType(n) {
+			case "toc":
+				nav.TOC = entries
+			case "landmarks":
+				nav.Landmarks = entries
+			case "page-list":
+				nav.PageList = entries
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	return nav, nil
+}
+
+// parseNavList converts an <ol> of the navigation document into TOC entries
+func (r *EPUBReader) parseNavList(list *html.Node, baseDir string) []core.TOCEntry {
+	if list == nil {
+		return nil
+	}
+
+	var entries []core.TOCEntry
+	for li := list.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.Data != "li" {
+			continue
+		}
+
+		entry := core.TOCEntry{SpineIndex: -1}
+		for c := li.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.Data {
+			case "a":
+				entry.Title = nodeText(c)
+				entry.Type = navType(c)
+				entry.SpineIndex, entry.Fragment = r.resolveHref(baseDir, attr(c, "href"))
+			case "span":
+				entry.Title = nodeText(c)
+			case "ol", "ul":
+				entry.Children = r.parseNavList(c, baseDir)
+			}
+		}
+
+		if entry.Title == "" && len(entry.Children) == 0 {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (r *EPUBReader) readNCX(item Item) (core.Navigation, error) {
+	ncxPath := path.Join(r.contentPath, item.Href)
+	ncxFile, err := r.findFile(ncxPath)
+	if err != nil {
+		return core.Navigation{}, err
+	}
+	defer ncxFile.Close()
+
+	var doc ncx
+	if err := xml.NewDecoder(ncxFile).Decode(&doc); err != nil {
+		return core.Navigation{}, err
+	}
+
+	baseDir := path.Dir(ncxPath)
+
+	var convert func([]navPoint) []core.TOCEntry
+	convert = func(points []navPoint) []core.TOCEntry {
+		var entries []core.TOCEntry
+		for _, point := range points {
+			entry := core.TOCEntry{
+				Title:    strings.TrimSpace(point.Label),
+				Children: convert(point.Children),
+			}
+			entry.SpineIndex, entry.Fragment = r.resolveHref(baseDir, point.Content.Src)
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	nav := core.Navigation{TOC: convert(doc.NavMap)}
+	for _, target := range doc.PageList {
+		entry := core.TOCEntry{Title: strings.TrimSpace(target.Label)}
+		entry.SpineIndex, entry.Fragment = r.resolveHref(baseDir, target.Content.Src)
+		nav.PageList = append(nav.PageList, entry)
+	}
+
+	return nav, nil
+}
+
+// resolveHref maps a link relative to baseDir onto a chapter index and fragment
+func (r *EPUBReader) resolveHref(baseDir, href string) (int, string) {
+	if href == "" {
+		return -1, ""
+	}
+
+	target, fragment, _ := strings.Cut(href, "#")
+	if unescaped, err := url.PathUnescape(target); err == nil {
+		target = unescaped
+	}
+	target = path.Join(baseDir, target)
+
+	for i, item := range r.book.Spine {
+		if strings.EqualFold(item.Href, target) {
+			return i, fragment
+		}
+	}
+	return -1, fragment
+}
+
+// navType returns the epub:type of an element regardless of how the parser
+// recorded the namespace prefix
+func navType(n *html.Node) string {
+	for _, a := range n.Attr {
+		if a.Key == "epub:type" || (a.Key == "type" && a.Namespace == "epub") {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func findChildElement(n *html.Node, tag string) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == tag {
+			return c
+		}
+	}
+	return nil
+}
+
+func nodeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
@@ -3,22 +3,69 @@ package epub
 import (
 	"archive/zip"
 	"errors"
+	"sync"
 
 	"github.com/edfun317/ereader/internal/core"
 )
 
+const (
+	defaultCacheSize     = 8
+	defaultPrefetchRange = 1
+)
+
 type EPUBReader struct {
 	file        *zip.ReadCloser
 	book        *core.Book
 	rootFile    string
 	contentPath string
+
+	spineFiles    []*zip.File // Archive entries backing book.Spine
+	spineIDRefs   []string    // Manifest IDs of the spine items
+	cfiRefs       []spineRef  // Package steps of the spine items, built on first use
+	coverItem     Item        // Manifest item of the cover image, if any
+	cache         *chapterCache
+	cacheSize     int
+	prefetchRange int // Neighbouring chapters loaded in the background, 0 disables
+
+	prefetchMu sync.Mutex
+	inflight   map[int]bool
+	wg         sync.WaitGroup
+}
+
+// Option configures an EPUBReader
+type Option func(*EPUBReader)
+
+// WithCacheSize sets how many decompressed chapters are kept in memory
+func WithCacheSize(size int) Option {
+	return func(r *EPUBReader) {
+		r.cacheSize = size
+	}
+}
+
+// WithPrefetch sets how many chapters on each side of the requested one are
+// decompressed in the background; 0 disables prefetching
+func WithPrefetch(chapters int) Option {
+	return func(r *EPUBReader) {
+		r.prefetchRange = chapters
+	}
 }
 
-func NewEPUBReader() *EPUBReader {
-	return &EPUBReader{}
+func NewEPUBReader(opts ...Option) *EPUBReader {
+	r := &EPUBReader{
+		cacheSize:     defaultCacheSize,
+		prefetchRange: defaultPrefetchRange,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 func (r *EPUBReader) Open(path string) (*core.Book, error) {
+	// Close a book opened before, once its prefetches stop using the
+	// archive and cache replaced below
+	r.Close()
+
 	// Open the EPUB file (it's a ZIP file)
 	reader, err := zip.OpenReader(path)
 	if err != nil {
@@ -28,8 +75,14 @@ func (r *EPUBReader) Open(path string) (*core.Book, error) {
 
 	// Initialize book structure
 	r.book = &core.Book{
-		Chapters: make([]core.Chapter, 0),
+		Spine: make([]core.SpineItem, 0),
 	}
+	r.spineFiles = nil
+	r.spineIDRefs = nil
+	r.cfiRefs = nil
+	r.coverItem = Item{}
+	r.cache = newChapterCache(r.cacheSize)
+	r.inflight = make(map[int]bool)
 
 	// Read container.xml to find the root file
 	if err := r.readContainer(); err != nil {
@@ -45,8 +98,16 @@ func (r *EPUBReader) Open(path string) (*core.Book, error) {
 }
 
 func (r *EPUBReader) Close() error {
+	// Wait for background prefetches before the archive goes away
+	r.wg.Wait()
+
+	if r.cache != nil {
+		r.cache.clear()
+	}
 	if r.file != nil {
-		return r.file.Close()
+		err := r.file.Close()
+		r.file = nil
+		return err
 	}
 	return nil
 }
@@ -58,19 +119,86 @@ func (r *EPUBReader) GetMetadata() core.BookMetadata {
 	return core.BookMetadata{}
 }
 
+func (r *EPUBReader) GetNavigation() core.Navigation {
+	if r.book != nil {
+		return r.book.Navigation
+	}
+	return core.Navigation{}
+}
+
+// GetChapter returns the chapter at index, decompressing it on first use
 func (r *EPUBReader) GetChapter(index int) (*core.Chapter, error) {
 	if r.book == nil {
 		return nil, errors.New("book not opened")
 	}
-	if index < 0 || index >= len(r.book.Chapters) {
+	if index < 0 || index >= len(r.book.Spine) {
 		return nil, errors.New("chapter index out of range")
 	}
-	return &r.book.Chapters[index], nil
+
+	chapter, ok := r.cache.get(index)
+	if !ok {
+		var err error
+		if chapter, err = r.readChapter(index); err != nil {
+			return nil, err
+		}
+		r.cache.put(chapter)
+	}
+
+	r.prefetchAround(index)
+	return chapter, nil
+}
+
+// LoadChapter implements core.ChapterLoader, decompressing the chapter
+// without caching it or prefetching its neighbours
+func (r *EPUBReader) LoadChapter(index int) (*core.Chapter, error) {
+	if r.book == nil {
+		return nil, errors.New("book not opened")
+	}
+	if index < 0 || index >= len(r.book.Spine) {
+		return nil, errors.New("chapter index out of range")
+	}
+	return r.readChapter(index)
 }
 
 func (r *EPUBReader) GetTotalChapters() int {
 	if r.book == nil {
 		return 0
 	}
-	return len(r.book.Chapters)
+	return len(r.book.Spine)
+}
+
+// prefetchAround loads the neighbours of index in the background
+func (r *EPUBReader) prefetchAround(index int) {
+	for offset := 1; offset <= r.prefetchRange; offset++ {
+		r.prefetch(index + offset)
+		r.prefetch(index - offset)
+	}
+}
+
+func (r *EPUBReader) prefetch(index int) {
+	if index < 0 || index >= len(r.book.Spine) || r.cache.contains(index) {
+		return
+	}
+
+	r.prefetchMu.Lock()
+	if r.inflight[index] {
+		r.prefetchMu.Unlock()
+		return
+	}
+	r.inflight[index] = true
+	r.prefetchMu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer func() {
+			r.prefetchMu.Lock()
+			delete(r.inflight, index)
+			r.prefetchMu.Unlock()
+		}()
+
+		if chapter, err := r.readChapter(index); err == nil {
+			r.cache.put(chapter)
+		}
+	}()
 }
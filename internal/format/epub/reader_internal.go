@@ -1,9 +1,12 @@
 package epub
 
 import (
+	"archive/zip"
+	"bytes"
 	"encoding/xml"
 	"errors"
 	"io"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -45,12 +48,7 @@ func (r *EPUBReader) readPackage() error {
 	}
 
 	// Set metadata
-	r.book.Metadata = core.BookMetadata{
-		Title:     pkg.Metadata.Title,
-		Author:    pkg.Metadata.Creator,
-		Publisher: pkg.Metadata.Publisher,
-		Language:  pkg.Metadata.Language,
-	}
+	r.book.Metadata = buildMetadata(&pkg)
 
 	// Build a map of manifest items for quick lookup
 	manifestItems := make(map[string]Item)
@@ -58,8 +56,8 @@ func (r *EPUBReader) readPackage() error {
 		manifestItems[item.ID] = item
 	}
 
-	// Process chapters in spine order
-	for i, itemRef := range pkg.Spine.ItemRefs {
+	// Index chapters in spine order; their content is read on demand
+	for _, itemRef := range pkg.Spine.ItemRefs {
 		item, ok := manifestItems[itemRef.IDRef]
 		if !ok {
 			continue
@@ -67,20 +65,35 @@ func (r *EPUBReader) readPackage() error {
 
 		if item.MediaType == "application/xhtml+xml" ||
 			item.MediaType == "application/x-dtbook+xml" {
-			chapter, err := r.readChapter(item, i)
-			if err != nil {
-				continue // Skip chapters with errors
+			href := path.Join(r.contentPath, item.Href)
+			file := r.lookupFile(href)
+			if file == nil {
+				continue // Skip chapters missing from the archive
 			}
-			r.book.Chapters = append(r.book.Chapters, *chapter)
+			r.spineFiles = append(r.spineFiles, file)
+			r.spineIDRefs = append(r.spineIDRefs, itemRef.IDRef)
+			r.book.Spine = append(r.book.Spine, core.SpineItem{
+				Index:     len(r.book.Spine),
+				Href:      href,
+				MediaType: item.MediaType,
+			})
 		}
 	}
 
+	if cover, ok := findCover(&pkg); ok {
+		r.coverItem = cover
+	}
+
+	// Read the navigation document (or NCX) now that the spine is known
+	r.readNavigation(&pkg)
+	r.applyTOCTitles()
+
 	return nil
 }
 
-func (r *EPUBReader) readChapter(item Item, index int) (*core.Chapter, error) {
-	chapterPath := filepath.Join(r.contentPath, item.Href)
-	chapterFile, err := r.findFile(chapterPath)
+// readChapter decompresses the spine document at index
+func (r *EPUBReader) readChapter(index int) (*core.Chapter, error) {
+	chapterFile, err := r.spineFiles[index].Open()
 	if err != nil {
 		return nil, err
 	}
@@ -91,20 +104,40 @@ func (r *EPUBReader) readChapter(item Item, index int) (*core.Chapter, error) {
 		return nil, err
 	}
 
+	item := r.book.Spine[index]
+	title := item.Title
+	if title == "" {
+		title = extractTitle(content)
+	}
+
+	doc, err := ParseDocument(bytes.NewReader(content), item.Href)
+	if err != nil {
+		return nil, err
+	}
+
 	return &core.Chapter{
-		Index:   index,
-		Title:   extractTitle(content),
-		Content: string(content),
+		Index:    index,
+		Title:    title,
+		Href:     item.Href,
+		Content:  string(content),
+		Document: doc,
 	}, nil
 }
 
 func (r *EPUBReader) findFile(name string) (io.ReadCloser, error) {
+	if f := r.lookupFile(name); f != nil {
+		return f.Open()
+	}
+	return nil, errors.New("file not found in EPUB: " + name)
+}
+
+func (r *EPUBReader) lookupFile(name string) *zip.File {
 	for _, f := range r.file.File {
 		if strings.EqualFold(f.Name, name) {
-			return f.Open()
+			return f
 		}
 	}
-	return nil, errors.New("file not found in EPUB: " + name)
+	return nil
 }
 
 // Helper function to extract title from chapter content
@@ -14,17 +14,47 @@ type rootFile struct {
 }
 
 type Package struct {
-	XMLName  xml.Name `xml:"package"`
-	Metadata Metadata `xml:"metadata"`
-	Manifest Manifest `xml:"manifest"`
-	Spine    Spine    `xml:"spine"`
+	XMLName          xml.Name `xml:"package"`
+	Version          string   `xml:"version,attr"`
+	UniqueIdentifier string   `xml:"unique-identifier,attr"`
+	Metadata         Metadata `xml:"metadata"`
+	Manifest         Manifest `xml:"manifest"`
+	Spine            Spine    `xml:"spine"`
 }
 
 type Metadata struct {
-	Title     string `xml:"title"`
-	Creator   string `xml:"creator"`
-	Publisher string `xml:"publisher"`
-	Language  string `xml:"language"`
+	Titles       []DCElement   `xml:"title"`
+	Creators     []DCElement   `xml:"creator"`
+	Contributors []DCElement   `xml:"contributor"`
+	Identifiers  []DCElement   `xml:"identifier"`
+	Dates        []DCElement   `xml:"date"`
+	Publisher    string        `xml:"publisher"`
+	Languages    []string      `xml:"language"`
+	Description  string        `xml:"description"`
+	Subjects     []string      `xml:"subject"`
+	Rights       string        `xml:"rights"`
+	Metas        []MetaElement `xml:"meta"`
+}
+
+// DCElement is a Dublin Core element with the EPUB 2 opf:* attributes
+type DCElement struct {
+	ID     string `xml:"id,attr"`
+	Role   string `xml:"role,attr"`
+	FileAs string `xml:"file-as,attr"`
+	Scheme string `xml:"scheme,attr"`
+	Event  string `xml:"event,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// MetaElement covers both EPUB 3 property metas and EPUB 2 name/content metas
+type MetaElement struct {
+	ID       string `xml:"id,attr"`
+	Property string `xml:"property,attr"`
+	Refines  string `xml:"refines,attr"`
+	Scheme   string `xml:"scheme,attr"`
+	Name     string `xml:"name,attr"`
+	Content  string `xml:"content,attr"`
+	Value    string `xml:",chardata"`
 }
 
 type Manifest struct {
@@ -32,15 +62,39 @@ type Manifest struct {
 }
 
 type Item struct {
-	ID        string `xml:"id,attr"`
-	Href      string `xml:"href,attr"`
-	MediaType string `xml:"media-type,attr"`
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
 }
 
 type Spine struct {
+	Toc      string    `xml:"toc,attr"`
 	ItemRefs []ItemRef `xml:"itemref"`
 }
 
 type ItemRef struct {
 	IDRef string `xml:"idref,attr"`
 }
+
+// EPUB 2 navigation control file (toc.ncx)
+type ncx struct {
+	XMLName  xml.Name        `xml:"ncx"`
+	NavMap   []navPoint      `xml:"navMap>navPoint"`
+	PageList []ncxPageTarget `xml:"pageList>pageTarget"`
+}
+
+type navPoint struct {
+	Label    string     `xml:"navLabel>text"`
+	Content  ncxContent `xml:"content"`
+	Children []navPoint `xml:"navPoint"`
+}
+
+type ncxPageTarget struct {
+	Label   string     `xml:"navLabel>text"`
+	Content ncxContent `xml:"content"`
+}
+
+type ncxContent struct {
+	Src string `xml:"src,attr"`
+}
@@ -0,0 +1,185 @@
+package epub
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/edfun317/ereader/internal/core"
+)
+
+// XPointers are the locations KOReader's engine uses for EPUB, and so for
+// progress sync. The engine merges the spine into one document with a
+// DocFragment element per spine item:
+//
+//	/body/DocFragment[3]/body/div/p[2]/text().15
+//
+// Elements are indexed among siblings of the same name, from 1, and the
+// index is left out when there is only one. Text nodes holding only white
+// space are not part of the engine's documents and are not counted.
+
+var xpointerFragment = regexp.MustCompile(`^/body/DocFragment\[(\d+)\]`)
+
+// xpointerName is the step selecting n, without an index
+func (n *xmlNode) xpointerName() string {
+	if n.tag == "" {
+		return "text()"
+	}
+	return n.tag
+}
+
+// xpointerCounted reports whether the engine keeps n
+func (n *xmlNode) xpointerCounted() bool {
+	return n.tag != "" || strings.TrimFunc(n.text, unicode.IsSpace) != ""
+}
+
+// xpointerStep is the step selecting n among its siblings
+func (n *xmlNode) xpointerStep() string {
+	index, count := 0, 0
+	for _, c := range n.parent.children {
+		if !c.xpointerCounted() || c.xpointerName() != n.xpointerName() {
+			continue
+		}
+		count++
+		if c == n {
+			index = count
+		}
+	}
+	if count > 1 {
+		return fmt.Sprintf("%s[%d]", n.xpointerName(), index)
+	}
+	return n.xpointerName()
+}
+
+// xpointerChild returns the child a step selects, or nil
+func (n *xmlNode) xpointerChild(name string, index int) *xmlNode {
+	for _, c := range n.children {
+		if !c.xpointerCounted() || c.xpointerName() != name {
+			continue
+		}
+		if index--; index == 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+// runeIndex returns the position, in runes, of the n-th non-space character
+// of s, or of the end of s when there are fewer
+func runeIndex(s string, n int) int {
+	runes := 0
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			if n <= 0 {
+				return runes
+			}
+			n--
+		}
+		runes++
+	}
+	return runes
+}
+
+// XPointer returns the KOReader XPointer of a location
+func (r *EPUBReader) XPointer(loc core.Location) (string, error) {
+	if loc.Chapter < 0 || loc.Chapter >= r.GetTotalChapters() {
+		return "", fmt.Errorf("chapter %d out of range", loc.Chapter)
+	}
+	doc, err := r.contentDocument(loc.Chapter)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "/body/DocFragment[%d]", loc.Chapter+1)
+	body := doc.root.find(func(n *xmlNode) bool { return n.tag == "body" })
+	if body == nil {
+		return b.String(), nil
+	}
+	b.WriteString("/body")
+	if len(doc.leaves) == 0 {
+		return b.String(), nil
+	}
+
+	leaf := doc.leafAt(loc.Offset)
+	for i := len(doc.leaves) - 1; !leaf.xpointerCounted() && i >= 0; i-- {
+		// Past the end of the text, only white space may be left
+		leaf = doc.leaves[i]
+	}
+	if !leaf.xpointerCounted() {
+		return b.String(), nil
+	}
+	var steps []string
+	for n := leaf; n != body && n.parent != nil; n = n.parent {
+		steps = append([]string{n.xpointerStep()}, steps...)
+	}
+	for _, step := range steps {
+		b.WriteString("/" + step)
+	}
+	if leaf.tag == "" {
+		fmt.Fprintf(&b, ".%d", runeIndex(leaf.text, loc.Offset-leaf.offset))
+	}
+	return b.String(), nil
+}
+
+// ResolveXPointer returns the location a KOReader XPointer points to. Steps
+// that no longer match the document, as after an edit of the book, are
+// dropped and the position of the deepest matching element is returned.
+func (r *EPUBReader) ResolveXPointer(xpointer string) (core.Location, error) {
+	match := xpointerFragment.FindStringSubmatch(xpointer)
+	if match == nil {
+		return core.Location{}, fmt.Errorf("unsupported XPointer %q", xpointer)
+	}
+	fragment, _ := strconv.Atoi(match[1])
+	loc := core.Location{Chapter: fragment - 1}
+	if loc.Chapter < 0 || loc.Chapter >= r.GetTotalChapters() {
+		return core.Location{}, fmt.Errorf("XPointer %q is past the last chapter", xpointer)
+	}
+
+	rest := strings.TrimPrefix(xpointer, match[0])
+	charOffset := -1
+	if dot := strings.LastIndex(rest, "."); dot >= 0 && dot > strings.LastIndex(rest, "/") {
+		if n, err := strconv.Atoi(rest[dot+1:]); err == nil {
+			charOffset = n
+			rest = rest[:dot]
+		}
+	}
+	steps := strings.Split(strings.Trim(rest, "/"), "/")
+	if len(steps) == 0 || steps[0] != "body" {
+		return loc, nil
+	}
+
+	doc, err := r.contentDocument(loc.Chapter)
+	if err != nil {
+		return core.Location{}, err
+	}
+	node := doc.root.find(func(n *xmlNode) bool { return n.tag == "body" })
+	if node == nil {
+		return loc, nil
+	}
+	resolved := true
+	for _, step := range steps[1:] {
+		name, index := step, 1
+		if open := strings.Index(step, "["); open >= 0 && strings.HasSuffix(step, "]") {
+			name = step[:open]
+			if n, err := strconv.Atoi(step[open+1 : len(step)-1]); err == nil {
+				index = n
+			}
+		}
+		next := node.xpointerChild(strings.ToLower(name), index)
+		if next == nil {
+			resolved = false
+			break
+		}
+		node = next
+	}
+
+	loc.Offset = node.offset
+	if resolved && node.tag == "" && node.counted && charOffset > 0 {
+		runes := []rune(node.text)
+		loc.Offset += textWeight(string(runes[:min(charOffset, len(runes))]))
+	}
+	return loc, nil
+}
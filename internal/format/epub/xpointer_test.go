@@ -0,0 +1,110 @@
+package epub
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/edfun317/ereader/internal/core"
+)
+
+// xpointerChapter has repeated and nested elements, inline markup, white
+// space between elements and text outside the paragraphs
+const xpointerChapter = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>One</title></head>
+<body>
+  <h1>Chapter One</h1>
+  <p>The first paragraph, with <em>some emphasis</em> and <a href="#n">a link</a>.</p>
+  <div class="quote">
+    <p>A quoted paragraph &amp; an entity.</p>
+    <p>Another   one,
+       spread over lines.</p>
+  </div>
+  Loose text in the body.
+  <p>中文的段落，没有空格。</p>
+  <ul><li>First item</li><li>Second <b>bold</b> item</li></ul>
+</body></html>`
+
+// writeTestEPUB writes a book with the given chapters and returns its path
+func writeTestEPUB(t *testing.T, chapters ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.epub")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	files := map[string]string{
+		"mimetype": "application/epub+zip",
+		"META-INF/container.xml": `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`,
+	}
+	manifest, spine := "", ""
+	for i, chapter := range chapters {
+		id := string(rune('a' + i))
+		files[id+".xhtml"] = chapter
+		manifest += `<item id="` + id + `" href="` + id + `.xhtml" media-type="application/xhtml+xml"/>`
+		spine += `<itemref idref="` + id + `"/>`
+	}
+	files["content.opf"] = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:identifier id="id">test</dc:identifier><dc:title>Test</dc:title></metadata>
+<manifest>` + manifest + `</manifest><spine>` + spine + `</spine></package>`
+
+	w := zip.NewWriter(f)
+	for _, name := range []string{"mimetype", "META-INF/container.xml", "content.opf"} {
+		writeZipFile(t, w, name, files[name])
+		delete(files, name)
+	}
+	for name, content := range files {
+		writeZipFile(t, w, name, content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeZipFile(t *testing.T, w *zip.Writer, name, content string) {
+	t.Helper()
+	fw, err := w.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestXPointerRoundTrip(t *testing.T) {
+	r := NewEPUBReader()
+	if _, err := r.Open(writeTestEPUB(t, xpointerChapter, xpointerChapter)); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer r.Close()
+
+	for chapter := range 2 {
+		doc, err := r.contentDocument(chapter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		end := doc.leaves[len(doc.leaves)-1].end
+		for offset := 0; offset < end; offset++ {
+			loc := core.Location{Chapter: chapter, Offset: offset}
+			xpointer, err := r.XPointer(loc)
+			if err != nil {
+				t.Fatalf("XPointer(%v): %v", loc, err)
+			}
+			got, err := r.ResolveXPointer(xpointer)
+			if err != nil {
+				t.Fatalf("ResolveXPointer(%q): %v", xpointer, err)
+			}
+			if got != loc {
+				t.Errorf("ResolveXPointer(%q) = %v, want %v", xpointer, got, loc)
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package kosync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrUnauthorized is returned when the server rejects the credentials
+var ErrUnauthorized = errors.New("unauthorized: wrong username or password")
+
+// ErrUserExists is returned when registering a taken username
+var ErrUserExists = errors.New("username is already registered")
+
+// Client talks to a KOReader sync server
+type Client struct {
+	server   string
+	username string
+	key      string
+	http     *http.Client
+}
+
+// ClientOption configures a Client
+type ClientOption func(*Client)
+
+// WithTimeout limits how long each request may take
+func WithTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.http.Timeout = d
+	}
+}
+
+// NewClient returns a client for the server at the given URL. key is the
+// user key, see Key.
+func NewClient(server, username, key string, opts ...ClientOption) *Client {
+	c := &Client{
+		server:   strings.TrimRight(server, "/"),
+		username: username,
+		key:      key,
+		http:     &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Register creates the client's user on the server
+func (c *Client) Register() error {
+	body := map[string]string{"username": c.username, "password": c.key}
+	return c.do(http.MethodPost, "/users/create", body, nil)
+}
+
+// Authorize checks the client's credentials
+func (c *Client) Authorize() error {
+	return c.do(http.MethodGet, "/users/auth", nil, nil)
+}
+
+// GetProgress returns the last progress pushed for a document, or nil when
+// there is none
+func (c *Client) GetProgress(document string) (*Progress, error) {
+	var progress Progress
+	if err := c.do(http.MethodGet, "/syncs/progress/"+url.PathEscape(document), nil, &progress); err != nil {
+		return nil, err
+	}
+	if progress.Document == "" {
+		return nil, nil
+	}
+	return &progress, nil
+}
+
+// UpdateProgress pushes the progress of a document
+func (c *Client) UpdateProgress(progress Progress) error {
+	return c.do(http.MethodPut, "/syncs/progress", progress, nil)
+}
+
+// do sends a request and decodes the response into out, when given
+func (c *Client) do(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.server+path, body)
+	if err != nil {
+		return fmt.Errorf("invalid sync server %q: %w", c.server, err)
+	}
+	req.Header.Set("Accept", mediaType)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("x-auth-user", c.username)
+	req.Header.Set("x-auth-key", c.key)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("sync server unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read sync response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr apiError
+		json.Unmarshal(data, &apiErr)
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized || apiErr.Code == codeUnauthorized:
+			return ErrUnauthorized
+		case apiErr.Code == codeUserExists:
+			return ErrUserExists
+		case apiErr.Message != "":
+			return fmt.Errorf("sync server: %s", apiErr.Message)
+		}
+		return fmt.Errorf("sync server: %s", resp.Status)
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("failed to decode sync response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+// Package kosync implements the KOReader sync protocol, which keeps reading
+// progress in step across devices: a client, a storage that syncs progress
+// through it, and a compatible server.
+package kosync
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+)
+
+// mediaType is the API version KOReader asks for
+const mediaType = "application/vnd.koreader.v1+json"
+
+// Progress is the position in a document reported by one device.
+// Document is the partial MD5 of the book file, see core.PartialMD5, and
+// Progress is a KOReader XPointer.
+type Progress struct {
+	Document   string  `json:"document"`
+	Progress   string  `json:"progress"`
+	Percentage float64 `json:"percentage"`
+	Device     string  `json:"device"`
+	DeviceID   string  `json:"device_id"`
+	Timestamp  int64   `json:"timestamp,omitempty"` // Unix time the server received it
+}
+
+// Error codes of the protocol
+const (
+	codeInternal      = 2000
+	codeUnauthorized  = 2001
+	codeUserExists    = 2002
+	codeInvalidFields = 2003
+	codeDocumentField = 2004
+)
+
+// apiError is the body of a failed request
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Key derives the key a user authenticates with from the password, as
+// KOReader does
+func Key(password string) string {
+	sum := md5.Sum([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
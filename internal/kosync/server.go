@@ -0,0 +1,177 @@
+package kosync
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/edfun317/ereader/internal/fileutil"
+)
+
+// Server is a KOReader sync server keeping its users and their progress in
+// a JSON file. It serves the same API as the official server, so KOReader
+// devices can use it too.
+type Server struct {
+	path string
+	mux  *http.ServeMux
+
+	mu   sync.Mutex
+	data serverData
+}
+
+type serverData struct {
+	Users    map[string]string              `json:"users"`    // Username to user key
+	Progress map[string]map[string]Progress `json:"progress"` // Username to document to progress
+}
+
+// NewServer returns a server storing its data in the file at path
+func NewServer(path string) (*Server, error) {
+	s := &Server{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.data); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	if s.data.Users == nil {
+		s.data.Users = make(map[string]string)
+	}
+	if s.data.Progress == nil {
+		s.data.Progress = make(map[string]map[string]Progress)
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("POST /users/create", s.createUser)
+	s.mux.HandleFunc("GET /users/auth", s.authorize)
+	s.mux.HandleFunc("PUT /syncs/progress", s.updateProgress)
+	s.mux.HandleFunc("GET /syncs/progress/{document}", s.getProgress)
+	s.mux.HandleFunc("GET /healthcheck", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"state": "OK"})
+	})
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Username == "" || body.Password == "" {
+		writeError(w, http.StatusForbidden, codeInvalidFields, "Invalid request")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.data.Users[body.Username]; exists {
+		writeError(w, http.StatusPaymentRequired, codeUserExists, "Username is already registered.")
+		return
+	}
+	s.data.Users[body.Username] = body.Password
+	if err := s.save(); err != nil {
+		delete(s.data.Users, body.Username)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Unknown server error.")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"username": body.Username})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.user(w, r); ok {
+		writeJSON(w, http.StatusOK, map[string]string{"authorized": "OK"})
+	}
+}
+
+func (s *Server) updateProgress(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.user(w, r)
+	if !ok {
+		return
+	}
+	var progress Progress
+	if err := json.NewDecoder(r.Body).Decode(&progress); err != nil || progress.Progress == "" || progress.Device == "" {
+		writeError(w, http.StatusForbidden, codeInvalidFields, "Invalid request")
+		return
+	}
+	if progress.Document == "" {
+		writeError(w, http.StatusForbidden, codeDocumentField, "Field 'document' not provided.")
+		return
+	}
+	progress.Timestamp = time.Now().Unix()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	documents := s.data.Progress[user]
+	if documents == nil {
+		documents = make(map[string]Progress)
+		s.data.Progress[user] = documents
+	}
+	previous, existed := documents[progress.Document]
+	documents[progress.Document] = progress
+	if err := s.save(); err != nil {
+		if existed {
+			documents[progress.Document] = previous
+		} else {
+			delete(documents, progress.Document)
+		}
+		writeError(w, http.StatusInternalServerError, codeInternal, "Unknown server error.")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"document": progress.Document, "timestamp": progress.Timestamp})
+}
+
+func (s *Server) getProgress(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.user(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	progress, found := s.data.Progress[user][r.PathValue("document")]
+	s.mu.Unlock()
+	if !found {
+		writeJSON(w, http.StatusOK, struct{}{})
+		return
+	}
+	writeJSON(w, http.StatusOK, progress)
+}
+
+// user authenticates a request, answering it when that fails
+func (s *Server) user(w http.ResponseWriter, r *http.Request) (string, bool) {
+	username, key := r.Header.Get("x-auth-user"), r.Header.Get("x-auth-key")
+	s.mu.Lock()
+	stored, exists := s.data.Users[username]
+	s.mu.Unlock()
+	if username == "" || !exists || stored != key {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+		return "", false
+	}
+	return username, true
+}
+
+// save writes the data file; the caller holds the lock
+func (s *Server) save() error {
+	data, err := json.MarshalIndent(s.data, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal sync data: %w", err)
+	}
+	return fileutil.WriteAtomic(s.path, data, 0600)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	writeJSON(w, status, apiError{Code: code, Message: message})
+}
@@ -0,0 +1,75 @@
+package kosync_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/edfun317/ereader/internal/kosync"
+)
+
+// newTestServer starts a sync server keeping its data in a temporary file
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server, err := kosync.NewServer(filepath.Join(t.TempDir(), "sync.json"))
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestServerRoundTrip(t *testing.T) {
+	ts := newTestServer(t)
+	client := kosync.NewClient(ts.URL, "reader", kosync.Key("secret"))
+
+	if err := client.Authorize(); !errors.Is(err, kosync.ErrUnauthorized) {
+		t.Fatalf("Authorize before Register = %v, want ErrUnauthorized", err)
+	}
+	if err := client.Register(); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := client.Register(); !errors.Is(err, kosync.ErrUserExists) {
+		t.Fatalf("second Register = %v, want ErrUserExists", err)
+	}
+	if err := client.Authorize(); err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	wrong := kosync.NewClient(ts.URL, "reader", kosync.Key("guess"))
+	if err := wrong.Authorize(); !errors.Is(err, kosync.ErrUnauthorized) {
+		t.Fatalf("Authorize with a wrong password = %v, want ErrUnauthorized", err)
+	}
+
+	progress, err := client.GetProgress("0123456789abcdef")
+	if err != nil || progress != nil {
+		t.Fatalf("GetProgress before a push = %+v, %v, want nil", progress, err)
+	}
+
+	pushed := kosync.Progress{
+		Document:   "0123456789abcdef",
+		Progress:   "/body/DocFragment[2]/body/p[3]/text().12",
+		Percentage: 0.25,
+		Device:     "laptop",
+		DeviceID:   "device-1",
+	}
+	if err := client.UpdateProgress(pushed); err != nil {
+		t.Fatalf("UpdateProgress: %v", err)
+	}
+	if err := wrong.UpdateProgress(pushed); !errors.Is(err, kosync.ErrUnauthorized) {
+		t.Fatalf("UpdateProgress with a wrong password = %v, want ErrUnauthorized", err)
+	}
+
+	progress, err = client.GetProgress(pushed.Document)
+	if err != nil {
+		t.Fatalf("GetProgress: %v", err)
+	}
+	if progress == nil || progress.Timestamp == 0 {
+		t.Fatalf("GetProgress = %+v, want the pushed progress with a timestamp", progress)
+	}
+	progress.Timestamp = 0
+	if *progress != pushed {
+		t.Errorf("GetProgress = %+v, want %+v", *progress, pushed)
+	}
+}
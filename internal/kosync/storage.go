@@ -0,0 +1,83 @@
+package kosync
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/edfun317/ereader/internal/core"
+)
+
+// Storage syncs reading progress through a KOReader sync server and keeps
+// everything else, along with a local copy of the progress, in the wrapped
+// storage. When the server cannot be reached the local copy is used.
+type Storage struct {
+	core.Storage
+	client   *Client
+	device   string
+	deviceID string
+}
+
+// NewStorage wraps base, reporting progress as coming from the named device
+func NewStorage(base core.Storage, client *Client, device, deviceID string) *Storage {
+	return &Storage{Storage: base, client: client, device: device, deviceID: deviceID}
+}
+
+// Progress implements core.Storage. The progress another device pushed
+// after the local progress was saved wins; it comes without a Location, as
+// only the reader can resolve its XPointer.
+func (s *Storage) Progress(book core.BookRef) (*core.Progress, error) {
+	local, err := s.Storage.Progress(book)
+	if err != nil {
+		return nil, err
+	}
+	if book.ID.Hash == "" {
+		return local, nil
+	}
+
+	remote, err := s.client.GetProgress(book.ID.Hash)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to sync progress: %v\n", err)
+		return local, nil
+	}
+	if remote == nil || remote.DeviceID == s.deviceID {
+		return local, nil
+	}
+	updated := time.Unix(remote.Timestamp, 0)
+	if local != nil && !updated.After(local.Updated) {
+		return local, nil
+	}
+	return &core.Progress{
+		Book:       book.ID,
+		FilePath:   book.Path,
+		XPointer:   remote.Progress,
+		Percentage: remote.Percentage,
+		Device:     remote.Device,
+		Updated:    updated,
+	}, nil
+}
+
+// SaveProgress implements core.Storage, pushing progress that has an
+// XPointer to the server
+func (s *Storage) SaveProgress(book core.BookRef, progress core.Progress) error {
+	if err := s.Storage.SaveProgress(book, progress); err != nil {
+		return err
+	}
+	// Without a page count the share read is unknown, and sending a rough
+	// one would overwrite a better position from another device
+	if book.ID.Hash == "" || progress.XPointer == "" || progress.Percentage == 0 {
+		return nil
+	}
+
+	err := s.client.UpdateProgress(Progress{
+		Document:   book.ID.Hash,
+		Progress:   progress.XPointer,
+		Percentage: progress.Percentage,
+		Device:     s.device,
+		DeviceID:   s.deviceID,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to sync progress: %v\n", err)
+	}
+	return nil
+}
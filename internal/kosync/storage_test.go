@@ -0,0 +1,71 @@
+package kosync_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/edfun317/ereader/internal/core"
+	"github.com/edfun317/ereader/internal/kosync"
+	"github.com/edfun317/ereader/internal/storage"
+)
+
+func TestStorageProgress(t *testing.T) {
+	ts := newTestServer(t)
+	client := kosync.NewClient(ts.URL, "reader", kosync.Key("secret"))
+	if err := client.Register(); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	laptop := kosync.NewStorage(storage.NewJSONStorage(t.TempDir()), client, "laptop", "device-1")
+	phone := kosync.NewStorage(storage.NewJSONStorage(t.TempDir()), client, "phone", "device-2")
+	book := core.BookRef{ID: core.BookID{Hash: "0123456789abcdef"}, Path: "/books/a.epub"}
+
+	save := func(s *kosync.Storage, xpointer string, percentage float64, updated time.Time) {
+		t.Helper()
+		err := s.SaveProgress(book, core.Progress{
+			Location:   &core.Location{Chapter: 1, Offset: 40},
+			XPointer:   xpointer,
+			Percentage: percentage,
+			Updated:    updated,
+		})
+		if err != nil {
+			t.Fatalf("SaveProgress: %v", err)
+		}
+	}
+	load := func(s *kosync.Storage) *core.Progress {
+		t.Helper()
+		progress, err := s.Progress(book)
+		if err != nil {
+			t.Fatalf("Progress: %v", err)
+		}
+		if progress == nil {
+			t.Fatal("Progress = nil")
+		}
+		return progress
+	}
+
+	// The laptop's own push is not taken for another device's progress
+	save(laptop, "/body/DocFragment[2]/body/p[1]/text().4", 0.2, time.Now().Add(-time.Hour))
+	if got := load(laptop); got.Location == nil || got.Device != "" {
+		t.Errorf("progress pushed by this device: got %+v, want the local copy", got)
+	}
+
+	// The phone pushes a position after the laptop's
+	save(phone, "/body/DocFragment[3]/body/p[2]/text().8", 0.5, time.Now().Add(-time.Hour))
+	if got := load(laptop); got.XPointer != "/body/DocFragment[3]/body/p[2]/text().8" || got.Location != nil || got.Device != "phone" {
+		t.Errorf("newer remote progress: got %+v, want the phone's without a Location", got)
+	}
+
+	// A position saved on the laptop after the push wins. Without a page
+	// count it is not pushed, leaving the phone's on the server.
+	save(laptop, "/body/DocFragment[4]/body/p[1]/text().2", 0, time.Now().Add(time.Hour))
+	if got := load(laptop); got.Location == nil || got.XPointer != "/body/DocFragment[4]/body/p[1]/text().2" {
+		t.Errorf("newer local progress: got %+v, want the local copy", got)
+	}
+	remote, err := client.GetProgress(book.ID.Hash)
+	if err != nil {
+		t.Fatalf("GetProgress: %v", err)
+	}
+	if remote == nil || remote.DeviceID != "device-2" {
+		t.Errorf("server progress = %+v, want the phone's", remote)
+	}
+}
@@ -0,0 +1,249 @@
+// Package library keeps a catalog of the books found in the directories
+// the user reads from.
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/edfun317/ereader/internal/config"
+	"github.com/edfun317/ereader/internal/core"
+	"github.com/edfun317/ereader/internal/fileutil"
+)
+
+// Book is a catalogued book file
+type Book struct {
+	ID          core.BookID `json:"id"`
+	Path        string      `json:"path"`
+	Title       string      `json:"title"`
+	Author      string      `json:"author,omitempty"`
+	Series      string      `json:"series,omitempty"`
+	SeriesIndex string      `json:"series_index,omitempty"`
+	Publisher   string      `json:"publisher,omitempty"`
+	Published   string      `json:"published,omitempty"`
+	Language    string      `json:"language,omitempty"`
+	ISBN        string      `json:"isbn,omitempty"`
+	Subjects    []string    `json:"subjects,omitempty"`
+	Tags        []string    `json:"tags,omitempty"` // Added by the user
+	Description string      `json:"description,omitempty"`
+	Cover       string      `json:"cover,omitempty"` // Cached copy of the cover image
+	Chapters    int         `json:"chapters"`
+	Size        int64       `json:"size"`
+	ModTime     time.Time   `json:"mod_time"` // Of the file when it was read
+	Added       time.Time   `json:"added"`
+	LastOpened  time.Time   `json:"last_opened,omitempty"`
+	Progress    float64     `json:"progress"`          // Share read, from 0 to 1
+	Missing     bool        `json:"missing,omitempty"` // The file was gone at the last scan
+}
+
+// Catalog is the list of known books
+type Catalog struct {
+	Books       []Book       `json:"books"`
+	Collections []Collection `json:"collections,omitempty"`
+}
+
+// Path returns the location of the catalog file
+func Path() (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "library.json"), nil
+}
+
+// Load reads the catalog, which is empty when none was saved yet
+func Load() (*Catalog, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	return load(path)
+}
+
+func load(path string) (*Catalog, error) {
+	c := &Catalog{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read library: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Update runs fn on the catalog under a lock and saves the result, so
+// concurrent scans do not lose each other's changes
+func Update(fn func(*Catalog) error) error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	lock, err := fileutil.Acquire(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	c, err := load(path)
+	if err != nil {
+		return err
+	}
+	if err := fn(c); err != nil {
+		return err
+	}
+	c.sort()
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal library: %w", err)
+	}
+	return fileutil.WriteAtomic(path, data, 0644)
+}
+
+func (c *Catalog) sort() {
+	sort.SliceStable(c.Books, func(i, j int) bool {
+		return c.Books[i].Path < c.Books[j].Path
+	})
+}
+
+// Find returns the book a user refers to by its path, by a prefix of its
+// hash or by words of its title and author matching no other book
+func (c *Catalog) Find(ref string) (*Book, error) {
+	if abs, err := filepath.Abs(ref); err == nil {
+		for i := range c.Books {
+			if c.Books[i].Path == abs {
+				return &c.Books[i], nil
+			}
+		}
+	}
+
+	var matches []*Book
+	if len(ref) >= 6 {
+		for i := range c.Books {
+			if strings.HasPrefix(c.Books[i].ID.Hash, strings.ToLower(ref)) {
+				matches = append(matches, &c.Books[i])
+			}
+		}
+	}
+	if len(matches) == 0 {
+		for _, i := range c.search(ref) {
+			matches = append(matches, &c.Books[i])
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no book in the library matches %q", ref)
+	case 1:
+		return matches[0], nil
+	}
+	return nil, fmt.Errorf("%d books match %q; use the path or hash to pick one", len(matches), ref)
+}
+
+// Search returns the books every word of the query occurs in, looking at
+// the title, authors, series, subjects, tags and file name
+func (c *Catalog) Search(query string) []Book {
+	var books []Book
+	for _, i := range c.search(query) {
+		books = append(books, c.Books[i])
+	}
+	return books
+}
+
+func (c *Catalog) search(query string) []int {
+	words := strings.Fields(strings.ToLower(query))
+	var found []int
+	for i, b := range c.Books {
+		fields := []string{b.Title, b.Author, b.Series, b.Publisher, b.ISBN, filepath.Base(b.Path)}
+		fields = append(append(fields, b.Subjects...), b.Tags...)
+		text := strings.ToLower(strings.Join(fields, "\n"))
+		matched := len(words) > 0
+		for _, w := range words {
+			if !strings.Contains(text, w) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+// Remove drops a book from the catalog and its collections along with its
+// cached cover; the book file itself is left alone
+func (c *Catalog) Remove(path string) bool {
+	for i, b := range c.Books {
+		if b.Path == path {
+			if b.Cover != "" && !c.coverShared(b.Cover, path) {
+				os.Remove(b.Cover)
+			}
+			for _, name := range c.CollectionsOf(path) {
+				c.Uncollect(name, path)
+			}
+			c.Books = append(c.Books[:i], c.Books[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// coverShared reports whether another copy of a book uses the same cover
+func (c *Catalog) coverShared(cover, path string) bool {
+	for _, b := range c.Books {
+		if b.Path != path && b.Cover == cover {
+			return true
+		}
+	}
+	return false
+}
+
+// Refresh updates when each book was last opened and how far it was read
+// from the reading state in store
+func (c *Catalog) Refresh(store core.Storage) error {
+	history, err := store.History()
+	if err != nil {
+		return err
+	}
+	opened := make(map[string]time.Time)
+	for _, entry := range history {
+		if key := entry.Book.Key(); key != "" && entry.Opened.After(opened[key]) {
+			opened[key] = entry.Opened
+		}
+	}
+
+	for i := range c.Books {
+		b := &c.Books[i]
+		b.LastOpened = opened[b.ID.Key()]
+		progress, err := store.Progress(core.BookRef{ID: b.ID, Path: b.Path})
+		if err != nil {
+			return err
+		}
+		switch {
+		case progress == nil:
+			b.Progress = 0
+		case progress.Percentage > 0:
+			b.Progress = progress.Percentage
+		case progress.Location != nil && b.Chapters > 0:
+			// No page count was known when it was saved. The chapter gives
+			// a rough share that stays below 1, as only reaching the last
+			// page finishes a book.
+			b.Progress = float64(progress.Location.Chapter) / float64(b.Chapters)
+		default:
+			b.Progress = 0
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package library
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Automatic collections, which books fall into by how far they were read
+const (
+	Reading  = "Currently Reading"
+	Unread   = "Unread"
+	Finished = "Finished"
+)
+
+// Shelves lists the automatic collections in the order they are shown
+var Shelves = []string{Reading, Unread, Finished}
+
+// Collection is a named, ordered list of books, such as a reading list
+type Collection struct {
+	Name  string   `json:"name"`
+	Books []string `json:"books"` // Paths, in reading order
+}
+
+// Shelf returns the automatic collection a book is in. Progress counts the
+// page being read, so a book reaches 1 on its last page.
+func (b Book) Shelf() string {
+	switch {
+	case b.Progress >= 1:
+		return Finished
+	case b.Progress > 0 || !b.LastOpened.IsZero():
+		return Reading
+	}
+	return Unread
+}
+
+// HasTag reports whether a book carries a tag, ignoring case
+func (b Book) HasTag(tag string) bool {
+	return slices.IndexFunc(b.Tags, func(t string) bool { return strings.EqualFold(t, tag) }) >= 0
+}
+
+// Tag adds tags to a book, returning how many it did not have yet
+func (b *Book) Tag(tags ...string) int {
+	added := 0
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !b.HasTag(tag) {
+			b.Tags = append(b.Tags, tag)
+			added++
+		}
+	}
+	sort.Slice(b.Tags, func(i, j int) bool { return strings.ToLower(b.Tags[i]) < strings.ToLower(b.Tags[j]) })
+	return added
+}
+
+// Untag removes tags from a book, returning how many it had
+func (b *Book) Untag(tags ...string) int {
+	removed := 0
+	for _, tag := range tags {
+		if i := slices.IndexFunc(b.Tags, func(t string) bool { return strings.EqualFold(t, strings.TrimSpace(tag)) }); i >= 0 {
+			b.Tags = slices.Delete(b.Tags, i, i+1)
+			removed++
+		}
+	}
+	if len(b.Tags) == 0 {
+		b.Tags = nil
+	}
+	return removed
+}
+
+// Tags returns every tag in use with the number of books carrying it
+func (c *Catalog) Tags() map[string]int {
+	counts := make(map[string]int)
+	spelling := make(map[string]string) // Tags differing only in case count as one
+	for _, b := range c.Books {
+		for _, tag := range b.Tags {
+			key := strings.ToLower(tag)
+			if _, ok := spelling[key]; !ok {
+				spelling[key] = tag
+			}
+			counts[spelling[key]]++
+		}
+	}
+	return counts
+}
+
+// isShelf reports whether name is one of the automatic collections
+func isShelf(name string) (string, bool) {
+	for _, shelf := range Shelves {
+		if strings.EqualFold(shelf, name) {
+			return shelf, true
+		}
+	}
+	return "", false
+}
+
+// Collection returns the user collection with the given name, ignoring
+// case, or nil
+func (c *Catalog) Collection(name string) *Collection {
+	for i := range c.Collections {
+		if strings.EqualFold(c.Collections[i].Name, name) {
+			return &c.Collections[i]
+		}
+	}
+	return nil
+}
+
+// Collect appends books to a collection, creating it when needed, and
+// returns how many were not in it yet
+func (c *Catalog) Collect(name string, paths ...string) (int, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, fmt.Errorf("a collection needs a name")
+	}
+	if shelf, ok := isShelf(name); ok {
+		return 0, fmt.Errorf("%q is filled automatically from reading progress", shelf)
+	}
+	collection := c.Collection(name)
+	if collection == nil {
+		c.Collections = append(c.Collections, Collection{Name: name})
+		collection = &c.Collections[len(c.Collections)-1]
+	}
+	added := 0
+	for _, path := range paths {
+		if !slices.Contains(collection.Books, path) {
+			collection.Books = append(collection.Books, path)
+			added++
+		}
+	}
+	return added, nil
+}
+
+// Uncollect takes books out of a collection, dropping the collection when
+// it is left empty, and returns how many were in it
+func (c *Catalog) Uncollect(name string, paths ...string) (int, error) {
+	collection := c.Collection(name)
+	if collection == nil {
+		return 0, fmt.Errorf("no collection named %q", name)
+	}
+	removed := 0
+	for _, path := range paths {
+		if i := slices.Index(collection.Books, path); i >= 0 {
+			collection.Books = slices.Delete(collection.Books, i, i+1)
+			removed++
+		}
+	}
+	if len(collection.Books) == 0 {
+		c.DeleteCollection(collection.Name)
+	}
+	return removed, nil
+}
+
+// DeleteCollection drops a collection, leaving its books in the library
+func (c *Catalog) DeleteCollection(name string) bool {
+	for i := range c.Collections {
+		if strings.EqualFold(c.Collections[i].Name, name) {
+			c.Collections = slices.Delete(c.Collections, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+// CollectionsOf returns the names of the user collections holding the book
+// at path
+func (c *Catalog) CollectionsOf(path string) []string {
+	var names []string
+	for _, collection := range c.Collections {
+		if slices.Contains(collection.Books, path) {
+			names = append(names, collection.Name)
+		}
+	}
+	return names
+}
+
+// movePath follows a book to a new path in the collections holding it
+func (c *Catalog) movePath(from, to string) {
+	for i := range c.Collections {
+		for j, path := range c.Collections[i].Books {
+			if path == from {
+				c.Collections[i].Books[j] = to
+			}
+		}
+	}
+}
+
+// Filter selects books by tag and by collection; empty values match all
+type Filter struct {
+	Tag        string
+	Collection string // A user collection or one of the Shelves
+}
+
+// Select returns the books matching the filter. Books of a user collection
+// come in the collection's order; others keep the catalog order.
+func (c *Catalog) Select(f Filter) ([]Book, error) {
+	books := c.Books
+	if f.Collection != "" {
+		shelf, automatic := isShelf(f.Collection)
+		switch collection := c.Collection(f.Collection); {
+		case automatic:
+			books = nil
+			for _, b := range c.Books {
+				if b.Shelf() == shelf {
+					books = append(books, b)
+				}
+			}
+		case collection != nil:
+			books = nil
+			for _, path := range collection.Books {
+				if i := slices.IndexFunc(c.Books, func(b Book) bool { return b.Path == path }); i >= 0 {
+					books = append(books, c.Books[i])
+				}
+			}
+		default:
+			return nil, fmt.Errorf("no collection named %q", f.Collection)
+		}
+	}
+	if f.Tag == "" {
+		return books, nil
+	}
+	var tagged []Book
+	for _, b := range books {
+		if b.HasTag(f.Tag) {
+			tagged = append(tagged, b)
+		}
+	}
+	return tagged, nil
+}
@@ -0,0 +1,209 @@
+package library
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/edfun317/ereader/internal/core"
+	"github.com/edfun317/ereader/internal/fileutil"
+)
+
+// Scanner finds books in directories and reads their metadata
+type Scanner struct {
+	// Extensions of the files to catalog, lower case with the dot
+	Extensions []string
+	// NewReader returns a reader for a book file
+	NewReader func(path string) core.BookReader
+	// CoverDir is where cover images are cached; empty to skip covers
+	CoverDir string
+}
+
+// ScanResult reports what a scan changed
+type ScanResult struct {
+	Added, Updated, Moved, Missing, Unchanged int
+	Errors                                    []error // Files that could not be read
+}
+
+// Scan walks dirs and brings the catalog up to date: new files are added,
+// changed ones re-read, and books whose file is gone are marked missing or,
+// when the same file turns up elsewhere, moved
+func (s *Scanner) Scan(c *Catalog, dirs []string) (ScanResult, error) {
+	var result ScanResult
+	seen := make(map[string]bool)
+
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if path == dir {
+					return err
+				}
+				return nil // Skip unreadable subdirectories
+			}
+			if d.IsDir() {
+				if path != dir && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !slices.Contains(s.Extensions, strings.ToLower(filepath.Ext(path))) {
+				return nil
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil || seen[abs] {
+				return nil
+			}
+			seen[abs] = true
+			if err := s.scanFile(c, abs, &result); err != nil {
+				result.Errors = append(result.Errors, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return result, fmt.Errorf("failed to scan %s: %w", dir, err)
+		}
+	}
+
+	for i := range c.Books {
+		b := &c.Books[i]
+		if seen[b.Path] {
+			continue
+		}
+		_, err := os.Stat(b.Path)
+		if missing := err != nil; missing != b.Missing {
+			b.Missing = missing
+			if missing {
+				result.Missing++
+			}
+		}
+	}
+	return result, nil
+}
+
+func (s *Scanner) scanFile(c *Catalog, path string, result *ScanResult) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	index := slices.IndexFunc(c.Books, func(b Book) bool { return b.Path == path })
+	if index >= 0 {
+		b := c.Books[index]
+		if b.Size == info.Size() && b.ModTime.Equal(info.ModTime()) {
+			c.Books[index].Missing = false
+			result.Unchanged++
+			return nil
+		}
+	}
+
+	hash, err := core.PartialMD5(path)
+	if err != nil {
+		return err
+	}
+	if index < 0 {
+		// A catalogued file that is gone and has turned up here was moved
+		for i, b := range c.Books {
+			if b.ID.Hash != hash || b.Path == path {
+				continue
+			}
+			if _, err := os.Stat(b.Path); err == nil {
+				continue // A second copy
+			}
+			c.movePath(b.Path, path)
+			c.Books[i].Path = path
+			c.Books[i].ModTime = info.ModTime()
+			c.Books[i].Missing = false
+			result.Moved++
+			return nil
+		}
+	}
+
+	book, err := s.readBook(path, info)
+	if err != nil {
+		return err
+	}
+	if index >= 0 {
+		old := c.Books[index]
+		book.Added, book.LastOpened, book.Progress = old.Added, old.LastOpened, old.Progress
+		book.Tags = old.Tags
+		c.Books[index] = book
+		result.Updated++
+		return nil
+	}
+	c.Books = append(c.Books, book)
+	result.Added++
+	return nil
+}
+
+// readBook reads the metadata of a book file
+func (s *Scanner) readBook(path string, info os.FileInfo) (Book, error) {
+	reader := s.NewReader(path)
+	if _, err := reader.Open(path); err != nil {
+		return Book{}, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer reader.Close()
+
+	metadata := reader.GetMetadata()
+	id, err := core.IdentifyBook(path, metadata)
+	if err != nil {
+		return Book{}, err
+	}
+	title := metadata.Title
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	book := Book{
+		ID:          id,
+		Path:        path,
+		Title:       title,
+		Author:      metadata.Author,
+		Series:      metadata.Series,
+		SeriesIndex: metadata.SeriesIndex,
+		Publisher:   metadata.Publisher,
+		Published:   metadata.Published,
+		Language:    metadata.Language,
+		ISBN:        metadata.ISBN(),
+		Subjects:    metadata.Subjects,
+		Description: metadata.Description,
+		Chapters:    reader.GetTotalChapters(),
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		Added:       time.Now(),
+	}
+	if covers, ok := reader.(core.CoverReader); ok && s.CoverDir != "" {
+		book.Cover = s.saveCover(covers, id.Hash)
+	}
+	return book, nil
+}
+
+// saveCover caches the cover of a book, returning its path or "" when the
+// book has none
+func (s *Scanner) saveCover(covers core.CoverReader, hash string) string {
+	data, mediaType, err := covers.Cover()
+	if err != nil {
+		return ""
+	}
+	ext := ".img"
+	switch mediaType {
+	case "image/jpeg":
+		ext = ".jpg"
+	case "image/png":
+		ext = ".png"
+	case "image/gif":
+		ext = ".gif"
+	case "image/svg+xml":
+		ext = ".svg"
+	case "image/webp":
+		ext = ".webp"
+	}
+	path := filepath.Join(s.CoverDir, hash+ext)
+	if err := fileutil.WriteAtomic(path, data, 0644); err != nil {
+		return ""
+	}
+	return path
+}
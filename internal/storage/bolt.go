@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/edfun317/ereader/internal/core"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStorage keeps reading state in a bbolt database, state.db. bbolt
+// allows one process to hold a database open, so it is opened for each
+// call and other readers wait for it.
+type BoltStorage struct {
+	path string
+}
+
+// NewBoltStorage stores state in a database in dir
+func NewBoltStorage(dir string) *BoltStorage {
+	return &BoltStorage{path: filepath.Join(dir, "state.db")}
+}
+
+// Buckets of the database; all but history and paths are keyed by book key
+var (
+	progressBucket    = []byte("progress")
+	bookmarksBucket   = []byte("bookmarks")
+	annotationsBucket = []byte("annotations")
+	historyBucket     = []byte("history")
+	pathsBucket       = []byte("paths") // File path to the book key last seen there
+
+	bookBuckets = [][]byte{progressBucket, bookmarksBucket, annotationsBucket}
+)
+
+// boltTimeout is how long to wait for another process to close the database
+const boltTimeout = 10 * time.Second
+
+// bookmarksRecord is the value stored in the bookmarks bucket
+type bookmarksRecord struct {
+	Book      core.BookID     `json:"book"`
+	FilePath  string          `json:"file_path"`
+	Bookmarks []core.Bookmark `json:"bookmarks"`
+}
+
+func (s *BoltStorage) open() (*bolt.DB, error) {
+	db, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: boltTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", s.path, err)
+	}
+	return db, nil
+}
+
+func (s *BoltStorage) view(fn func(tx *bolt.Tx) error) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(fn)
+}
+
+func (s *BoltStorage) update(fn func(tx *bolt.Tx) error) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, name := range append(append([][]byte{}, bookBuckets...), historyBucket, pathsBucket) {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return fn(tx)
+	})
+}
+
+// find returns the key of a book in a bucket; see findKey
+func find(tx *bolt.Tx, bucket []byte, book core.BookRef) (string, bool) {
+	b := tx.Bucket(bucket)
+	if b == nil {
+		return "", false
+	}
+	ids := make(map[string]core.BookID)
+	b.ForEach(func(k, v []byte) error {
+		var record struct {
+			Book core.BookID `json:"book"`
+		}
+		json.Unmarshal(v, &record)
+		ids[string(k)] = record.Book
+		return nil
+	})
+	paths := make(map[string]string)
+	if p := tx.Bucket(pathsBucket); p != nil {
+		if key := p.Get([]byte(book.Path)); key != nil {
+			paths[book.Path] = string(key)
+		}
+	}
+	return findKey(book, ids, paths)
+}
+
+// get decodes the record of a book in a bucket, reporting whether one exists
+func get(tx *bolt.Tx, bucket []byte, book core.BookRef, v any) (bool, error) {
+	key, ok := find(tx, bucket, book)
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(tx.Bucket(bucket).Get([]byte(key)), v); err != nil {
+		return false, fmt.Errorf("failed to decode %s of %s: %w", bucket, key, err)
+	}
+	return true, nil
+}
+
+// put stores the record of a book under its current key, moving all of the
+// book's records when they were stored under another key
+func put(tx *bolt.Tx, bucket []byte, book core.BookRef, v any) error {
+	key := book.Key()
+	if old, ok := find(tx, bucket, book); ok && old != key {
+		for _, name := range bookBuckets {
+			b := tx.Bucket(name)
+			if data := b.Get([]byte(old)); data != nil {
+				if err := b.Put([]byte(key), data); err != nil {
+					return err
+				}
+				if err := b.Delete([]byte(old)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if book.Path != "" {
+		if err := tx.Bucket(pathsBucket).Put([]byte(book.Path), []byte(key)); err != nil {
+			return err
+		}
+	}
+	if v == nil {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", bucket, err)
+	}
+	return tx.Bucket(bucket).Put([]byte(key), data)
+}
+
+// Books implements core.Storage
+func (s *BoltStorage) Books() ([]core.BookRef, error) {
+	var books []core.BookRef
+	seen := make(map[string]bool)
+	err := s.view(func(tx *bolt.Tx) error {
+		for _, name := range bookBuckets {
+			b := tx.Bucket(name)
+			if b == nil {
+				continue
+			}
+			err := b.ForEach(func(k, v []byte) error {
+				var record struct {
+					Book     core.BookID `json:"book"`
+					FilePath string      `json:"file_path"`
+				}
+				if err := json.Unmarshal(v, &record); err != nil {
+					return fmt.Errorf("failed to decode %s of %s: %w", name, k, err)
+				}
+				if !seen[string(k)] {
+					seen[string(k)] = true
+					books = append(books, core.BookRef{ID: record.Book, Path: record.FilePath})
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return books, err
+}
+
+// Progress implements core.Storage
+func (s *BoltStorage) Progress(book core.BookRef) (*core.Progress, error) {
+	var progress core.Progress
+	var found bool
+	err := s.view(func(tx *bolt.Tx) (err error) {
+		found, err = get(tx, progressBucket, book, &progress)
+		return err
+	})
+	if err != nil || !found || !hasProgress(progress) {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// SaveProgress implements core.Storage
+func (s *BoltStorage) SaveProgress(book core.BookRef, progress core.Progress) error {
+	progress.Book = book.ID
+	progress.FilePath = book.Path
+	return s.update(func(tx *bolt.Tx) error {
+		return put(tx, progressBucket, book, progress)
+	})
+}
+
+// Bookmarks implements core.Storage
+func (s *BoltStorage) Bookmarks(book core.BookRef) ([]core.Bookmark, error) {
+	var record bookmarksRecord
+	err := s.view(func(tx *bolt.Tx) error {
+		_, err := get(tx, bookmarksBucket, book, &record)
+		return err
+	})
+	return record.Bookmarks, err
+}
+
+// SaveBookmarks implements core.Storage
+func (s *BoltStorage) SaveBookmarks(book core.BookRef, bookmarks []core.Bookmark, since time.Time) ([]core.Bookmark, error) {
+	var merged []core.Bookmark
+	err := s.update(func(tx *bolt.Tx) error {
+		var record bookmarksRecord
+		if _, err := get(tx, bookmarksBucket, book, &record); err != nil {
+			return err
+		}
+		merged = core.MergeBookmarks(bookmarks, record.Bookmarks, since)
+		if len(merged) == 0 {
+			return put(tx, bookmarksBucket, book, nil)
+		}
+		return put(tx, bookmarksBucket, book, bookmarksRecord{
+			Book:      book.ID,
+			FilePath:  book.Path,
+			Bookmarks: merged,
+		})
+	})
+	return merged, err
+}
+
+// Annotations implements core.Storage
+func (s *BoltStorage) Annotations(book core.BookRef) (*core.BookAnnotations, error) {
+	var annotations core.BookAnnotations
+	var found bool
+	err := s.view(func(tx *bolt.Tx) (err error) {
+		found, err = get(tx, annotationsBucket, book, &annotations)
+		return err
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	return &annotations, nil
+}
+
+// AllAnnotations implements core.Storage
+func (s *BoltStorage) AllAnnotations() ([]core.BookAnnotations, error) {
+	var all []core.BookAnnotations
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(annotationsBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var annotations core.BookAnnotations
+			if err := json.Unmarshal(v, &annotations); err != nil {
+				return fmt.Errorf("failed to decode annotations of %s: %w", k, err)
+			}
+			all = append(all, annotations)
+			return nil
+		})
+	})
+	return all, err
+}
+
+// SaveAnnotations implements core.Storage
+func (s *BoltStorage) SaveAnnotations(book core.BookRef, annotations core.BookAnnotations, since time.Time) (*core.BookAnnotations, error) {
+	var saved *core.BookAnnotations
+	err := s.update(func(tx *bolt.Tx) error {
+		var stored core.BookAnnotations
+		if _, err := get(tx, annotationsBucket, book, &stored); err != nil {
+			return err
+		}
+		annotations.Highlights = core.MergeHighlights(annotations.Highlights, stored.Highlights, since)
+		if len(annotations.Highlights) == 0 {
+			return put(tx, annotationsBucket, book, nil)
+		}
+		annotations.Book = book.ID
+		annotations.FilePath = book.Path
+		saved = &annotations
+		return put(tx, annotationsBucket, book, annotations)
+	})
+	return saved, err
+}
+
+// AddHistory implements core.Storage
+func (s *BoltStorage) AddHistory(entry core.HistoryEntry) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+
+		// Skip entries already recorded, as when migrating twice
+		duplicate, count := false, 0
+		b.ForEach(func(k, v []byte) error {
+			var e core.HistoryEntry
+			if json.Unmarshal(v, &e) == nil && e.Book == entry.Book && e.Opened.Equal(entry.Opened) {
+				duplicate = true
+			}
+			count++
+			return nil
+		})
+		if duplicate {
+			return nil
+		}
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode history: %w", err)
+		}
+		if err := b.Put(sequenceKey(seq), data); err != nil {
+			return err
+		}
+
+		// Drop the oldest entries beyond the limit
+		for excess := count + 1 - historyLimit; excess > 0; excess-- {
+			k, _ := b.Cursor().First()
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// History implements core.Storage
+func (s *BoltStorage) History() ([]core.HistoryEntry, error) {
+	var history []core.HistoryEntry
+	err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var entry core.HistoryEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("failed to decode history: %w", err)
+			}
+			history = append(history, entry)
+		}
+		return nil
+	})
+	return history, err
+}
+
+// Close implements core.Storage; the database is only open during calls
+func (s *BoltStorage) Close() error {
+	return nil
+}
+
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
@@ -0,0 +1,367 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/edfun317/ereader/internal/core"
+	"github.com/edfun317/ereader/internal/fileutil"
+)
+
+// JSONStorage keeps reading state in JSON files: progress.json for
+// positions and bookmarks, annotations.json and history.json. Each file is
+// guarded by an advisory lock and re-read before every change, so several
+// reader processes can share them.
+type JSONStorage struct {
+	dir string
+}
+
+// NewJSONStorage stores state in files in dir
+func NewJSONStorage(dir string) *JSONStorage {
+	return &JSONStorage{dir: dir}
+}
+
+type (
+	progressFile struct {
+		Progresses map[string]progressEntry `json:"progresses"`
+		Paths      map[string]string        `json:"paths,omitempty"` // File path to the book key last seen there
+	}
+
+	// progressEntry keeps a book's bookmarks with its position
+	progressEntry struct {
+		core.Progress
+		Bookmarks []core.Bookmark `json:"bookmarks,omitempty"`
+	}
+
+	annotationsFile struct {
+		Books map[string]core.BookAnnotations `json:"books"`
+	}
+
+	historyFile struct {
+		Entries []core.HistoryEntry `json:"entries"` // Oldest first
+	}
+)
+
+const (
+	progressFileName    = "progress.json"
+	annotationsFileName = "annotations.json"
+	historyFileName     = "history.json"
+)
+
+// withFile reads a JSON file under its lock, runs fn on the contents and,
+// when write is set, replaces the file with the result
+func withFile[T any](path string, write bool, fn func(*T) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	lock, err := fileutil.Acquire(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	var contents T
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &contents); err != nil {
+			// Keep the damaged file for inspection and start afresh
+			backup, backupErr := fileutil.Backup(path)
+			if backupErr != nil {
+				return backupErr
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %s was corrupt and was moved to %s\n", filepath.Base(path), backup)
+			var empty T
+			contents = empty
+		}
+	}
+
+	if err := fn(&contents); err != nil {
+		return err
+	}
+	if !write {
+		return nil
+	}
+
+	data, err = json.MarshalIndent(contents, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", filepath.Base(path), err)
+	}
+	return fileutil.WriteAtomic(path, data, 0644)
+}
+
+func (s *JSONStorage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// progress runs fn on the progress file, rewriting it when write is set
+func (s *JSONStorage) progress(write bool, fn func(*progressFile) error) error {
+	return withFile(s.path(progressFileName), write, func(f *progressFile) error {
+		f.migrate()
+		return fn(f)
+	})
+}
+
+func (s *JSONStorage) annotations(write bool, fn func(*annotationsFile) error) error {
+	return withFile(s.path(annotationsFileName), write, func(f *annotationsFile) error {
+		f.migrate()
+		return fn(f)
+	})
+}
+
+// migrate rekeys entries saved by file path under the content hash of the
+// file, when it still exists
+func (f *progressFile) migrate() {
+	if f.Progresses == nil {
+		f.Progresses = make(map[string]progressEntry)
+	}
+	if f.Paths == nil {
+		f.Paths = make(map[string]string)
+	}
+	for key, entry := range f.Progresses {
+		if entry.Book.Hash != "" {
+			continue
+		}
+		if entry.FilePath == "" {
+			entry.FilePath = key
+		}
+		id, ok := identify(entry.FilePath)
+		if !ok {
+			continue
+		}
+		entry.Book = id
+		delete(f.Progresses, key)
+		if _, exists := f.Progresses[id.Key()]; !exists {
+			f.Progresses[id.Key()] = entry
+		}
+		f.Paths[entry.FilePath] = id.Key()
+	}
+}
+
+func (f *progressFile) find(book core.BookRef) (string, bool) {
+	ids := make(map[string]core.BookID, len(f.Progresses))
+	for key, entry := range f.Progresses {
+		ids[key] = entry.Book
+	}
+	return findKey(book, ids, f.Paths)
+}
+
+// entry returns the state of a book, moving it to the book's current key
+// when it was found under another
+func (f *progressFile) entry(book core.BookRef) progressEntry {
+	key, ok := f.find(book)
+	if !ok {
+		return progressEntry{}
+	}
+	entry := f.Progresses[key]
+	if key != book.Key() {
+		delete(f.Progresses, key)
+	}
+	return entry
+}
+
+func (f *progressFile) put(book core.BookRef, entry progressEntry) {
+	entry.Book = book.ID
+	entry.FilePath = book.Path
+	f.Progresses[book.Key()] = entry
+	if book.Path != "" {
+		f.Paths[book.Path] = book.Key()
+	}
+}
+
+func (f *annotationsFile) migrate() {
+	if f.Books == nil {
+		f.Books = make(map[string]core.BookAnnotations)
+	}
+	for key, entry := range f.Books {
+		if entry.Book.Hash != "" {
+			continue
+		}
+		if entry.FilePath == "" {
+			entry.FilePath = key
+		}
+		id, ok := identify(entry.FilePath)
+		if !ok {
+			continue
+		}
+		entry.Book = id
+		delete(f.Books, key)
+		if _, exists := f.Books[id.Key()]; !exists {
+			f.Books[id.Key()] = entry
+		}
+	}
+}
+
+func (f *annotationsFile) find(book core.BookRef) (string, bool) {
+	ids := make(map[string]core.BookID, len(f.Books))
+	paths := make(map[string]string, len(f.Books))
+	for key, entry := range f.Books {
+		ids[key] = entry.Book
+		paths[entry.FilePath] = key
+	}
+	return findKey(book, ids, paths)
+}
+
+// Books implements core.Storage
+func (s *JSONStorage) Books() ([]core.BookRef, error) {
+	var books []core.BookRef
+	seen := make(map[string]bool)
+	add := func(key string, id core.BookID, path string) {
+		if !seen[key] {
+			seen[key] = true
+			books = append(books, core.BookRef{ID: id, Path: path})
+		}
+	}
+
+	err := s.progress(false, func(f *progressFile) error {
+		for key, entry := range f.Progresses {
+			add(key, entry.Book, entry.FilePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.annotations(false, func(f *annotationsFile) error {
+		for key, entry := range f.Books {
+			add(key, entry.Book, entry.FilePath)
+		}
+		return nil
+	})
+	return books, err
+}
+
+// Progress implements core.Storage
+func (s *JSONStorage) Progress(book core.BookRef) (*core.Progress, error) {
+	var progress *core.Progress
+	err := s.progress(false, func(f *progressFile) error {
+		if key, ok := f.find(book); ok {
+			p := f.Progresses[key].Progress
+			if hasProgress(p) {
+				progress = &p
+			}
+		}
+		return nil
+	})
+	return progress, err
+}
+
+// SaveProgress implements core.Storage
+func (s *JSONStorage) SaveProgress(book core.BookRef, progress core.Progress) error {
+	return s.progress(true, func(f *progressFile) error {
+		entry := f.entry(book)
+		entry.Progress = progress
+		f.put(book, entry)
+		return nil
+	})
+}
+
+// Bookmarks implements core.Storage
+func (s *JSONStorage) Bookmarks(book core.BookRef) ([]core.Bookmark, error) {
+	var bookmarks []core.Bookmark
+	err := s.progress(false, func(f *progressFile) error {
+		if key, ok := f.find(book); ok {
+			bookmarks = f.Progresses[key].Bookmarks
+		}
+		return nil
+	})
+	return bookmarks, err
+}
+
+// SaveBookmarks implements core.Storage
+func (s *JSONStorage) SaveBookmarks(book core.BookRef, bookmarks []core.Bookmark, since time.Time) ([]core.Bookmark, error) {
+	var merged []core.Bookmark
+	err := s.progress(true, func(f *progressFile) error {
+		entry := f.entry(book)
+		merged = core.MergeBookmarks(bookmarks, entry.Bookmarks, since)
+		entry.Bookmarks = merged
+		f.put(book, entry)
+		return nil
+	})
+	return merged, err
+}
+
+// Annotations implements core.Storage
+func (s *JSONStorage) Annotations(book core.BookRef) (*core.BookAnnotations, error) {
+	var annotations *core.BookAnnotations
+	err := s.annotations(false, func(f *annotationsFile) error {
+		if key, ok := f.find(book); ok {
+			a := f.Books[key]
+			annotations = &a
+		}
+		return nil
+	})
+	return annotations, err
+}
+
+// AllAnnotations implements core.Storage
+func (s *JSONStorage) AllAnnotations() ([]core.BookAnnotations, error) {
+	var all []core.BookAnnotations
+	err := s.annotations(false, func(f *annotationsFile) error {
+		for _, a := range f.Books {
+			all = append(all, a)
+		}
+		return nil
+	})
+	return all, err
+}
+
+// SaveAnnotations implements core.Storage
+func (s *JSONStorage) SaveAnnotations(book core.BookRef, annotations core.BookAnnotations, since time.Time) (*core.BookAnnotations, error) {
+	var saved *core.BookAnnotations
+	err := s.annotations(true, func(f *annotationsFile) error {
+		var stored []core.Highlight
+		if key, ok := f.find(book); ok {
+			stored = f.Books[key].Highlights
+			delete(f.Books, key)
+		}
+		annotations.Highlights = core.MergeHighlights(annotations.Highlights, stored, since)
+		if len(annotations.Highlights) == 0 {
+			return nil
+		}
+		annotations.Book = book.ID
+		annotations.FilePath = book.Path
+		f.Books[book.Key()] = annotations
+		saved = &annotations
+		return nil
+	})
+	return saved, err
+}
+
+// AddHistory implements core.Storage
+func (s *JSONStorage) AddHistory(entry core.HistoryEntry) error {
+	return withFile(s.path(historyFileName), true, func(f *historyFile) error {
+		for _, e := range f.Entries {
+			if e.Book == entry.Book && e.Opened.Equal(entry.Opened) {
+				return nil
+			}
+		}
+		f.Entries = append(f.Entries, entry)
+		if len(f.Entries) > historyLimit {
+			f.Entries = f.Entries[len(f.Entries)-historyLimit:]
+		}
+		return nil
+	})
+}
+
+// History implements core.Storage
+func (s *JSONStorage) History() ([]core.HistoryEntry, error) {
+	var history []core.HistoryEntry
+	err := withFile(s.path(historyFileName), false, func(f *historyFile) error {
+		for i := len(f.Entries) - 1; i >= 0; i-- {
+			history = append(history, f.Entries[i])
+		}
+		return nil
+	})
+	return history, err
+}
+
+// Close implements core.Storage; the files are only open during calls
+func (s *JSONStorage) Close() error {
+	return nil
+}
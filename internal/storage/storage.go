@@ -0,0 +1,217 @@
+// Package storage implements core.Storage on JSON files and on an
+// embedded bbolt database.
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/edfun317/ereader/internal/config"
+	"github.com/edfun317/ereader/internal/core"
+	"github.com/edfun317/ereader/internal/dirsync"
+	"github.com/edfun317/ereader/internal/kosync"
+)
+
+// Backend names, as used in the config file
+const (
+	JSON = "json"
+	Bolt = "bolt"
+)
+
+// Backends lists the available backends
+var Backends = []string{JSON, Bolt}
+
+// historyLimit is the number of history entries kept
+const historyLimit = 500
+
+// Open opens the named backend with its files in dir
+func Open(backend, dir string) (core.Storage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	switch backend {
+	case JSON:
+		return NewJSONStorage(dir), nil
+	case Bolt:
+		return NewBoltStorage(dir), nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q (available: json, bolt)", backend)
+}
+
+// OpenLocal opens the backend selected in the config file without any
+// syncing, for commands that only read state
+func OpenLocal() (core.Storage, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := config.StateDir()
+	if err != nil {
+		return nil, err
+	}
+	return Open(cfg.Storage, dir)
+}
+
+// syncTimeout bounds the progress sync requests made when opening and
+// closing a book
+const syncTimeout = 5 * time.Second
+
+// OpenDefault opens the backend selected in the config file, merging the
+// changes of other devices when a sync directory is set up and syncing
+// progress through a KOReader sync server when an account is set up
+func OpenDefault() (core.Storage, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := config.StateDir()
+	if err != nil {
+		return nil, err
+	}
+	store, err := Open(cfg.Storage, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if folder := cfg.Folder; folder != nil {
+		synced := dirsync.NewStorage(store, folder.Dir, folder.DeviceID)
+		if _, err := synced.Merge(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to sync with %s: %v\n", folder.Dir, err)
+		}
+		store = synced
+	}
+	if cfg.Sync == nil {
+		return store, nil
+	}
+
+	sync := cfg.Sync
+	client := kosync.NewClient(sync.Server, sync.Username, sync.UserKey, kosync.WithTimeout(syncTimeout))
+	return kosync.NewStorage(store, client, sync.Device, sync.DeviceID), nil
+}
+
+// MigrateStats counts what Migrate copied
+type MigrateStats struct {
+	Books, Bookmarks, Highlights, History int
+}
+
+// Migrate copies all reading state from one storage to another. State
+// already in the destination is merged with the copied state.
+func Migrate(from, to core.Storage) (MigrateStats, error) {
+	var stats MigrateStats
+	books, err := from.Books()
+	if err != nil {
+		return stats, err
+	}
+
+	for _, book := range books {
+		progress, err := from.Progress(book)
+		if err != nil {
+			return stats, err
+		}
+		if progress != nil {
+			if err := to.SaveProgress(book, *progress); err != nil {
+				return stats, err
+			}
+		}
+
+		bookmarks, err := from.Bookmarks(book)
+		if err != nil {
+			return stats, err
+		}
+		if len(bookmarks) > 0 {
+			// A zero sync time keeps the bookmarks of both sides
+			if _, err := to.SaveBookmarks(book, bookmarks, time.Time{}); err != nil {
+				return stats, err
+			}
+		}
+
+		annotations, err := from.Annotations(book)
+		if err != nil {
+			return stats, err
+		}
+		if annotations != nil {
+			merged := *annotations
+			if existing, err := to.Annotations(book); err != nil {
+				return stats, err
+			} else if existing != nil {
+				merged = *existing
+				for _, h := range annotations.Highlights {
+					merged.Add(h)
+				}
+			}
+			// A zero sync time keeps the highlights of both sides
+			if _, err := to.SaveAnnotations(book, merged, time.Time{}); err != nil {
+				return stats, err
+			}
+			stats.Highlights += len(annotations.Highlights)
+		}
+
+		stats.Books++
+		stats.Bookmarks += len(bookmarks)
+	}
+
+	history, err := from.History()
+	if err != nil {
+		return stats, err
+	}
+	// History is returned newest first; add the oldest first
+	for i := len(history) - 1; i >= 0; i-- {
+		if err := to.AddHistory(history[i]); err != nil {
+			return stats, err
+		}
+	}
+	stats.History = len(history)
+	return stats, nil
+}
+
+// hasProgress reports whether a saved entry holds a position, in any of the
+// forms it may have been saved in. The JSON backend keeps bookmarks in the
+// same entry, so one may exist without a position.
+func hasProgress(p core.Progress) bool {
+	return p.Location != nil || p.Position != nil || p.CFI != "" || p.XPointer != "" || p.Percentage > 0
+}
+
+// findKey returns the key a book's state is stored under among entries
+// with the given identities: the one with the same content hash, else one
+// declaring the same unique identifier, else the one last seen at the same
+// path, else one stored by earlier versions under the path itself
+func findKey(book core.BookRef, ids map[string]core.BookID, paths map[string]string) (string, bool) {
+	if key := book.ID.Key(); key != "" {
+		if _, ok := ids[key]; ok {
+			return key, true
+		}
+	}
+	if book.ID.UniqueID != "" {
+		keys := make([]string, 0, len(ids))
+		for key := range ids {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if ids[key].UniqueID == book.ID.UniqueID {
+				return key, true
+			}
+		}
+	}
+	if key, ok := paths[book.Path]; ok && book.Path != "" {
+		if _, ok := ids[key]; ok {
+			return key, true
+		}
+	}
+	if _, ok := ids[book.Path]; ok && book.Path != "" {
+		return book.Path, true
+	}
+	return "", false
+}
+
+// identify computes the identity of a book stored by earlier versions
+// under its path, when the file still exists
+func identify(path string) (core.BookID, bool) {
+	hash, err := core.PartialMD5(path)
+	if err != nil {
+		return core.BookID{}, false
+	}
+	return core.BookID{Hash: hash}, true
+}
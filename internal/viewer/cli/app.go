@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"time"
 
+	colors "github.com/edfun317/ereader/internal/color"
 	"github.com/edfun317/ereader/internal/core"
 )
 
@@ -16,9 +18,30 @@ type (
 		scanner     *bufio.Scanner
 		currentPos  CurrentPos
 		pageSize    int
+		lineWidth   int
+		margin      int // Minimum blank columns on each side of the text
+		maxWidth    int // Upper bound for the text column, 0 for no limit
+		termCols    int
+		termRows    int
+		theme       string // Name of the color scheme
+		codeScroll  int    // Columns code lines are scrolled sideways
+		scrollPage  CurrentPos
+		search      *searchState
+		bookmarks   []core.Bookmark
+		highlights  []core.Highlight
+		selection   *selection // Passage being selected, nil when reading
+		layouts     *layoutCache
+		counter     *pageCounter // Background page count, nil when not running
 		shouldExit  bool
 		input       *os.File
 		currentFile string // Add this field to store current file path
+		bookID      core.BookID
+		storage     core.Storage // Reading state; opened from the config when not given
+		// When progress and highlights were last read or written, to merge
+		// with other instances
+		progressSynced   time.Time
+		highlightsSynced time.Time
+		syncedFrom       string // Device the saved position came from, if another
 	}
 	CurrentPos struct {
 		Chapter int `json:"chapter"`
@@ -26,14 +49,60 @@ type (
 	}
 )
 
-func NewCLIViewer(reader core.BookReader) *CLIViewer {
+// Option configures a CLIViewer
+type Option func(*CLIViewer)
 
-	return &CLIViewer{
-		reader:   reader,
-		pageSize: 20, // Default lines per page
+// WithMargin sets the minimum number of blank columns on each side of the text
+func WithMargin(columns int) Option {
+	return func(v *CLIViewer) {
+		v.margin = max(columns, 0)
 	}
 }
 
+// WithMaxWidth limits the width of the text column; 0 uses the whole terminal
+func WithMaxWidth(columns int) Option {
+	return func(v *CLIViewer) {
+		v.maxWidth = max(columns, 0)
+	}
+}
+
+// WithColorScheme selects one of the predefined color schemes by name;
+// unknown names keep the default
+func WithColorScheme(name string) Option {
+	return func(v *CLIViewer) {
+		if _, ok := colors.PredefinedSchemes[name]; ok {
+			v.theme = name
+		}
+	}
+}
+
+// WithStorage keeps reading state in the given storage instead of the one
+// selected in the config file
+func WithStorage(storage core.Storage) Option {
+	return func(v *CLIViewer) {
+		v.storage = storage
+	}
+}
+
+func NewCLIViewer(reader core.BookReader, opts ...Option) *CLIViewer {
+
+	v := &CLIViewer{
+		reader:    reader,
+		pageSize:  20, // Default lines per page, replaced by the terminal height
+		lineWidth: 80,
+		margin:    2,
+		maxWidth:  80,
+		termCols:  defaultColumns,
+		termRows:  defaultRows,
+		theme:     "default",
+		layouts:   newLayoutCache(),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
 // Initialize input handling
 func (v *CLIViewer) initializeInput() error {
 	// Open /dev/tty for Unix systems or CONIN$ for Windows
@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/edfun317/ereader/internal/core"
+	"github.com/fatih/color"
+)
+
+// excerptWidth is the number of columns of page text kept with a bookmark
+const excerptWidth = 40
+
+// addBookmark asks for a name and bookmarks the start of the current page
+func (v *CLIViewer) addBookmark() error {
+	layout, err := v.chapterLayout(v.currentPos.Chapter)
+	if err != nil {
+		return err
+	}
+	if v.currentPos.Page < 0 || v.currentPos.Page >= len(layout.pages) {
+		return nil
+	}
+
+	page := layout.pages[v.currentPos.Page]
+	excerpt := truncateWidth(string(newSearchText(page).original), excerptWidth)
+
+	fmt.Println()
+	name, ok, err := readLine("Bookmark name (Enter for excerpt): ", nil)
+	if err != nil || !ok {
+		return err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = excerpt
+	}
+
+	v.bookmarks = append(v.bookmarks, core.Bookmark{
+		Name:    name,
+		Chapter: v.currentPos.Chapter,
+		Offset:  layout.pageOffsets[v.currentPos.Page],
+		Excerpt: excerpt,
+		Created: time.Now(),
+	})
+	return v.saveBookmarks()
+}
+
+// showBookmarks lists the bookmarks in reading order to jump to or delete
+func (v *CLIViewer) showBookmarks() error {
+	if len(v.bookmarks) == 0 {
+		return v.showMessage("No bookmarks yet; press 'b' to add one")
+	}
+
+	selected := 0
+	for {
+		core.SortBookmarks(v.bookmarks)
+		chapterStyle := color.New(color.Faint)
+		items := make([]string, len(v.bookmarks))
+		for i, b := range v.bookmarks {
+			items[i] = b.Name + chapterStyle.Sprintf("  %s", v.chapterTitle(b.Chapter))
+		}
+
+		index, action, err := v.selectItem("Bookmarks", items, selected,
+			menuAction{key: 'd', label: "delete"})
+		if err != nil || index < 0 {
+			return err
+		}
+
+		switch action {
+		case 'd':
+			v.bookmarks = append(v.bookmarks[:index], v.bookmarks[index+1:]...)
+			if err := v.saveBookmarks(); err != nil {
+				return err
+			}
+			if len(v.bookmarks) == 0 {
+				return nil
+			}
+			selected = min(index, len(v.bookmarks)-1)
+		default:
+			return v.goToOffset(v.bookmarks[index].Chapter, v.bookmarks[index].Offset)
+		}
+	}
+}
+
+// pageBookmarked reports whether a bookmark falls on the current page
+func (v *CLIViewer) pageBookmarked(layout *chapterLayout) bool {
+	page := v.currentPos.Page
+	if page < 0 || page >= len(layout.pageOffsets) {
+		return false
+	}
+	for _, b := range v.bookmarks {
+		if b.Chapter == v.currentPos.Chapter && layout.pageForOffset(b.Offset) == page {
+			return true
+		}
+	}
+	return false
+}
+
+// chapterTitle returns the title of a chapter, or its number when untitled
+func (v *CLIViewer) chapterTitle(index int) string {
+	if chapter, err := v.reader.GetChapter(index); err == nil && chapter.Title != "" {
+		return chapter.Title
+	}
+	return fmt.Sprintf("Chapter %d", index+1)
+}
+
+// goToOffset shows the page of a chapter holding the given text offset
+func (v *CLIViewer) goToOffset(chapter, offset int) error {
+	if chapter < 0 || chapter >= v.reader.GetTotalChapters() {
+		return nil
+	}
+	layout, err := v.chapterLayout(chapter)
+	if err != nil {
+		return err
+	}
+	v.currentPos.Chapter = chapter
+	v.currentPos.Page = layout.pageForOffset(offset)
+	return nil
+}
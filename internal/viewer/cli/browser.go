@@ -0,0 +1,499 @@
+package cli
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/edfun317/ereader/internal/library"
+	"github.com/eiannone/keyboard"
+	"github.com/fatih/color"
+)
+
+// Orders and groupings the browser cycles through
+var (
+	browserSorts  = []string{"title", "author", "recent", "progress"}
+	browserGroups = []string{"none", "series", "author", "subject", "tag", "collection", "shelf"}
+)
+
+const (
+	// Lines used by the heading and filter above the list and the status
+	// and help below it
+	browserChromeLines = 4
+	// Lines of the details of the selected book
+	browserDetailLines = 7
+	progressBarWidth   = 30
+)
+
+// Browser is a full-screen list of the books in the library that opens the
+// chosen one in the reader and comes back to the list when it is closed
+type Browser struct {
+	load     func() (*library.Catalog, error) // Reads the catalog with current progress
+	open     func(path string) error          // Reads a book until the reader quits
+	catalog  *library.Catalog
+	rows     []browserRow // Visible rows: group headings and books
+	selected int          // Row of the selected book
+	sortBy   string
+	group    string
+	filter   string
+	status   string // Message shown until the next key
+	termCols int
+	termRows int
+}
+
+// browserRow is a line of the list: a group heading or a book
+type browserRow struct {
+	heading string
+	book    *library.Book
+}
+
+// NewBrowser returns a browser over the catalog load returns, reading books
+// with open
+func NewBrowser(load func() (*library.Catalog, error), open func(path string) error) *Browser {
+	return &Browser{
+		load:     load,
+		open:     open,
+		sortBy:   browserSorts[0],
+		group:    browserGroups[0],
+		termCols: defaultColumns,
+		termRows: defaultRows,
+	}
+}
+
+// Run shows the browser until it is left with Ctrl-C or with Esc, which
+// first clears the filter if there is one; letters typed go into the filter
+func (b *Browser) Run() error {
+	if err := b.reload(""); err != nil {
+		return err
+	}
+	if err := keyboard.Open(); err != nil {
+		return fmt.Errorf("failed to initialize keyboard: %w", err)
+	}
+	defer keyboard.Close()
+
+	resized, stopWatching := watchResize()
+	defer stopWatching()
+
+	for {
+		b.updateSize()
+		b.display()
+
+		keys, err := keyboard.GetKeys(10)
+		if err != nil {
+			return fmt.Errorf("keyboard error: %w", err)
+		}
+		select {
+		case event := <-keys:
+			if event.Err != nil {
+				return fmt.Errorf("keyboard error: %w", event.Err)
+			}
+			quit, err := b.handleKey(event.Rune, event.Key)
+			if err != nil || quit {
+				return err
+			}
+		case <-resized:
+		}
+	}
+}
+
+// reload reads the catalog again, keeping the book at path selected
+func (b *Browser) reload(path string) error {
+	catalog, err := b.load()
+	if err != nil {
+		return err
+	}
+	b.catalog = catalog
+	b.arrange(path)
+	return nil
+}
+
+func (b *Browser) handleKey(char rune, key keyboard.Key) (bool, error) {
+	b.status = ""
+	switch key {
+	case keyboard.KeyCtrlC:
+		return true, nil
+	case keyboard.KeyEsc:
+		if b.filter == "" {
+			return true, nil
+		}
+		b.filter = ""
+		b.arrange(b.selectedPath())
+	case keyboard.KeyArrowUp:
+		b.move(-1)
+	case keyboard.KeyArrowDown:
+		b.move(1)
+	case keyboard.KeyPgup:
+		b.move(-b.listHeight())
+	case keyboard.KeyPgdn:
+		b.move(b.listHeight())
+	case keyboard.KeyHome:
+		b.move(-len(b.rows))
+	case keyboard.KeyEnd:
+		b.move(len(b.rows))
+	case keyboard.KeyTab:
+		b.sortBy = nextValue(browserSorts, b.sortBy)
+		b.arrange(b.selectedPath())
+	case keyboard.KeyCtrlG:
+		b.group = nextValue(browserGroups, b.group)
+		b.arrange(b.selectedPath())
+	case keyboard.KeyBackspace, keyboard.KeyBackspace2:
+		if runes := []rune(b.filter); len(runes) > 0 {
+			b.filter = string(runes[:len(runes)-1])
+			b.arrange(b.selectedPath())
+		}
+	case keyboard.KeyEnter:
+		return false, b.openSelected()
+	case keyboard.KeySpace:
+		if b.filter != "" {
+			b.filter += " "
+		}
+	default:
+		if char != 0 {
+			b.filter += string(char)
+			b.arrange(b.selectedPath())
+		}
+	}
+	return false, nil
+}
+
+// nextValue returns the entry after current in values, wrapping around
+func nextValue(values []string, current string) string {
+	for i, v := range values {
+		if v == current {
+			return values[(i+1)%len(values)]
+		}
+	}
+	return values[0]
+}
+
+// openSelected reads the selected book, then shows the list again with the
+// progress made
+func (b *Browser) openSelected() error {
+	book := b.selectedBook()
+	if book == nil {
+		return nil
+	}
+	if book.Missing {
+		b.status = fmt.Sprintf("%s is missing; rescan the library to find it", book.Path)
+		return nil
+	}
+
+	// The reader takes over the keyboard until it quits
+	path := book.Path
+	keyboard.Close()
+	err := b.open(path)
+	if openErr := keyboard.Open(); openErr != nil {
+		return fmt.Errorf("failed to initialize keyboard: %w", openErr)
+	}
+	if err != nil {
+		b.status = fmt.Sprintf("Failed to read %s: %v", path, err)
+	}
+	return b.reload(path)
+}
+
+// arrange filters, sorts and groups the books into rows, selecting the book
+// at path or else the first one. A book in several groups, such as one
+// with two tags, is listed under each.
+func (b *Browser) arrange(path string) {
+	books := b.catalog.Books
+	if strings.TrimSpace(b.filter) != "" {
+		books = b.catalog.Search(b.filter)
+	}
+
+	type entry struct {
+		group string
+		book  *library.Book
+	}
+	var entries []entry
+	for i := range books {
+		groups := b.groups(&books[i])
+		if len(groups) == 0 {
+			groups = []string{""}
+		}
+		for _, group := range groups {
+			entries = append(entries, entry{group, &books[i]})
+		}
+	}
+	less := b.less()
+	rank := b.groupRank()
+	sort.SliceStable(entries, func(i, j int) bool {
+		if gi, gj := entries[i].group, entries[j].group; gi != gj {
+			return rank(gi, gj)
+		}
+		return less(entries[i].book, entries[j].book)
+	})
+
+	b.rows = b.rows[:0]
+	for i, e := range entries {
+		if b.group != "none" && (i == 0 || entries[i-1].group != e.group) {
+			heading := e.group
+			if heading == "" {
+				heading = "No " + b.group
+			}
+			b.rows = append(b.rows, browserRow{heading: heading})
+		}
+		b.rows = append(b.rows, browserRow{book: e.book})
+	}
+
+	b.selected = -1
+	for i, row := range b.rows {
+		if row.book == nil {
+			continue
+		}
+		if b.selected < 0 {
+			b.selected = i
+		}
+		if row.book.Path == path {
+			b.selected = i
+			break
+		}
+	}
+}
+
+// less returns the order of books within a group
+func (b *Browser) less() func(x, y *library.Book) bool {
+	byTitle := func(x, y *library.Book) bool {
+		return strings.ToLower(x.Title) < strings.ToLower(y.Title)
+	}
+	switch b.sortBy {
+	case "author":
+		return func(x, y *library.Book) bool {
+			if ax, ay := strings.ToLower(x.Author), strings.ToLower(y.Author); ax != ay {
+				return ax < ay
+			}
+			return byTitle(x, y)
+		}
+	case "recent":
+		return func(x, y *library.Book) bool {
+			if !x.LastOpened.Equal(y.LastOpened) {
+				return x.LastOpened.After(y.LastOpened)
+			}
+			return x.Added.After(y.Added)
+		}
+	case "progress":
+		return func(x, y *library.Book) bool {
+			if x.Progress != y.Progress {
+				return x.Progress > y.Progress
+			}
+			return byTitle(x, y)
+		}
+	}
+	return byTitle
+}
+
+// groups returns the names of the groups a book is listed under
+func (b *Browser) groups(book *library.Book) []string {
+	switch b.group {
+	case "series":
+		return []string{book.Series}
+	case "author":
+		return []string{book.Author}
+	case "subject":
+		return book.Subjects // As declared in the book's metadata
+	case "tag":
+		return book.Tags // As added with "ereader library tag"
+	case "collection":
+		return b.catalog.CollectionsOf(book.Path)
+	case "shelf":
+		return []string{book.Shelf()}
+	}
+	return nil
+}
+
+// groupRank returns the order of group headings: the automatic shelves in
+// their usual order, others alphabetically with the books outside any
+// group last
+func (b *Browser) groupRank() func(x, y string) bool {
+	if b.group == "shelf" {
+		return func(x, y string) bool {
+			return slices.Index(library.Shelves, x) < slices.Index(library.Shelves, y)
+		}
+	}
+	return func(x, y string) bool {
+		if x == "" || y == "" {
+			return y == ""
+		}
+		return strings.ToLower(x) < strings.ToLower(y)
+	}
+}
+
+// move shifts the selection by delta books, skipping group headings
+func (b *Browser) move(delta int) {
+	if b.selected < 0 {
+		return
+	}
+	step := 1
+	if delta < 0 {
+		step, delta = -1, -delta
+	}
+	for i := b.selected + step; i >= 0 && i < len(b.rows) && delta > 0; i += step {
+		if b.rows[i].book != nil {
+			b.selected = i
+			delta--
+		}
+	}
+}
+
+func (b *Browser) selectedBook() *library.Book {
+	if b.selected < 0 || b.selected >= len(b.rows) {
+		return nil
+	}
+	return b.rows[b.selected].book
+}
+
+func (b *Browser) selectedPath() string {
+	if book := b.selectedBook(); book != nil {
+		return book.Path
+	}
+	return ""
+}
+
+func (b *Browser) updateSize() {
+	cols, rows, err := terminalSize()
+	if err != nil || cols <= 0 || rows <= 0 {
+		cols, rows = defaultColumns, defaultRows
+	}
+	b.termCols, b.termRows = cols, rows
+}
+
+// listHeight is the number of rows of the list shown at once
+func (b *Browser) listHeight() int {
+	return max(b.termRows-browserChromeLines-browserDetailLines-1, minPageSize)
+}
+
+func (b *Browser) display() {
+	clearScreen()
+	heading := color.New(color.FgCyan, color.Bold)
+	faint := color.New(color.Faint)
+	marker := color.New(color.FgBlack, color.BgCyan)
+
+	heading.Print("=== Library ===")
+	faint.Printf("  %d books · sorted by %s · grouped by %s\n", len(b.catalog.Books), b.sortBy, b.group)
+	if b.filter != "" {
+		fmt.Printf("Filter: %s\n", b.filter)
+	} else {
+		faint.Println("Type to filter")
+	}
+
+	height := b.listHeight()
+	switch {
+	case len(b.catalog.Books) == 0:
+		fmt.Println(`The library is empty; add books with "ereader library scan <dir>"`)
+		height--
+	case len(b.rows) == 0:
+		fmt.Println("No books match the filter")
+		height--
+	}
+
+	// Keep the selection visible inside a window of height rows
+	start := 0
+	if b.selected >= height {
+		start = b.selected - height + 1
+	}
+	end := min(start+height, len(b.rows))
+	width := max(b.termCols-2, minLineWidth)
+	for i := start; i < end; i++ {
+		row := b.rows[i]
+		if row.book == nil {
+			heading.Println(truncateWidth(row.heading, width))
+			continue
+		}
+		line := b.bookLine(row.book, width)
+		switch {
+		case i == b.selected:
+			marker.Printf("> %s\n", line)
+		case row.book.Missing:
+			faint.Printf("  %s\n", line)
+		default:
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	for i := end - start; i < height; i++ {
+		fmt.Println()
+	}
+
+	b.displayDetails(width)
+
+	if b.status != "" {
+		color.New(color.FgYellow).Println(truncateWidth(b.status, width))
+	} else {
+		fmt.Println()
+	}
+	color.New(color.FgYellow).Println("↑/↓ to move, Enter to read, Tab to sort, Ctrl-G to group, Esc to quit")
+}
+
+// bookLine formats a book as a line of the list, width columns wide
+func (b *Browser) bookLine(book *library.Book, width int) string {
+	percent := fmt.Sprintf("%3.0f%%", book.Progress*100)
+	if book.Missing {
+		percent = "missing"
+	}
+	title := book.Title
+	if book.Series != "" && book.SeriesIndex != "" && b.group == "series" {
+		title = book.SeriesIndex + ". " + title
+	}
+	text := title
+	if book.Author != "" && b.group != "author" {
+		text += " — " + book.Author
+	}
+	available := max(width-displayWidth(percent)-1, 1)
+	text = truncateWidth(text, available)
+	return text + strings.Repeat(" ", available-displayWidth(text)+1) + percent
+}
+
+// displayDetails shows the metadata and progress of the selected book
+func (b *Browser) displayDetails(width int) {
+	fmt.Println(strings.Repeat("─", width))
+	book := b.selectedBook()
+	if book == nil {
+		for i := 1; i < browserDetailLines; i++ {
+			fmt.Println()
+		}
+		return
+	}
+
+	lines := []string{color.New(color.Bold).Sprint(truncateWidth(book.Title, width))}
+	byline := book.Author
+	if book.Series != "" {
+		series := book.Series
+		if book.SeriesIndex != "" {
+			series += " #" + book.SeriesIndex
+		}
+		byline = strings.TrimPrefix(byline+" · "+series, " · ")
+	}
+	if book.Published != "" {
+		byline = strings.TrimPrefix(byline+" · "+book.Published, " · ")
+	}
+	lines = append(lines, truncateWidth(byline, width))
+	shelves := append([]string{book.Shelf()}, b.catalog.CollectionsOf(book.Path)...)
+	if len(book.Tags) > 0 {
+		shelves = append(shelves, "tags: "+strings.Join(book.Tags, ", "))
+	}
+	lines = append(lines, truncateWidth(fmt.Sprintf("%s %3.0f%% · %s",
+		progressBar(book.Progress, progressBarWidth), book.Progress*100, strings.Join(shelves, " · ")), width))
+	opened := "never opened"
+	if !book.LastOpened.IsZero() {
+		opened = "opened " + book.LastOpened.Local().Format("2006-01-02 15:04")
+	}
+	lines = append(lines, fmt.Sprintf("%d chapters · %s · added %s",
+		book.Chapters, opened, book.Added.Local().Format("2006-01-02")))
+	lines = append(lines, color.New(color.Faint).Sprint(truncateWidthLeft(book.Path, width)))
+	if book.Description != "" {
+		lines = append(lines, truncateWidth(strings.Join(strings.Fields(book.Description), " "), width))
+	}
+
+	for i := 1; i < browserDetailLines; i++ {
+		if i-1 < len(lines) {
+			fmt.Println(lines[i-1])
+		} else {
+			fmt.Println()
+		}
+	}
+}
+
+// progressBar draws the share read as a bar width columns wide
+func progressBar(fraction float64, width int) string {
+	filled := int(min(max(fraction, 0), 1)*float64(width) + 0.5)
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
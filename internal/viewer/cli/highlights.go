@@ -0,0 +1,378 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/edfun317/ereader/internal/annotation"
+	colors "github.com/edfun317/ereader/internal/color"
+	"github.com/edfun317/ereader/internal/core"
+	"github.com/eiannone/keyboard"
+	"github.com/fatih/color"
+)
+
+// Characters ending a sentence, and closing marks that stay with it
+const (
+	sentenceEnds   = ".!?。！？…"
+	sentenceCloser = "\"'”’)]」』）"
+)
+
+type (
+	// textRange is a passage between two text offsets of a chapter
+	textRange struct {
+		start int
+		end   int
+	}
+
+	// selection is the passage being chosen for a new highlight
+	selection struct {
+		textRange
+		units      []textRange
+		first      int // Index of the first selected unit
+		count      int // Number of selected units, 0 for a search match
+		paragraphs bool
+		color      string
+	}
+)
+
+// textUnits splits rendered lines into paragraphs, or into sentences.
+// Paragraphs are runs of lines with book text separated by blank lines.
+func textUnits(lines []line, sentences bool) []textRange {
+	var units []textRange
+	var group []line
+
+	flush := func() {
+		if len(group) == 0 {
+			return
+		}
+		text := newSearchText(group)
+		group = nil
+		if len(text.original) == 0 {
+			return
+		}
+		if !sentences {
+			units = append(units, textRange{start: text.offsets[0], end: text.offsets[len(text.offsets)-1] + 1})
+			return
+		}
+		units = append(units, text.sentences()...)
+	}
+
+	for _, l := range lines {
+		hasText := false
+		for _, s := range l.spans {
+			if s.offset >= 0 && strings.TrimSpace(s.text) != "" {
+				hasText = true
+				break
+			}
+		}
+		if !hasText {
+			flush()
+			continue
+		}
+		group = append(group, l)
+	}
+	flush()
+	return units
+}
+
+// sentences splits the text after sentence ending punctuation followed by
+// whitespace; CJK full stops end a sentence without a following space
+func (t *searchText) sentences() []textRange {
+	var units []textRange
+	start := -1
+	for i := 0; i < len(t.original); i++ {
+		r := t.original[i]
+		if unicode.IsSpace(r) {
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		if !strings.ContainsRune(sentenceEnds, r) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(t.original) && strings.ContainsRune(sentenceEnds+sentenceCloser, t.original[end]) {
+			end++
+		}
+		wide := runeWidth(r) == 2
+		if end == len(t.original) || unicode.IsSpace(t.original[end]) || wide {
+			units = append(units, textRange{start: t.offsets[start], end: t.offsets[end-1] + 1})
+			start = -1
+			i = end - 1
+		}
+	}
+	if start >= 0 {
+		units = append(units, textRange{start: t.offsets[start], end: t.offsets[len(t.offsets)-1] + 1})
+	}
+	return units
+}
+
+// slice returns the text between two text offsets
+func (t *searchText) slice(r textRange) string {
+	var sb strings.Builder
+	for i, offset := range t.offsets {
+		if offset >= r.end {
+			break
+		}
+		if offset >= r.start && (offset > r.start || !unicode.IsSpace(t.original[i])) {
+			sb.WriteRune(t.original[i])
+		}
+	}
+	return sb.String()
+}
+
+// unitAt returns the index of the unit containing or following offset
+func unitAt(units []textRange, offset int) int {
+	for i, u := range units {
+		if u.end > offset {
+			return i
+		}
+	}
+	return len(units) - 1
+}
+
+// selectPassage lets the reader choose sentences or paragraphs, starting at
+// the search match on the page if there is one, and highlights them
+func (v *CLIViewer) selectPassage() error {
+	layout, err := v.chapterLayout(v.currentPos.Chapter)
+	if err != nil {
+		return err
+	}
+	if v.currentPos.Page < 0 || v.currentPos.Page >= len(layout.pages) {
+		return nil
+	}
+	lines := layout.lines()
+
+	sel := &selection{color: annotation.Colors[0]}
+	sel.units = textUnits(lines, true)
+	if len(sel.units) == 0 {
+		return nil
+	}
+
+	pageStart := layout.pageOffsets[v.currentPos.Page]
+	if hit, ok := v.currentHit(); ok && layout.pageForOffset(hit.start) == v.currentPos.Page {
+		sel.textRange = textRange{start: hit.start, end: hit.end}
+		sel.first = unitAt(sel.units, hit.start)
+	} else {
+		sel.first = unitAt(sel.units, pageStart)
+		sel.count = 1
+		sel.update()
+	}
+
+	v.selection = sel
+	defer func() { v.selection = nil }()
+
+	for {
+		v.currentPos.Page = layout.pageForOffset(sel.start)
+		if err := v.displayCurrentPage(); err != nil {
+			return err
+		}
+
+		char, key, err := keyboard.GetKey()
+		if err != nil {
+			return fmt.Errorf("keyboard error: %w", err)
+		}
+
+		switch {
+		case key == keyboard.KeyArrowRight:
+			sel.move(1)
+		case key == keyboard.KeyArrowLeft:
+			sel.move(-1)
+		case key == keyboard.KeyArrowDown:
+			sel.grow(1)
+		case key == keyboard.KeyArrowUp:
+			sel.grow(-1)
+		case key == keyboard.KeyTab:
+			sel.paragraphs = !sel.paragraphs
+			sel.units = textUnits(lines, !sel.paragraphs)
+			sel.first = unitAt(sel.units, sel.start)
+			sel.count = 1
+			sel.update()
+		case char >= '1' && char < '1'+rune(len(annotation.Colors)):
+			sel.color = annotation.Colors[char-'1']
+		case key == keyboard.KeyEnter:
+			text := newSearchText(lines).slice(sel.textRange)
+			return v.addHighlight(sel.textRange, text, sel.color)
+		case key == keyboard.KeyEsc || char == 'q':
+			return nil
+		}
+	}
+}
+
+// update recomputes the selected range from the selected units
+func (s *selection) update() {
+	last := min(s.first+max(s.count, 1), len(s.units)) - 1
+	s.textRange = textRange{start: s.units[s.first].start, end: s.units[last].end}
+}
+
+// move shifts the selection by delta units
+func (s *selection) move(delta int) {
+	if s.count == 0 {
+		s.count = 1
+	}
+	s.first = min(max(s.first+delta, 0), len(s.units)-s.count)
+	s.update()
+}
+
+// grow adds or removes units at the end of the selection
+func (s *selection) grow(delta int) {
+	if s.count == 0 {
+		s.count = 1
+	}
+	s.count = min(max(s.count+delta, 1), len(s.units)-s.first)
+	s.update()
+}
+
+// addHighlight asks for an optional note and stores the highlight
+func (v *CLIViewer) addHighlight(r textRange, text, colorName string) error {
+	fmt.Println()
+	note, ok, err := readLine("Note (optional): ", nil)
+	if err != nil || !ok {
+		return err
+	}
+
+	now := time.Now()
+	v.highlights = append(v.highlights, core.Highlight{
+		ID:           annotation.NewID(),
+		Chapter:      v.currentPos.Chapter,
+		ChapterTitle: v.chapterTitle(v.currentPos.Chapter),
+		Start:        r.start,
+		End:          r.end,
+		Text:         text,
+		Color:        colorName,
+		Note:         strings.TrimSpace(note),
+		Created:      now,
+		Updated:      now,
+	})
+	core.SortHighlights(v.highlights)
+	return v.saveAnnotations()
+}
+
+// showHighlights lists the highlights of the book to jump to, annotate or delete
+func (v *CLIViewer) showHighlights() error {
+	if len(v.highlights) == 0 {
+		return v.showMessage("No highlights yet; press 'v' to select a passage")
+	}
+
+	selected := 0
+	for {
+		noteStyle := color.New(color.Faint)
+		items := make([]string, len(v.highlights))
+		for i, h := range v.highlights {
+			swatch := color.New(colors.BgColorMap[h.Color]).Sprint(" ")
+			room := max(v.lineWidth-4, 10)
+			if h.Note == "" {
+				items[i] = fmt.Sprintf("%s %s", swatch, truncateWidth(h.Text, room))
+				continue
+			}
+			text := truncateWidth(h.Text, room/2)
+			note := truncateWidth("✎ "+h.Note, room-displayWidth(text)-2)
+			items[i] = fmt.Sprintf("%s %s  %s", swatch, text, noteStyle.Sprint(note))
+		}
+
+		index, action, err := v.selectItem("Highlights", items, selected,
+			menuAction{key: 'e', label: "edit the note"},
+			menuAction{key: 'd', label: "delete"})
+		if err != nil || index < 0 {
+			return err
+		}
+		selected = index
+
+		switch action {
+		case 'e':
+			fmt.Println()
+			note, ok, err := readLine("Note: ", nil)
+			if err != nil {
+				return err
+			}
+			if ok {
+				v.highlights[index].Note = strings.TrimSpace(note)
+				v.highlights[index].Updated = time.Now()
+				if err := v.saveAnnotations(); err != nil {
+					return err
+				}
+				// Saving takes in changes from other instances
+				if len(v.highlights) == 0 {
+					return nil
+				}
+				selected = min(index, len(v.highlights)-1)
+			}
+		case 'd':
+			v.highlights = append(v.highlights[:index], v.highlights[index+1:]...)
+			if err := v.saveAnnotations(); err != nil {
+				return err
+			}
+			if len(v.highlights) == 0 {
+				return nil
+			}
+			selected = min(index, len(v.highlights)-1)
+		default:
+			return v.goToOffset(v.highlights[index].Chapter, v.highlights[index].Start)
+		}
+	}
+}
+
+// markAnnotations paints the highlights, the selection and the current
+// search match of the chapter onto a line
+func (v *CLIViewer) markAnnotations(l line, chapter int) line {
+	for _, h := range v.highlights {
+		if h.Chapter != chapter {
+			continue
+		}
+		bg := colors.BgColorMap[h.Color]
+		l = l.mark(h.Start, h.End, func(s textStyle) textStyle {
+			s.bg = bg
+			s.fg = color.FgBlack
+			return s
+		})
+	}
+	if v.selection != nil {
+		bg := colors.BgColorMap[v.selection.color]
+		l = l.mark(v.selection.start, v.selection.end, func(s textStyle) textStyle {
+			s.bg = bg
+			s.fg = color.FgBlack
+			s.underline = true
+			return s
+		})
+	}
+	return v.markSearchHit(l, chapter)
+}
+
+// loadAnnotations reads the highlights stored for the current book
+func (v *CLIViewer) loadAnnotations() error {
+	v.highlightsSynced = time.Now()
+	annotations, err := v.storage.Annotations(v.bookRef())
+	if err != nil {
+		return fmt.Errorf("failed to load annotations: %w", err)
+	}
+	v.highlights = nil
+	if annotations != nil {
+		v.highlights = annotations.Highlights
+	}
+	return nil
+}
+
+// saveAnnotations stores the highlights of the current book, keeping
+// changes other instances made since this one last synced them
+func (v *CLIViewer) saveAnnotations() error {
+	now := time.Now()
+	metadata := v.reader.GetMetadata()
+	saved, err := v.storage.SaveAnnotations(v.bookRef(), core.BookAnnotations{
+		Title:      metadata.Title,
+		Author:     metadata.Author,
+		Highlights: v.highlights,
+	}, v.highlightsSynced)
+	if err != nil {
+		return fmt.Errorf("failed to save annotations: %w", err)
+	}
+	v.highlights = nil
+	if saved != nil {
+		v.highlights = saved.Highlights
+	}
+	v.highlightsSynced = now
+	return nil
+}
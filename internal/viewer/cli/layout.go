@@ -0,0 +1,300 @@
+package cli
+
+import (
+	"sync"
+
+	colors "github.com/edfun317/ereader/internal/color"
+	"github.com/edfun317/ereader/internal/core"
+)
+
+// maxCachedLayouts bounds how many fully paginated chapters are kept
+const maxCachedLayouts = 16
+
+type (
+	// layoutKey identifies everything a chapter's pagination depends on
+	layoutKey struct {
+		chapter  int
+		width    int
+		pageSize int
+		theme    string
+	}
+
+	// chapterLayout is the formatted and paginated output of one chapter
+	chapterLayout struct {
+		pages       [][]line
+		pageOffsets []int          // Text offset of the first character of each page
+		anchors     map[string]int // Text offset of each anchor ID
+	}
+
+	// layoutCache keeps chapter layouts and per-chapter page counts so that
+	// paging does not re-parse the chapter on every keystroke
+	layoutCache struct {
+		mu         sync.Mutex
+		layouts    map[layoutKey]*chapterLayout
+		pageCounts map[layoutKey]int
+		generation int // Bumped on invalidation to stop stale book counts
+	}
+)
+
+func newLayoutCache() *layoutCache {
+	return &layoutCache{
+		layouts:    make(map[layoutKey]*chapterLayout),
+		pageCounts: make(map[layoutKey]int),
+	}
+}
+
+func (c *layoutCache) get(key layoutKey) (*chapterLayout, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	layout, ok := c.layouts[key]
+	return layout, ok
+}
+
+func (c *layoutCache) put(key layoutKey, layout *chapterLayout) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.layouts) >= maxCachedLayouts {
+		// Drop the layouts farthest from the chapter being stored
+		for k := range c.layouts {
+			if abs(k.chapter-key.chapter) > maxCachedLayouts/2 || k.width != key.width ||
+				k.pageSize != key.pageSize || k.theme != key.theme {
+				delete(c.layouts, k)
+			}
+		}
+	}
+	c.layouts[key] = layout
+	c.pageCounts[key] = len(layout.pages)
+}
+
+func (c *layoutCache) pageCount(key layoutKey) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count, ok := c.pageCounts[key]
+	return count, ok
+}
+
+func (c *layoutCache) setPageCount(key layoutKey, count int, generation int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation == c.generation {
+		c.pageCounts[key] = count
+	}
+}
+
+func (c *layoutCache) currentGeneration() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// invalidate drops every layout, e.g. after a resize or a settings change
+func (c *layoutCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.layouts = make(map[layoutKey]*chapterLayout)
+	c.pageCounts = make(map[layoutKey]int)
+	c.generation++
+}
+
+// layoutKeyFor returns the cache key of a chapter under the current settings
+func (v *CLIViewer) layoutKeyFor(chapter int) layoutKey {
+	return layoutKey{
+		chapter:  chapter,
+		width:    v.lineWidth,
+		pageSize: v.pageSize,
+		theme:    v.theme,
+	}
+}
+
+// chapterLayout returns the paginated chapter, formatting it only on a cache miss
+func (v *CLIViewer) chapterLayout(index int) (*chapterLayout, error) {
+	key := v.layoutKeyFor(index)
+	if layout, ok := v.layouts.get(key); ok {
+		return layout, nil
+	}
+
+	layout, err := v.buildLayout(key)
+	if err != nil {
+		return nil, err
+	}
+	v.layouts.put(key, layout)
+	return layout, nil
+}
+
+// buildLayout formats and paginates a chapter for the settings in key
+func (v *CLIViewer) buildLayout(key layoutKey) (*chapterLayout, error) {
+	chapter, err := v.reader.GetChapter(key.chapter)
+	if err != nil {
+		return nil, err
+	}
+	return layoutChapter(chapter, key), nil
+}
+
+// layoutChapter formats and paginates a loaded chapter
+func layoutChapter(chapter *core.Chapter, key layoutKey) *chapterLayout {
+	lines, anchors := renderDocument(chapter.Document, key.width, colors.PredefinedSchemes[key.theme])
+	pages := paginateLines(lines, key.pageSize)
+
+	layout := &chapterLayout{
+		pages:       pages,
+		pageOffsets: make([]int, len(pages)),
+		anchors:     anchors,
+	}
+	for i, page := range pages {
+		if len(page) > 0 {
+			layout.pageOffsets[i] = page[0].offset
+		}
+	}
+	return layout
+}
+
+// pageForOffset returns the page containing the character at text offset
+func (l *chapterLayout) pageForOffset(offset int) int {
+	page := 0
+	for i, start := range l.pageOffsets {
+		if start > offset {
+			break
+		}
+		page = i
+	}
+	return page
+}
+
+// lines returns every rendered line of the chapter
+func (l *chapterLayout) lines() []line {
+	var lines []line
+	for _, page := range l.pages {
+		lines = append(lines, page...)
+	}
+	return lines
+}
+
+// pageCounter is a running count of the pages of the book
+type pageCounter struct {
+	stop chan struct{} // Closed to cancel the count
+	done chan struct{} // Closed once the count has stopped
+}
+
+// countBookPages lays out every chapter in the background so the footer can
+// show the position within the whole book. Chapters are loaded past the
+// reader's chapter cache, which is left to the chapters near the page read.
+func (v *CLIViewer) countBookPages() {
+	v.stopCountingPages()
+
+	generation := v.layouts.currentGeneration()
+	keys := make([]layoutKey, v.reader.GetTotalChapters())
+	for i := range keys {
+		keys[i] = v.layoutKeyFor(i)
+	}
+	load := v.reader.GetChapter
+	if loader, ok := v.reader.(core.ChapterLoader); ok {
+		load = loader.LoadChapter
+	}
+
+	counter := &pageCounter{stop: make(chan struct{}), done: make(chan struct{})}
+	v.counter = counter
+	go func() {
+		defer close(counter.done)
+		for _, key := range keys {
+			select {
+			case <-counter.stop:
+				return
+			default:
+			}
+			if v.layouts.currentGeneration() != generation {
+				return
+			}
+			if _, ok := v.layouts.pageCount(key); ok {
+				continue
+			}
+			chapter, err := load(key.chapter)
+			if err != nil {
+				v.layouts.setPageCount(key, 0, generation)
+				continue
+			}
+			v.layouts.setPageCount(key, len(layoutChapter(chapter, key).pages), generation)
+		}
+	}()
+}
+
+// stopCountingPages cancels the page count and waits for it to stop, so
+// that the book can be closed
+func (v *CLIViewer) stopCountingPages() {
+	if v.counter != nil {
+		close(v.counter.stop)
+		<-v.counter.done
+		v.counter = nil
+	}
+}
+
+// bookPosition returns the current page number within the whole book and the
+// total number of pages, or ok=false while the count is still running
+func (v *CLIViewer) bookPosition() (page, total int, ok bool) {
+	for i := 0; i < v.reader.GetTotalChapters(); i++ {
+		count, known := v.layouts.pageCount(v.layoutKeyFor(i))
+		if !known {
+			return 0, 0, false
+		}
+		if i < v.currentPos.Chapter {
+			page += count
+		}
+		total += count
+	}
+	return page + v.currentPos.Page + 1, total, true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// relayout re-paginates after a settings change while keeping the first
+// character of the current page on screen
+func (v *CLIViewer) relayout(apply func()) error {
+	anchor := v.location().Offset
+
+	apply()
+	v.layouts.invalidate()
+
+	layout, err := v.chapterLayout(v.currentPos.Chapter)
+	if err != nil {
+		return err
+	}
+	v.currentPos.Page = layout.pageForOffset(anchor)
+	v.countBookPages()
+	return nil
+}
+
+// paginateLines groups rendered lines into pages, never starting a page
+// with blank lines
+func paginateLines(lines []line, pageSize int) [][]line {
+	var pages [][]line
+	var current []line
+
+	for _, l := range lines {
+		if len(current) == 0 && len(l.spans) == 0 {
+			continue
+		}
+		current = append(current, l)
+		if len(current) >= pageSize {
+			pages = append(pages, current)
+			current = nil
+		}
+	}
+	if len(current) > 0 {
+		pages = append(pages, current)
+	}
+
+	if len(pages) == 0 {
+		pages = [][]line{{}}
+	}
+	return pages
+}
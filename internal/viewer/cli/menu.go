@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/eiannone/keyboard"
+	"github.com/fatih/color"
+)
+
+// menuAction is an extra key accepted by selectItem besides Enter
+type menuAction struct {
+	key   rune
+	label string
+}
+
+// selectItem shows a scrollable list and returns the index chosen with
+// Enter or one of the extra actions, together with the action key (0 for
+// Enter). The index is -1 when the list is left with Esc or 'q'.
+func (v *CLIViewer) selectItem(title string, items []string, selected int, actions ...menuAction) (int, rune, error) {
+	if len(items) == 0 {
+		return -1, 0, nil
+	}
+	selected = min(max(selected, 0), len(items)-1)
+	heading := color.New(color.FgCyan)
+	marker := color.New(color.FgCyan, color.Bold)
+
+	for {
+		clearScreen()
+		heading.Printf("=== %s ===\n", title)
+		fmt.Println()
+
+		// Keep the selection visible inside a window of pageSize entries
+		start := 0
+		if selected >= v.pageSize {
+			start = selected - v.pageSize + 1
+		}
+		end := min(start+v.pageSize, len(items))
+
+		for i := start; i < end; i++ {
+			if i == selected {
+				marker.Print("> ")
+			} else {
+				fmt.Print("  ")
+			}
+			fmt.Println(items[i])
+		}
+
+		fmt.Print("\n↑/↓ to move, Enter to jump")
+		for _, action := range actions {
+			fmt.Printf(", '%c' to %s", action.key, action.label)
+		}
+		fmt.Println(", Esc or 'q' to go back")
+
+		char, key, err := keyboard.GetKey()
+		if err != nil {
+			return -1, 0, fmt.Errorf("keyboard error: %w", err)
+		}
+		for _, action := range actions {
+			if char == action.key {
+				return selected, char, nil
+			}
+		}
+
+		switch {
+		case key == keyboard.KeyArrowUp && selected > 0:
+			selected--
+		case key == keyboard.KeyArrowDown && selected < len(items)-1:
+			selected++
+		case key == keyboard.KeyEnter:
+			return selected, 0, nil
+		case key == keyboard.KeyEsc || char == 'q':
+			return -1, 0, nil
+		}
+	}
+}
+
+// readLine prompts for a line of text below the page. It reports false
+// when the input is cancelled with Esc. When onTab is set, Tab calls it
+// and the prompt is replaced by the string it returns.
+func readLine(prompt string, onTab func() string) (string, bool, error) {
+	var input []rune
+	for {
+		fmt.Printf("\r\x1b[K%s%s", prompt, string(input))
+
+		char, key, err := keyboard.GetKey()
+		if err != nil {
+			return "", false, fmt.Errorf("keyboard error: %w", err)
+		}
+
+		switch {
+		case key == keyboard.KeyEnter:
+			fmt.Println()
+			return string(input), true, nil
+		case key == keyboard.KeyEsc || key == keyboard.KeyCtrlC:
+			fmt.Println()
+			return "", false, nil
+		case key == keyboard.KeyBackspace || key == keyboard.KeyBackspace2:
+			if len(input) > 0 {
+				input = input[:len(input)-1]
+			}
+		case key == keyboard.KeyTab:
+			if onTab != nil {
+				prompt = onTab()
+			}
+		case key == keyboard.KeySpace:
+			input = append(input, ' ')
+		case char != 0:
+			input = append(input, char)
+		}
+	}
+}
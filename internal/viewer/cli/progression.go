@@ -1,133 +1,179 @@
 package cli
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
-)
+	"math"
+	"time"
 
-// ProgressStore stores progress for multiple books
-type ProgressStore struct {
-	Progresses map[string]ReadingProgress `json:"progresses"`
-}
+	"github.com/edfun317/ereader/internal/core"
+)
 
-// ReadingProgress stores the reading position for a book
-type ReadingProgress struct {
-	FilePath string     `json:"file_path"`
-	Position CurrentPos `json:"position"`
+// bookRef names the open book in storage
+func (v *CLIViewer) bookRef() core.BookRef {
+	return core.BookRef{ID: v.bookID, Path: v.currentFile}
 }
 
-// saveProgress saves the current reading position to a file
+// saveProgress saves the current reading position
 func (v *CLIViewer) saveProgress() error {
 	if v.currentFile == "" {
 		return fmt.Errorf("no current file set")
 	}
 
-	// Create progress directory if it doesn't exist
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
+	location := v.location()
+	progress := core.Progress{
+		Location: &location,
+		Updated:  time.Now(),
 	}
-
-	progressDir := filepath.Join(homeDir, ".ereader")
-	if err := os.MkdirAll(progressDir, 0755); err != nil {
-		return fmt.Errorf("failed to create progress directory: %w", err)
+	if encoder, ok := v.reader.(core.LocationEncoder); ok {
+		// The CFI is informational; the location alone restores the position
+		progress.CFI, _ = encoder.EncodeLocation(location)
 	}
-
-	progressFile := filepath.Join(progressDir, "progress.json")
-
-	// Read existing progress store or create new one
-	store := ProgressStore{
-		Progresses: make(map[string]ReadingProgress),
+	if encoder, ok := v.reader.(core.XPointerEncoder); ok {
+		progress.XPointer, _ = encoder.XPointer(location)
 	}
-
-	// Try to read existing progress file
-	if data, err := os.ReadFile(progressFile); err == nil {
-		if err := json.Unmarshal(data, &store); err != nil {
-			// If the file is corrupted, start fresh
-			store.Progresses = make(map[string]ReadingProgress)
-		}
+	// Like KOReader, the share read counts the current page; it is left
+	// out while the pages of the book are still being counted
+	if page, total, ok := v.bookPosition(); ok && total > 0 {
+		progress.Percentage = float64(page) / float64(total)
 	}
-
-	// Update or add new progress
-	store.Progresses[v.currentFile] = ReadingProgress{
-		FilePath: v.currentFile,
-		Position: v.currentPos,
+	if err := v.storage.SaveProgress(v.bookRef(), progress); err != nil {
+		return fmt.Errorf("failed to save progress: %w", err)
 	}
+	return nil
+}
 
-	// Marshal the entire store
-	data, err := json.MarshalIndent(store, "", "    ")
+// saveBookmarks saves the bookmarks, keeping changes other instances made
+// since this one last synced them
+func (v *CLIViewer) saveBookmarks() error {
+	now := time.Now()
+	bookmarks, err := v.storage.SaveBookmarks(v.bookRef(), v.bookmarks, v.progressSynced)
 	if err != nil {
-		return fmt.Errorf("failed to marshal progress data: %w", err)
-	}
-
-	// Write to temporary file first
-	tempFile := progressFile + ".tmp"
-	if err := os.WriteFile(tempFile, data, 0644); err != nil {
-		return fmt.Errorf("failed to write temporary progress file: %w", err)
-	}
-
-	// Rename temporary file to actual file (atomic operation)
-	if err := os.Rename(tempFile, progressFile); err != nil {
-		os.Remove(tempFile) // Clean up temp file if rename fails
-		return fmt.Errorf("failed to save progress file: %w", err)
+		return fmt.Errorf("failed to save bookmarks: %w", err)
 	}
-
+	v.bookmarks = bookmarks
+	v.progressSynced = now
 	return nil
 }
 
-// loadProgress loads the reading position from file
+// loadProgress restores the saved reading position and bookmarks
 func (v *CLIViewer) loadProgress() error {
 	if v.currentFile == "" {
 		return fmt.Errorf("no current file set")
 	}
 
-	homeDir, err := os.UserHomeDir()
+	v.progressSynced = time.Now()
+	bookmarks, err := v.storage.Bookmarks(v.bookRef())
 	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
+		return fmt.Errorf("failed to load bookmarks: %w", err)
 	}
+	v.bookmarks = bookmarks
+	core.SortBookmarks(v.bookmarks)
 
-	progressFile := filepath.Join(homeDir, ".ereader", "progress.json")
-	data, err := os.ReadFile(progressFile)
+	progress, err := v.storage.Progress(v.bookRef())
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil // No saved progress, start from beginning
+		return fmt.Errorf("failed to load progress: %w", err)
+	}
+	if progress == nil {
+		return nil // No saved progress, start from beginning
+	}
+
+	if location, ok := v.savedLocation(*progress); ok {
+		if err := v.goToOffset(location.Chapter, location.Offset); err != nil {
+			return err
+		}
+		v.syncedFrom = progress.Device
+	}
+	if progress.Location == nil || progress.Book != v.bookID {
+		// Rewrite entries from earlier versions or of a moved book
+		return v.saveProgress()
+	}
+	return nil
+}
+
+// savedLocation returns the position stored in progress. Without a
+// location, as for progress synced from another device or saved by earlier
+// versions, it is converted from the XPointer, the CFI, the percentage or
+// the page based position.
+func (v *CLIViewer) savedLocation(progress core.Progress) (core.Location, bool) {
+	if progress.Location != nil {
+		return *progress.Location, true
+	}
+	if encoder, ok := v.reader.(core.XPointerEncoder); ok && progress.XPointer != "" {
+		if location, err := encoder.ResolveXPointer(progress.XPointer); err == nil {
+			return location, true
+		}
+	}
+	if encoder, ok := v.reader.(core.LocationEncoder); ok && progress.CFI != "" {
+		if location, err := encoder.DecodeLocation(progress.CFI); err == nil {
+			return location, true
+		}
+	}
+	if progress.Percentage > 0 {
+		if location, ok := v.locationAt(progress.Percentage); ok {
+			return location, true
 		}
-		return fmt.Errorf("failed to read progress file: %w", err)
 	}
 
-	var store ProgressStore
-	if err := json.Unmarshal(data, &store); err != nil {
-		return fmt.Errorf("failed to unmarshal progress data: %w", err)
+	pos := progress.Position
+	if pos == nil || pos.Chapter < 0 || pos.Chapter >= v.reader.GetTotalChapters() {
+		return core.Location{}, false
+	}
+	// The page size the position was saved with is unknown; the current
+	// layout is the best estimate
+	location := core.Location{Chapter: pos.Chapter}
+	if layout, err := v.chapterLayout(pos.Chapter); err == nil && len(layout.pageOffsets) > 0 {
+		page := min(max(pos.Page, 0), len(layout.pageOffsets)-1)
+		location.Offset = layout.pageOffsets[page]
 	}
+	return location, true
+}
 
-	// Load progress for current file if it exists
-	if progress, exists := store.Progresses[v.currentFile]; exists {
-		// Validate the position before setting
-		if progress.Position.Chapter >= 0 &&
-			progress.Position.Chapter < v.reader.GetTotalChapters() {
-			v.currentPos = progress.Position
+// locationAt returns the start of the page a share of the way through the
+// book, laying out every chapter to count the pages
+func (v *CLIViewer) locationAt(percentage float64) (core.Location, bool) {
+	layouts := make([]*chapterLayout, v.reader.GetTotalChapters())
+	total := 0
+	for i := range layouts {
+		layout, err := v.chapterLayout(i)
+		if err != nil {
+			return core.Location{}, false
 		}
+		layouts[i] = layout
+		total += len(layout.pageOffsets)
 	}
 
-	return nil
+	// The percentage counts the page it was saved on
+	page := max(int(math.Round(percentage*float64(total)))-1, 0)
+	for i, layout := range layouts {
+		if page < len(layout.pageOffsets) {
+			return core.Location{Chapter: i, Offset: layout.pageOffsets[page]}, true
+		}
+		page -= len(layout.pageOffsets)
+	}
+	return core.Location{}, false
+}
+
+// location returns the layout independent position of the current page
+func (v *CLIViewer) location() core.Location {
+	location := core.Location{Chapter: v.currentPos.Chapter}
+	if layout, err := v.chapterLayout(v.currentPos.Chapter); err == nil &&
+		v.currentPos.Page >= 0 && v.currentPos.Page < len(layout.pageOffsets) {
+		location.Offset = layout.pageOffsets[v.currentPos.Page]
+	}
+	return location
 }
 
 // Debug function to help troubleshoot progress saving
 func (v *CLIViewer) debugProgress() {
-	homeDir, _ := os.UserHomeDir()
-	progressFile := filepath.Join(homeDir, ".ereader", "progress.json")
-
 	fmt.Printf("\nDebug Progress Information:\n")
 	fmt.Printf("Current File: %s\n", v.currentFile)
-	fmt.Printf("Current Position: Chapter %d, Page %d\n",
-		v.currentPos.Chapter, v.currentPos.Page)
-	fmt.Printf("Progress File: %s\n", progressFile)
+	fmt.Printf("Current Position: Chapter %d, Page %d (%s)\n",
+		v.currentPos.Chapter, v.currentPos.Page, v.location())
+	fmt.Printf("Book: %s\n", v.bookRef().Key())
 
-	if data, err := os.ReadFile(progressFile); err == nil {
-		fmt.Printf("Current Progress File Content:\n%s\n", string(data))
+	if progress, err := v.storage.Progress(v.bookRef()); err == nil {
+		fmt.Printf("Saved Progress: %+v\n", progress)
 	} else {
-		fmt.Printf("Error reading progress file: %v\n", err)
+		fmt.Printf("Error reading progress: %v\n", err)
 	}
 }
@@ -0,0 +1,421 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	colors "github.com/edfun317/ereader/internal/color"
+	"github.com/edfun317/ereader/internal/core"
+	"github.com/fatih/color"
+)
+
+const (
+	codeIndent = "    "
+	tabWidth   = 4
+)
+
+type (
+	// textStyle is the terminal presentation of a run of text
+	textStyle struct {
+		bold      bool
+		italic    bool
+		underline bool
+		faint     bool
+		reverse   bool
+		fg        color.Attribute // 0 keeps the terminal default
+		bg        color.Attribute
+	}
+
+	// span is a run of text sharing one style. Offset is the text offset of
+	// its first character, or -1 for decorations such as list markers which
+	// are not part of the book text.
+	span struct {
+		text   string
+		style  textStyle
+		offset int
+	}
+
+	// line is one rendered terminal line. Verbatim lines hold code that is
+	// never wrapped; they are clipped to the page when displayed.
+	line struct {
+		spans    []span
+		offset   int // Text offset at the start of the line
+		verbatim bool
+		fixed    int // Leading prefix spans that stay put when a verbatim line scrolls
+		// Soft wrapped inside a word or a CJK run, so the text continues
+		// from the previous line without whitespace between
+		joined bool
+	}
+)
+
+func (s textStyle) attributes() []color.Attribute {
+	var attrs []color.Attribute
+	if s.bold {
+		attrs = append(attrs, color.Bold)
+	}
+	if s.faint {
+		attrs = append(attrs, color.Faint)
+	}
+	if s.italic {
+		attrs = append(attrs, color.Italic)
+	}
+	if s.underline {
+		attrs = append(attrs, color.Underline)
+	}
+	if s.reverse {
+		attrs = append(attrs, color.ReverseVideo)
+	}
+	if s.fg != 0 {
+		attrs = append(attrs, s.fg)
+	}
+	if s.bg != 0 {
+		attrs = append(attrs, s.bg)
+	}
+	return attrs
+}
+
+func (s textStyle) sprint(text string) string {
+	attrs := s.attributes()
+	if len(attrs) == 0 {
+		return text
+	}
+	return color.New(attrs...).Sprint(text)
+}
+
+// String renders the line with its ANSI styling
+func (l line) String() string {
+	var sb strings.Builder
+	for _, s := range l.spans {
+		sb.WriteString(s.style.sprint(s.text))
+	}
+	return sb.String()
+}
+
+// plain returns the line text without styling
+func (l line) plain() string {
+	var sb strings.Builder
+	for _, s := range l.spans {
+		sb.WriteString(s.text)
+	}
+	return sb.String()
+}
+
+func (l line) width() int {
+	return displayWidth(l.plain())
+}
+
+// prefixPart is one level of indentation: a list marker, a quote gutter...
+// The first line rendered under it uses first, the following ones rest.
+type prefixPart struct {
+	first span
+	rest  span
+	used  bool
+}
+
+// renderer lays out a document into styled lines of a given width
+type renderer struct {
+	width   int
+	lines   []line
+	offset  int // Text offset of the next character
+	anchors map[string]int
+	prefix  []*prefixPart
+	blank   bool // A blank line is due before the next block
+	syntax  map[tokenKind]textStyle
+}
+
+// renderDocument lays out doc for the given width and color scheme and
+// returns the lines together with the text offset of every anchor
+func renderDocument(doc *core.Document, width int, scheme colors.ColorScheme) ([]line, map[string]int) {
+	r := &renderer{width: width, anchors: make(map[string]int), syntax: syntaxStyles(scheme)}
+	if doc != nil {
+		r.blocks(doc.Blocks, textStyle{})
+	}
+	return r.lines, r.anchors
+}
+
+// prefixSpans returns the prefix for the next line and marks first prefixes used
+func (r *renderer) prefixSpans() []span {
+	var spans []span
+	for _, p := range r.prefix {
+		if p.used {
+			spans = append(spans, p.rest)
+		} else {
+			spans = append(spans, p.first)
+			p.used = true
+		}
+	}
+	return spans
+}
+
+func (r *renderer) prefixWidth() int {
+	width := 0
+	for _, p := range r.prefix {
+		width += displayWidth(p.rest.text)
+	}
+	return width
+}
+
+func (r *renderer) contentWidth() int {
+	return max(r.width-r.prefixWidth(), 1)
+}
+
+func (r *renderer) pushPrefix(first, rest string, style textStyle) {
+	r.prefix = append(r.prefix, &prefixPart{
+		first: span{text: first, style: style, offset: -1},
+		rest:  span{text: rest, style: style, offset: -1},
+	})
+}
+
+func (r *renderer) popPrefix() {
+	r.prefix = r.prefix[:len(r.prefix)-1]
+}
+
+// emit appends a line of content, prepending the current prefixes
+func (r *renderer) emit(content line) {
+	if r.blank && len(r.lines) > 0 {
+		r.emitBlank()
+	}
+	r.blank = false
+
+	prefix := r.prefixSpans()
+	content.fixed = len(prefix)
+	content.spans = append(prefix, content.spans...)
+	r.lines = append(r.lines, content)
+}
+
+// emitBlank appends an empty line, keeping gutters such as quote bars
+func (r *renderer) emitBlank() {
+	var spans []span
+	for _, p := range r.prefix {
+		if text := strings.TrimRight(p.rest.text, " "); text != "" && p.used {
+			spans = append(spans, span{text: text, style: p.rest.style, offset: -1})
+		}
+	}
+	r.lines = append(r.lines, line{spans: spans, offset: r.offset})
+}
+
+func (r *renderer) blocks(blocks []core.Block, style textStyle) {
+	for _, block := range blocks {
+		r.block(block, style)
+	}
+}
+
+func (r *renderer) block(block core.Block, style textStyle) {
+	switch b := block.(type) {
+	case core.Heading:
+		r.heading(b, style)
+	case core.Paragraph:
+		r.paragraph(r.inlineSpans(b.Content, style))
+		r.blank = true
+	case core.List:
+		r.list(b, style)
+		r.blank = true
+	case core.BlockQuote:
+		r.blockQuote(b, style)
+	case core.CodeBlock:
+		r.codeBlock(b)
+		r.blank = true
+	case core.Table:
+		r.table(b, style)
+		r.blank = true
+	case core.Footnote:
+		r.anchors[b.ID] = r.offset
+		footnoteStyle := style
+		footnoteStyle.faint = true
+		r.pushPrefix("  ", "  ", textStyle{})
+		r.blocks(b.Blocks, footnoteStyle)
+		r.popPrefix()
+		r.blank = true
+	case core.Image:
+		r.paragraph(r.inlineSpans([]core.Inline{b}, style))
+		r.blank = true
+	case core.HorizontalRule:
+		rule := "* * *"
+		padding := strings.Repeat(" ", max((r.contentWidth()-len(rule))/2, 0))
+		r.emit(line{spans: []span{{text: padding + rule, style: textStyle{faint: true}, offset: -1}}, offset: r.offset})
+		r.blank = true
+	case core.Anchor:
+		r.anchors[b.ID] = r.offset
+	}
+}
+
+func (r *renderer) heading(h core.Heading, style textStyle) {
+	headingStyle := style
+	headingStyle.bold = true
+	switch h.Level {
+	case 1:
+		headingStyle.fg = color.FgCyan
+	case 2:
+		headingStyle.underline = true
+	default:
+		headingStyle.italic = true
+	}
+
+	// Top level headings get extra room above them
+	if h.Level <= 2 && len(r.lines) > 0 {
+		r.emitBlank()
+	}
+
+	lines := wrapSpans(r.inlineSpans(h.Content, headingStyle), r.contentWidth())
+	for _, l := range lines {
+		r.emit(l)
+	}
+
+	if h.Level == 1 && len(lines) > 0 {
+		rule := strings.Repeat("━", min(lines[len(lines)-1].width(), r.contentWidth()))
+		r.emit(line{spans: []span{{text: rule, style: textStyle{fg: color.FgCyan}, offset: -1}}, offset: r.offset})
+	}
+	r.blank = true
+}
+
+// paragraph wraps styled spans and emits them, honouring forced line breaks
+func (r *renderer) paragraph(spans []span) {
+	for _, l := range wrapSpans(spans, r.contentWidth()) {
+		r.emit(l)
+	}
+}
+
+func (r *renderer) list(l core.List, style textStyle) {
+	markerWidth := 2
+	if l.Ordered {
+		markerWidth = len(fmt.Sprintf("%d. ", l.Start+len(l.Items)-1))
+	}
+
+	for i, item := range l.Items {
+		marker := "• "
+		if l.Ordered {
+			marker = fmt.Sprintf("%d. ", l.Start+i)
+		}
+		marker = fmt.Sprintf("%*s", markerWidth, marker)
+
+		r.pushPrefix(marker, strings.Repeat(" ", markerWidth), textStyle{faint: !l.Ordered})
+		if i > 0 {
+			// Items are tight: no blank lines between them
+			r.blank = false
+		}
+		r.blocks(item.Blocks, style)
+		if !r.prefix[len(r.prefix)-1].used {
+			// Empty item, still show its marker
+			r.emit(line{offset: r.offset})
+		}
+		r.popPrefix()
+		r.blank = false
+	}
+}
+
+func (r *renderer) blockQuote(q core.BlockQuote, style textStyle) {
+	if len(r.lines) > 0 {
+		r.blank = true
+	}
+
+	quoteStyle := style
+	quoteStyle.italic = true
+	r.pushPrefix("│ ", "│ ", textStyle{faint: true})
+	r.blocks(q.Blocks, quoteStyle)
+	r.popPrefix()
+	r.blank = true
+}
+
+// codeBlock keeps preformatted text verbatim and highlights its syntax.
+// Long lines are not wrapped but clipped on display, where they can scroll.
+func (r *renderer) codeBlock(c core.CodeBlock) {
+	r.pushPrefix(codeIndent, codeIndent, textStyle{})
+	for _, tokens := range highlightCode(expandTabs(c.Text), c.Language) {
+		l := line{offset: r.offset, verbatim: true}
+		for _, t := range tokens {
+			l.spans = append(l.spans, span{text: t.text, style: r.syntax[t.kind], offset: r.offset})
+			r.offset += textOffsetLen(t.text)
+		}
+		r.emit(l)
+	}
+	r.popPrefix()
+}
+
+// inlineSpans converts inline content to styled spans, assigning text
+// offsets and recording anchors on the way
+func (r *renderer) inlineSpans(inlines []core.Inline, style textStyle) []span {
+	var spans []span
+	add := func(text string, s textStyle) {
+		spans = append(spans, span{text: text, style: s, offset: r.offset})
+		r.offset += textOffsetLen(text)
+	}
+	// Decorations such as brackets are shown but not part of the book text
+	decorate := func(text string, s textStyle) {
+		spans = append(spans, span{text: text, style: s, offset: -1})
+	}
+
+	var walk func([]core.Inline, textStyle)
+	walk = func(inlines []core.Inline, style textStyle) {
+		for _, inline := range inlines {
+			switch in := inline.(type) {
+			case core.Text:
+				add(in.Text, style)
+			case core.Emphasis:
+				s := style
+				s.italic = true
+				walk(in.Content, s)
+			case core.Strong:
+				s := style
+				s.bold = true
+				walk(in.Content, s)
+			case core.Code:
+				s := style
+				s.fg = color.FgYellow
+				add(in.Text, s)
+			case core.Link:
+				s := style
+				s.underline = true
+				walk(in.Content, s)
+			case core.FootnoteRef:
+				s := style
+				s.faint = true
+				decorate("[", s)
+				add(in.Label, s)
+				decorate("]", s)
+			case core.LineBreak:
+				spans = append(spans, span{text: "\n", style: style, offset: -1})
+			case core.Image:
+				s := style
+				s.faint = true
+				s.italic = true
+				if in.Alt == "" {
+					decorate("[Image]", s)
+					break
+				}
+				decorate("[Image: ", s)
+				add(in.Alt, s)
+				decorate("]", s)
+			case core.Anchor:
+				r.anchors[in.ID] = r.offset
+			}
+		}
+	}
+	walk(inlines, style)
+
+	return spans
+}
+
+// expandTabs replaces tabs with spaces up to the next tab stop
+func expandTabs(text string) string {
+	if !strings.Contains(text, "\t") {
+		return text
+	}
+
+	var sb strings.Builder
+	column := 0
+	for _, r := range text {
+		switch r {
+		case '\t':
+			spaces := tabWidth - column%tabWidth
+			sb.WriteString(strings.Repeat(" ", spaces))
+			column += spaces
+		case '\n':
+			sb.WriteRune(r)
+			column = 0
+		default:
+			sb.WriteRune(r)
+			column += runeWidth(r)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,374 @@
+package cli
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	colors "github.com/edfun317/ereader/internal/color"
+	"github.com/eiannone/keyboard"
+	"github.com/fatih/color"
+	"golang.org/x/text/unicode/norm"
+)
+
+// contextWidth is how much text is shown around a hit in the result list
+const contextWidth = 30
+
+type (
+	// searchHit is one match, located by the text offsets of its first
+	// character and of the character following it
+	searchHit struct {
+		chapter int
+		title   string
+		start   int
+		end     int
+		before  string
+		match   string
+		after   string
+	}
+
+	// searchState holds the last search so that n and N can cycle its hits
+	searchState struct {
+		query   string
+		regex   bool
+		hits    []searchHit
+		current int
+	}
+
+	// searchText is the rendered text of a chapter prepared for matching.
+	// Every rune of folded has the text offset recorded in offsets.
+	searchText struct {
+		original []rune
+		folded   string
+		offsets  []int
+	}
+)
+
+// foldRune removes case and diacritics so that "É" matches "e"
+func foldRune(r rune) rune {
+	if r >= utf8.RuneSelf {
+		if base, _ := utf8.DecodeRuneInString(norm.NFD.String(string(r))); base != utf8.RuneError {
+			r = base
+		}
+	}
+	return unicode.ToLower(r)
+}
+
+// stripMarks removes combining diacritics from s
+func stripMarks(s string) string {
+	var sb strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if !unicode.Is(unicode.Mn, r) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// compileQuery turns the user's query into a case and diacritic
+// insensitive expression. Plain queries match literally with any
+// whitespace between their words.
+func compileQuery(query string, regex bool) (*regexp.Regexp, error) {
+	pattern := stripMarks(query)
+	if !regex {
+		words := strings.Fields(pattern)
+		for i, w := range words {
+			words[i] = regexp.QuoteMeta(w)
+		}
+		pattern = strings.Join(words, `\s+`)
+	}
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search pattern: %w", err)
+	}
+	return re, nil
+}
+
+// newSearchText collects the book text of rendered lines, leaving out
+// decorations and joining lines with a single space, except where a line
+// was wrapped without whitespace
+func newSearchText(lines []line) *searchText {
+	t := &searchText{}
+	var folded strings.Builder
+	pendingSpace := false
+
+	for _, l := range lines {
+		if l.joined {
+			pendingSpace = false
+		}
+		// The prefixes of a joined line, such as list indentation, are not
+		// a break in the text
+		leading := l.joined
+		for _, s := range l.spans {
+			if s.offset < 0 {
+				if !leading {
+					pendingSpace = true
+				}
+				continue
+			}
+			leading = false
+			offset := s.offset
+			for _, r := range s.text {
+				if unicode.IsSpace(r) {
+					pendingSpace = true
+					continue
+				}
+				if pendingSpace && len(t.original) > 0 {
+					t.original = append(t.original, ' ')
+					t.offsets = append(t.offsets, offset)
+					folded.WriteRune(' ')
+				}
+				pendingSpace = false
+				t.original = append(t.original, r)
+				t.offsets = append(t.offsets, offset)
+				folded.WriteRune(foldRune(r))
+				offset++
+			}
+		}
+		pendingSpace = true
+	}
+	t.folded = folded.String()
+	return t
+}
+
+// find returns the hits of re in the text
+func (t *searchText) find(re *regexp.Regexp, chapter int, title string) []searchHit {
+	var hits []searchHit
+	runeIndex, byteIndex := 0, 0
+	toRunes := func(b int) int {
+		runeIndex += utf8.RuneCountInString(t.folded[byteIndex:b])
+		byteIndex = b
+		return runeIndex
+	}
+
+	for _, loc := range re.FindAllStringIndex(t.folded, -1) {
+		start, end := toRunes(loc[0]), toRunes(loc[1])
+		for end > start && unicode.IsSpace(t.original[end-1]) {
+			end--
+		}
+		for start < end && unicode.IsSpace(t.original[start]) {
+			start++
+		}
+		if start == end {
+			continue
+		}
+
+		before := t.original[max(start-contextWidth, 0):start]
+		after := t.original[end:min(end+contextWidth, len(t.original))]
+		hits = append(hits, searchHit{
+			chapter: chapter,
+			title:   title,
+			start:   t.offsets[start],
+			end:     t.offsets[end-1] + 1,
+			before:  strings.TrimLeft(string(before), " "),
+			match:   string(t.original[start:end]),
+			after:   strings.TrimRight(string(after), " "),
+		})
+	}
+	return hits
+}
+
+// searchBook finds every match of the query in all chapters
+func (v *CLIViewer) searchBook(query string, regex bool) ([]searchHit, error) {
+	re, err := compileQuery(query, regex)
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []searchHit
+	scheme := colors.PredefinedSchemes[v.theme]
+	for i := 0; i < v.reader.GetTotalChapters(); i++ {
+		chapter, err := v.reader.GetChapter(i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search chapter %d: %w", i+1, err)
+		}
+		lines, _ := renderDocument(chapter.Document, v.lineWidth, scheme)
+		hits = append(hits, newSearchText(lines).find(re, i, v.chapterTitle(i))...)
+	}
+	return hits, nil
+}
+
+// startSearch prompts for a query, lists the hits and jumps to the chosen
+// one. An empty query shows the results of the previous search again.
+func (v *CLIViewer) startSearch() error {
+	regex := false
+	if v.search != nil {
+		regex = v.search.regex
+	}
+	prompt := func() string {
+		if regex {
+			return "Search (regex, Tab for text): "
+		}
+		return "Search (Tab for regex): "
+	}
+
+	fmt.Println()
+	query, ok, err := readLine(prompt(), func() string {
+		regex = !regex
+		return prompt()
+	})
+	if err != nil || !ok {
+		return err
+	}
+
+	if strings.TrimSpace(query) != "" {
+		fmt.Println("Searching...")
+		hits, err := v.searchBook(query, regex)
+		if err != nil {
+			return v.showMessage(err.Error())
+		}
+		if len(hits) == 0 {
+			return v.showMessage(fmt.Sprintf("No matches for %q", query))
+		}
+		v.search = &searchState{query: query, regex: regex, hits: hits, current: v.firstHitFromHere(hits)}
+	}
+	if v.search == nil {
+		return nil
+	}
+	return v.showSearchResults()
+}
+
+// firstHitFromHere returns the first hit at or after the current page
+func (v *CLIViewer) firstHitFromHere(hits []searchHit) int {
+	pageStart := 0
+	if layout, err := v.chapterLayout(v.currentPos.Chapter); err == nil &&
+		v.currentPos.Page >= 0 && v.currentPos.Page < len(layout.pageOffsets) {
+		pageStart = layout.pageOffsets[v.currentPos.Page]
+	}
+	for i, hit := range hits {
+		if hit.chapter > v.currentPos.Chapter ||
+			hit.chapter == v.currentPos.Chapter && hit.start >= pageStart {
+			return i
+		}
+	}
+	return 0
+}
+
+// showSearchResults lists the hits with their context and chapter
+func (v *CLIViewer) showSearchResults() error {
+	chapterStyle := color.New(color.Faint)
+	matchStyle := color.New(color.Bold, color.ReverseVideo)
+
+	items := make([]string, len(v.search.hits))
+	for i, hit := range v.search.hits {
+		label := hit.title + ": "
+		room := max(v.lineWidth-2-displayWidth(label)-displayWidth(hit.match), 2)
+		before := truncateWidthLeft(hit.before, room/2)
+		after := truncateWidth(hit.after, room-displayWidth(before))
+		items[i] = chapterStyle.Sprint(label) + before + matchStyle.Sprint(hit.match) + after
+	}
+
+	title := fmt.Sprintf("%d matches for %q", len(v.search.hits), v.search.query)
+	if len(v.search.hits) == 1 {
+		title = fmt.Sprintf("1 match for %q", v.search.query)
+	}
+	selected, _, err := v.selectItem(title, items, v.search.current)
+	if err != nil || selected < 0 {
+		return err
+	}
+	return v.goToHit(selected)
+}
+
+// goToHit shows the page holding the given search hit
+func (v *CLIViewer) goToHit(index int) error {
+	hit := v.search.hits[index]
+	v.search.current = index
+	return v.goToOffset(hit.chapter, hit.start)
+}
+
+// cycleSearch moves to the next or previous hit of the last search
+func (v *CLIViewer) cycleSearch(delta int) error {
+	if v.search == nil {
+		return nil
+	}
+	n := len(v.search.hits)
+	return v.goToHit(((v.search.current+delta)%n + n) % n)
+}
+
+// currentHit returns the search hit last jumped to, if any
+func (v *CLIViewer) currentHit() (searchHit, bool) {
+	if v.search == nil || v.currentPos.Chapter != v.search.hits[v.search.current].chapter {
+		return searchHit{}, false
+	}
+	return v.search.hits[v.search.current], true
+}
+
+// markSearchHit highlights the current search hit if it is in the chapter
+func (v *CLIViewer) markSearchHit(l line, chapter int) line {
+	if v.search == nil {
+		return l
+	}
+	hit := v.search.hits[v.search.current]
+	if hit.chapter != chapter {
+		return l
+	}
+	return l.mark(hit.start, hit.end, func(s textStyle) textStyle {
+		s.reverse = true
+		return s
+	})
+}
+
+// mark restyles the characters whose text offsets lie in [start, end)
+func (l line) mark(start, end int, restyle func(textStyle) textStyle) line {
+	var spans []span
+	for _, s := range l.spans {
+		if s.offset < 0 || s.offset >= end {
+			spans = append(spans, s)
+			continue
+		}
+
+		offset := s.offset
+		first := len(spans)
+		for _, c := range splitClusters(s.text) {
+			n := textOffsetLen(c.text)
+			// Spaces take the offset of the character after them
+			inside := offset >= start && offset < end
+			if n == 0 {
+				inside = offset > start && offset < end
+			}
+
+			style := s.style
+			if inside {
+				style = restyle(style)
+			}
+			if last := len(spans) - 1; last >= first && spans[last].style == style {
+				spans[last].text += c.text
+			} else {
+				spans = append(spans, span{text: c.text, style: style, offset: offset})
+			}
+			offset += n
+		}
+	}
+	l.spans = spans
+	return l
+}
+
+// showMessage prints a message and waits for a key press
+func (v *CLIViewer) showMessage(message string) error {
+	fmt.Printf("\n%s\nPress any key to continue...", message)
+	if _, _, err := keyboard.GetKey(); err != nil {
+		return fmt.Errorf("keyboard error: %w", err)
+	}
+	return nil
+}
+
+// truncateWidthLeft keeps the end of s within width columns
+func truncateWidthLeft(s string, width int) string {
+	clusters := splitClusters(s)
+	used, i := 0, len(clusters)
+	for i > 0 && used+clusters[i-1].width <= width {
+		i--
+		used += clusters[i].width
+	}
+	if i == 0 {
+		return s
+	}
+	var sb strings.Builder
+	sb.WriteString("…")
+	for _, c := range clusters[i+1:] {
+		sb.WriteString(c.text)
+	}
+	return sb.String()
+}
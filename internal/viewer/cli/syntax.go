@@ -0,0 +1,362 @@
+package cli
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	colors "github.com/edfun317/ereader/internal/color"
+	"github.com/fatih/color"
+)
+
+type tokenKind int
+
+const (
+	tokenPlain tokenKind = iota
+	tokenKeyword
+	tokenType
+	tokenString
+	tokenComment
+	tokenNumber
+)
+
+// syntaxToken is a piece of source code with its lexical category
+type syntaxToken struct {
+	text string
+	kind tokenKind
+}
+
+// language describes just enough of a programming language to colour it
+type language struct {
+	keywords      map[string]bool
+	types         map[string]bool
+	lineComments  []string
+	blockComments [][2]string
+	quotes        []string // String delimiters, longest first
+	caseFold      bool     // Keywords are case-insensitive
+}
+
+func words(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		set[w] = true
+	}
+	return set
+}
+
+var (
+	cStyleComments = [][2]string{{"/*", "*/"}}
+
+	goLanguage = &language{
+		keywords: words("break case chan const continue default defer else fallthrough for func go goto " +
+			"if import interface map package range return select struct switch type var nil true false iota"),
+		types: words("bool byte complex64 complex128 error float32 float64 int int8 int16 int32 int64 " +
+			"rune string uint uint8 uint16 uint32 uint64 uintptr any comparable"),
+		lineComments:  []string{"//"},
+		blockComments: cStyleComments,
+		quotes:        []string{`"`, "'", "`"},
+	}
+
+	pythonLanguage = &language{
+		keywords: words("and as assert async await break class continue def del elif else except finally " +
+			"for from global if import in is lambda nonlocal not or pass raise return try while with yield " +
+			"None True False self match case"),
+		types:        words("int float str bool list dict set tuple bytes object type"),
+		lineComments: []string{"#"},
+		quotes:       []string{`"""`, `'''`, `"`, "'"},
+	}
+
+	javascriptLanguage = &language{
+		keywords: words("break case catch class const continue debugger default delete do else export extends " +
+			"finally for function if import in instanceof let new return super switch this throw try typeof var " +
+			"void while with yield async await of null undefined true false interface type enum implements"),
+		types:         words("string number boolean any unknown never object Array Promise Map Set"),
+		lineComments:  []string{"//"},
+		blockComments: cStyleComments,
+		quotes:        []string{`"`, "'", "`"},
+	}
+
+	javaLanguage = &language{
+		keywords: words("abstract assert break case catch class const continue default do else enum extends " +
+			"final finally for goto if implements import instanceof interface native new package private " +
+			"protected public return static strictfp super switch synchronized this throw throws transient try " +
+			"volatile while null true false var record fun val when object override open data sealed"),
+		types:         words("boolean byte char double float int long short void String Integer Object List Map"),
+		lineComments:  []string{"//"},
+		blockComments: cStyleComments,
+		quotes:        []string{`"""`, `"`, "'"},
+	}
+
+	cLanguage = &language{
+		keywords: words("auto break case catch class const constexpr continue default delete do else enum " +
+			"explicit extern for friend goto if inline namespace new noexcept nullptr operator private protected " +
+			"public register return sizeof static struct switch template this throw try typedef typename union " +
+			"using virtual volatile while true false NULL #include #define #ifdef #ifndef #endif #if #else"),
+		types: words("bool char double float int long short signed unsigned void size_t int8_t int16_t int32_t " +
+			"int64_t uint8_t uint16_t uint32_t uint64_t std string vector auto"),
+		lineComments:  []string{"//"},
+		blockComments: cStyleComments,
+		quotes:        []string{`"`, "'"},
+	}
+
+	csharpLanguage = &language{
+		keywords: words("abstract as base break case catch class const continue default delegate do else enum " +
+			"event explicit extern finally fixed for foreach goto if implicit in interface internal is lock " +
+			"namespace new null operator out override params private protected public readonly ref return sealed " +
+			"sizeof static struct switch this throw try typeof using virtual void volatile while true false var " +
+			"async await record"),
+		types:         words("bool byte char decimal double float int long object sbyte short string uint ulong ushort"),
+		lineComments:  []string{"//"},
+		blockComments: cStyleComments,
+		quotes:        []string{`"`, "'"},
+	}
+
+	rustLanguage = &language{
+		keywords: words("as async await break const continue crate dyn else enum extern false fn for if impl in " +
+			"let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use " +
+			"where while"),
+		types: words("bool char f32 f64 i8 i16 i32 i64 i128 isize str u8 u16 u32 u64 u128 usize String Vec " +
+			"Option Result Box"),
+		lineComments:  []string{"//"},
+		blockComments: cStyleComments,
+		quotes:        []string{`"`},
+	}
+
+	rubyLanguage = &language{
+		keywords: words("alias and begin break case class def defined? do else elsif end ensure false for if in " +
+			"module next nil not or redo rescue retry return self super then true undef unless until when while " +
+			"yield require attr_accessor"),
+		lineComments: []string{"#"},
+		quotes:       []string{`"`, "'"},
+	}
+
+	shellLanguage = &language{
+		keywords: words("if then else elif fi case esac for while until do done in function return exit " +
+			"export local readonly echo cd source set unset"),
+		lineComments: []string{"#"},
+		quotes:       []string{`"`, "'"},
+	}
+
+	sqlLanguage = &language{
+		keywords: words("select from where and or not insert into values update set delete create table drop " +
+			"alter index view join inner left right outer full on as group by order having limit offset union " +
+			"all distinct null is in exists between like primary key foreign references default case when then " +
+			"else end begin commit rollback with returning asc desc"),
+		types:         words("int integer bigint smallint text varchar char boolean date timestamp numeric decimal real serial"),
+		lineComments:  []string{"--"},
+		blockComments: cStyleComments,
+		quotes:        []string{"'", `"`},
+		caseFold:      true,
+	}
+
+	jsonLanguage = &language{
+		keywords: words("true false null"),
+		quotes:   []string{`"`},
+	}
+
+	yamlLanguage = &language{
+		keywords:     words("true false null yes no"),
+		lineComments: []string{"#"},
+		quotes:       []string{`"`, "'"},
+	}
+
+	// languages maps the names used in class="language-x" to definitions
+	languages = map[string]*language{
+		"go": goLanguage, "golang": goLanguage,
+		"python": pythonLanguage, "py": pythonLanguage, "python3": pythonLanguage,
+		"javascript": javascriptLanguage, "js": javascriptLanguage, "jsx": javascriptLanguage,
+		"typescript": javascriptLanguage, "ts": javascriptLanguage, "tsx": javascriptLanguage,
+		"java": javaLanguage, "kotlin": javaLanguage, "kt": javaLanguage, "scala": javaLanguage,
+		"c": cLanguage, "cpp": cLanguage, "c++": cLanguage, "h": cLanguage, "objc": cLanguage,
+		"csharp": csharpLanguage, "cs": csharpLanguage, "c#": csharpLanguage,
+		"rust": rustLanguage, "rs": rustLanguage,
+		"ruby": rubyLanguage, "rb": rubyLanguage,
+		"bash": shellLanguage, "sh": shellLanguage, "shell": shellLanguage, "zsh": shellLanguage, "console": shellLanguage,
+		"sql": sqlLanguage, "postgresql": sqlLanguage, "mysql": sqlLanguage,
+		"json": jsonLanguage, "yaml": yamlLanguage, "yml": yamlLanguage,
+	}
+)
+
+// highlightCode splits code into lines of tokens. Unknown languages yield
+// plain tokens, one per line.
+func highlightCode(code, languageName string) [][]syntaxToken {
+	lang, ok := languages[strings.ToLower(languageName)]
+	if !ok {
+		var lines [][]syntaxToken
+		for _, text := range strings.Split(code, "\n") {
+			lines = append(lines, []syntaxToken{{text: text, kind: tokenPlain}})
+		}
+		return lines
+	}
+	return splitTokenLines(lang.tokenize(code))
+}
+
+// tokenize scans the whole source so that comments and strings spanning
+// several lines are coloured correctly
+func (lang *language) tokenize(code string) []syntaxToken {
+	var tokens []syntaxToken
+	var plain strings.Builder
+
+	emit := func(text string, kind tokenKind) {
+		if plain.Len() > 0 {
+			tokens = append(tokens, syntaxToken{text: plain.String(), kind: tokenPlain})
+			plain.Reset()
+		}
+		tokens = append(tokens, syntaxToken{text: text, kind: kind})
+	}
+
+	for i := 0; i < len(code); {
+		rest := code[i:]
+
+		if _, ok := hasAnyPrefix(rest, lang.lineComments); ok {
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			emit(rest[:end], tokenComment)
+			i += end
+			continue
+		}
+
+		if block, ok := hasBlockPrefix(rest, lang.blockComments); ok {
+			end := strings.Index(rest[len(block[0]):], block[1])
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += len(block[0]) + len(block[1])
+			}
+			emit(rest[:end], tokenComment)
+			i += end
+			continue
+		}
+
+		if quote, ok := hasAnyPrefix(rest, lang.quotes); ok {
+			end := stringEnd(rest, quote)
+			emit(rest[:end], tokenString)
+			i += end
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(rest)
+		switch {
+		case isIdentStart(r):
+			end := identEnd(rest)
+			word := rest[:end]
+			key := word
+			if lang.caseFold {
+				key = strings.ToLower(word)
+			}
+			switch {
+			case lang.keywords[key]:
+				emit(word, tokenKeyword)
+			case lang.types[key]:
+				emit(word, tokenType)
+			default:
+				plain.WriteString(word)
+			}
+			i += end
+		case unicode.IsDigit(r):
+			end := numberEnd(rest)
+			emit(rest[:end], tokenNumber)
+			i += end
+		default:
+			plain.WriteString(rest[:size])
+			i += size
+		}
+	}
+
+	if plain.Len() > 0 {
+		tokens = append(tokens, syntaxToken{text: plain.String(), kind: tokenPlain})
+	}
+	return tokens
+}
+
+// splitTokenLines breaks tokens spanning newlines into per-line tokens
+func splitTokenLines(tokens []syntaxToken) [][]syntaxToken {
+	lines := [][]syntaxToken{nil}
+	for _, token := range tokens {
+		parts := strings.Split(token.text, "\n")
+		for i, part := range parts {
+			if i > 0 {
+				lines = append(lines, nil)
+			}
+			if part != "" {
+				lines[len(lines)-1] = append(lines[len(lines)-1], syntaxToken{text: part, kind: token.kind})
+			}
+		}
+	}
+	return lines
+}
+
+func hasAnyPrefix(s string, prefixes []string) (string, bool) {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+func hasBlockPrefix(s string, blocks [][2]string) ([2]string, bool) {
+	for _, b := range blocks {
+		if strings.HasPrefix(s, b[0]) {
+			return b, true
+		}
+	}
+	return [2]string{}, false
+}
+
+// stringEnd returns the length of the string literal at the start of s,
+// honouring backslash escapes except in Go raw strings
+func stringEnd(s, quote string) int {
+	escapes := quote != "`"
+	for i := len(quote); i < len(s); i++ {
+		switch {
+		case escapes && s[i] == '\\':
+			i++
+		case strings.HasPrefix(s[i:], quote):
+			return i + len(quote)
+		case s[i] == '\n' && len(quote) == 1 && quote != "`":
+			// Unterminated single-line string
+			return i
+		}
+	}
+	return len(s)
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || r == '#' || unicode.IsLetter(r)
+}
+
+func identEnd(s string) int {
+	for i, r := range s {
+		if i > 0 && !(r == '_' || r == '?' || unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+func numberEnd(s string) int {
+	for i, r := range s {
+		if !(unicode.IsDigit(r) || unicode.IsLetter(r) || r == '.' || r == '_') {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// syntaxStyles maps token kinds to the styles of a color scheme
+func syntaxStyles(scheme colors.ColorScheme) map[tokenKind]textStyle {
+	fg := func(name string) color.Attribute {
+		return colors.ColorMap[strings.ToLower(name)]
+	}
+	return map[tokenKind]textStyle{
+		tokenPlain:   {fg: fg(scheme.Syntax.Code)},
+		tokenKeyword: {fg: fg(scheme.Syntax.Keyword), bold: true},
+		tokenType:    {fg: fg(scheme.Syntax.Type)},
+		tokenString:  {fg: fg(scheme.Syntax.String)},
+		tokenComment: {fg: fg(scheme.Syntax.Comment), italic: true},
+		tokenNumber:  {fg: fg(scheme.Syntax.Number)},
+	}
+}
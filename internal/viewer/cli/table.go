@@ -0,0 +1,468 @@
+package cli
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/edfun317/ereader/internal/core"
+)
+
+const (
+	// maxMinColumnWidth caps the width a single long word (e.g. a URL) may
+	// force on a column; longer words are broken instead
+	maxMinColumnWidth = 15
+	// cellOverhead is the border and padding around each column: "│ " + " "
+	cellOverhead = 3
+)
+
+type (
+	// tableCell is a source cell with its rendered content and grid position
+	tableCell struct {
+		spans            []span
+		header           bool
+		row, col         int
+		rowSpan, colSpan int
+	}
+
+	// tableGrid resolves row and column spans into a matrix of cells
+	tableGrid struct {
+		cells []*tableCell
+		slots [][]*tableCell // slots[row][col] is the cell covering that slot
+		cols  int
+	}
+
+	// canvas is a grid of terminal columns used to draw the table
+	canvas struct {
+		cells [][]styledCluster
+	}
+)
+
+// buildTableGrid places cells on the grid, honouring colspan and rowspan
+func (r *renderer) buildTableGrid(t core.Table, style textStyle) *tableGrid {
+	g := &tableGrid{}
+	g.slots = make([][]*tableCell, len(t.Rows))
+
+	for rowIndex, row := range t.Rows {
+		col := 0
+		for _, c := range row.Cells {
+			// Skip slots already covered by row spans from above
+			for col < len(g.slots[rowIndex]) && g.slots[rowIndex][col] != nil {
+				col++
+			}
+
+			cellStyle := style
+			cellStyle.bold = c.Header
+			cell := &tableCell{
+				spans:   r.inlineSpans(c.Content, cellStyle),
+				header:  c.Header,
+				row:     rowIndex,
+				col:     col,
+				rowSpan: min(max(c.RowSpan, 1), len(t.Rows)-rowIndex),
+				colSpan: max(c.ColSpan, 1),
+			}
+			g.cells = append(g.cells, cell)
+
+			for dr := 0; dr < cell.rowSpan; dr++ {
+				slots := g.slots[rowIndex+dr]
+				for len(slots) < col+cell.colSpan {
+					slots = append(slots, nil)
+				}
+				for dc := 0; dc < cell.colSpan; dc++ {
+					slots[col+dc] = cell
+				}
+				g.slots[rowIndex+dr] = slots
+			}
+			col += cell.colSpan
+		}
+	}
+
+	for _, slots := range g.slots {
+		g.cols = max(g.cols, len(slots))
+	}
+	// Pad short rows with empty cells so every slot has an owner
+	for rowIndex := range g.slots {
+		for col := 0; col < g.cols; col++ {
+			if col >= len(g.slots[rowIndex]) {
+				g.slots[rowIndex] = append(g.slots[rowIndex], nil)
+			}
+			if g.slots[rowIndex][col] == nil {
+				cell := &tableCell{row: rowIndex, col: col, rowSpan: 1, colSpan: 1}
+				g.cells = append(g.cells, cell)
+				g.slots[rowIndex][col] = cell
+			}
+		}
+	}
+	return g
+}
+
+// cellWidths returns the narrowest and the natural width of a cell's content
+func cellWidths(spans []span) (int, int) {
+	minWidth, natural, lineWidth := 1, 0, 0
+	for _, token := range tokenize(spans) {
+		if token.lineBreak {
+			natural = max(natural, lineWidth)
+			lineWidth = 0
+			continue
+		}
+		minWidth = max(minWidth, min(token.width, maxMinColumnWidth))
+		if token.spaceBefore && lineWidth > 0 {
+			lineWidth++
+		}
+		lineWidth += token.width
+	}
+	return minWidth, max(natural, lineWidth, 1)
+}
+
+// columnWidths fits the columns into width, or returns false when even the
+// narrowest layout does not fit
+func (g *tableGrid) columnWidths(width int) ([]int, bool) {
+	mins := make([]int, g.cols)
+	naturals := make([]int, g.cols)
+
+	// Single column cells first, then widen columns for spanning cells
+	for pass := 0; pass < 2; pass++ {
+		for _, cell := range g.cells {
+			if (cell.colSpan == 1) != (pass == 0) {
+				continue
+			}
+			minWidth, natural := cellWidths(cell.spans)
+			spread(mins, cell.col, cell.colSpan, minWidth)
+			spread(naturals, cell.col, cell.colSpan, natural)
+		}
+	}
+
+	available := width - g.cols*cellOverhead - 1
+	total, totalMin := 0, 0
+	for c := range mins {
+		naturals[c] = max(naturals[c], mins[c])
+		total += naturals[c]
+		totalMin += mins[c]
+	}
+
+	if totalMin > available {
+		return nil, false
+	}
+	if total <= available {
+		return naturals, true
+	}
+
+	// Give each column its minimum, then share the rest in proportion to
+	// how much more room the column would like
+	widths := append([]int(nil), mins...)
+	spare := available - totalMin
+	wanted := total - totalMin
+	for c := range widths {
+		extra := (naturals[c] - mins[c]) * spare / wanted
+		widths[c] += extra
+	}
+	return widths, true
+}
+
+// spread makes columns [col, col+span) at least need wide in total,
+// counting the borders between them
+func spread(widths []int, col, span, need int) {
+	have := (span - 1) * cellOverhead
+	for c := col; c < col+span; c++ {
+		have += widths[c]
+	}
+	for i := 0; have < need; i++ {
+		widths[col+i%span]++
+		have++
+	}
+}
+
+// table lays out a table as a box-drawn grid, falling back to one record
+// per row when the columns do not fit
+func (r *renderer) table(t core.Table, style textStyle) {
+	if len(t.Caption) > 0 {
+		captionStyle := style
+		captionStyle.italic = true
+		r.paragraph(r.inlineSpans(t.Caption, captionStyle))
+	}
+	if len(t.Rows) == 0 {
+		return
+	}
+
+	start := r.offset
+	g := r.buildTableGrid(t, style)
+	widths, ok := g.columnWidths(r.contentWidth())
+	if !ok {
+		r.tableRecords(g)
+		return
+	}
+
+	// Border lines hold no book text and take the offset of the line above
+	for _, l := range g.draw(widths) {
+		if l.offset < 0 {
+			l.offset = start
+		}
+		start = l.offset
+		r.emit(l)
+	}
+}
+
+// draw renders the grid with box-drawing borders
+func (g *tableGrid) draw(widths []int) []line {
+	rows := len(g.slots)
+
+	colX := make([]int, g.cols+1)
+	for c, w := range widths {
+		colX[c+1] = colX[c] + w + cellOverhead
+	}
+
+	// Wrap every cell to its region width and size the rows to fit
+	wrapped := make(map[*tableCell][]line)
+	heights := make([]int, rows)
+	for i := range heights {
+		heights[i] = 1
+	}
+	for _, cell := range g.cells {
+		regionWidth := colX[cell.col+cell.colSpan] - colX[cell.col] - cellOverhead
+		wrapped[cell] = wrapSpans(cell.spans, regionWidth)
+		if cell.rowSpan == 1 {
+			heights[cell.row] = max(heights[cell.row], len(wrapped[cell]))
+		}
+	}
+	for _, cell := range g.cells {
+		if cell.rowSpan == 1 {
+			continue
+		}
+		last := cell.row + cell.rowSpan - 1
+		have := cell.rowSpan - 1
+		for row := cell.row; row <= last; row++ {
+			have += heights[row]
+		}
+		if need := len(wrapped[cell]); need > have {
+			heights[last] += need - have
+		}
+	}
+
+	rowY := make([]int, rows+1)
+	for row, h := range heights {
+		rowY[row+1] = rowY[row] + h + 1
+	}
+
+	cv := newCanvas(colX[g.cols]+1, rowY[rows]+1)
+	border := textStyle{faint: true}
+
+	// Borders exist between slots owned by different cells
+	vertical := func(row, boundary int) bool {
+		if row < 0 || row >= rows {
+			return false
+		}
+		return boundary == 0 || boundary == g.cols || g.slots[row][boundary-1] != g.slots[row][boundary]
+	}
+	horizontal := func(lineIndex, col int) bool {
+		if col < 0 || col >= g.cols {
+			return false
+		}
+		return lineIndex == 0 || lineIndex == rows || g.slots[lineIndex-1][col] != g.slots[lineIndex][col]
+	}
+
+	for row := 0; row < rows; row++ {
+		for b := 0; b <= g.cols; b++ {
+			if vertical(row, b) {
+				for y := rowY[row] + 1; y < rowY[row+1]; y++ {
+					cv.set(colX[b], y, "│", border)
+				}
+			}
+		}
+	}
+	for lineIndex := 0; lineIndex <= rows; lineIndex++ {
+		y := rowY[lineIndex]
+		for c := 0; c < g.cols; c++ {
+			if horizontal(lineIndex, c) {
+				for x := colX[c] + 1; x < colX[c+1]; x++ {
+					cv.set(x, y, "─", border)
+				}
+			}
+		}
+		for b := 0; b <= g.cols; b++ {
+			junction := boxJunction(vertical(lineIndex-1, b), vertical(lineIndex, b),
+				horizontal(lineIndex, b-1), horizontal(lineIndex, b))
+			if junction != "" {
+				cv.set(colX[b], y, junction, border)
+			}
+		}
+	}
+
+	for _, cell := range g.cells {
+		y := rowY[cell.row] + 1
+		for _, l := range wrapped[cell] {
+			cv.write(colX[cell.col]+2, y, l)
+			y++
+		}
+	}
+
+	return cv.lines()
+}
+
+// boxJunction picks the box-drawing character joining the given directions
+func boxJunction(up, down, left, right bool) string {
+	switch {
+	case up && down && left && right:
+		return "┼"
+	case up && down && right:
+		return "├"
+	case up && down && left:
+		return "┤"
+	case down && left && right:
+		return "┬"
+	case up && left && right:
+		return "┴"
+	case down && right:
+		return "┌"
+	case down && left:
+		return "┐"
+	case up && right:
+		return "└"
+	case up && left:
+		return "┘"
+	case up || down:
+		return "│"
+	case left || right:
+		return "─"
+	}
+	return ""
+}
+
+// tableRecords renders each row as a block of "Header: value" lines, used
+// when the table is too wide for the terminal
+func (r *renderer) tableRecords(g *tableGrid) {
+	// Labels come from the leading header rows
+	labels := make([]string, g.cols)
+	bodyStart := 0
+	for row := range g.slots {
+		isHeader := true
+		for _, cell := range g.slots[row] {
+			isHeader = isHeader && cell.header
+		}
+		if !isHeader {
+			break
+		}
+		for col, cell := range g.slots[row] {
+			if text := strings.TrimSpace(spansText(cell.spans)); text != "" && labels[col] == "" {
+				labels[col] = text
+			}
+		}
+		bodyStart = row + 1
+	}
+
+	labelWidth := 0
+	for col := range labels {
+		if labels[col] == "" {
+			labels[col] = "Column " + strconv.Itoa(col+1)
+		}
+		labelWidth = max(labelWidth, displayWidth(labels[col]))
+	}
+	labelWidth = min(labelWidth, r.contentWidth()/3)
+
+	labelStyle := textStyle{bold: true}
+	for row := bodyStart; row < len(g.slots); row++ {
+		if row > bodyStart {
+			rule := strings.Repeat("─", min(r.contentWidth(), 20))
+			r.emit(line{spans: []span{{text: rule, style: textStyle{faint: true}, offset: -1}}, offset: r.offset})
+		}
+
+		for col, cell := range g.slots[row] {
+			// Spanning cells are shown once, under their first column
+			if cell.row != row || cell.col != col || len(cell.spans) == 0 {
+				continue
+			}
+			label := truncateWidth(labels[col], labelWidth)
+			label += strings.Repeat(" ", labelWidth-displayWidth(label)) + ": "
+
+			r.prefix = append(r.prefix, &prefixPart{
+				first: span{text: label, style: labelStyle, offset: -1},
+				rest:  span{text: strings.Repeat(" ", labelWidth+2), offset: -1},
+			})
+			r.paragraph(cell.spans)
+			r.popPrefix()
+		}
+	}
+}
+
+func newCanvas(width, height int) *canvas {
+	cv := &canvas{cells: make([][]styledCluster, height)}
+	for y := range cv.cells {
+		cv.cells[y] = make([]styledCluster, width)
+		for x := range cv.cells[y] {
+			cv.cells[y][x] = styledCluster{cluster: cluster{text: " ", width: 1}, offset: -1}
+		}
+	}
+	return cv
+}
+
+func (cv *canvas) set(x, y int, text string, style textStyle) {
+	cv.cells[y][x] = styledCluster{cluster: cluster{text: text, width: 1}, style: style, offset: -1}
+}
+
+// write copies a rendered line onto the canvas; wide characters take two
+// columns, the second one being left empty
+func (cv *canvas) write(x, y int, l line) {
+	for _, c := range styledClusters(l.spans) {
+		if x >= len(cv.cells[y]) {
+			return
+		}
+		cv.cells[y][x] = c
+		for i := 1; i < c.width && x+i < len(cv.cells[y]); i++ {
+			cv.cells[y][x+i] = styledCluster{}
+		}
+		x += max(c.width, 1)
+	}
+}
+
+func (cv *canvas) lines() []line {
+	var lines []line
+	offset := -1
+	for _, row := range cv.cells {
+		b := newLineBuilder(offset)
+		for _, c := range row {
+			if c.text == "" {
+				continue // Second half of a wide character
+			}
+			b.add(c)
+		}
+		l := b.line()
+		l.spans = trimTrailingSpace(l.spans)
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func trimTrailingSpace(spans []span) []span {
+	for len(spans) > 0 {
+		last := &spans[len(spans)-1]
+		last.text = strings.TrimRight(last.text, " ")
+		if last.text != "" {
+			break
+		}
+		spans = spans[:len(spans)-1]
+	}
+	return spans
+}
+
+func spansText(spans []span) string {
+	var sb strings.Builder
+	for _, s := range spans {
+		sb.WriteString(s.text)
+	}
+	return sb.String()
+}
+
+// truncateWidth shortens s to at most width columns, adding an ellipsis
+func truncateWidth(s string, width int) string {
+	if displayWidth(s) <= width {
+		return s
+	}
+	var sb strings.Builder
+	used := 0
+	for _, c := range splitClusters(s) {
+		if used+c.width > width-1 {
+			break
+		}
+		sb.WriteString(c.text)
+		used += c.width
+	}
+	return sb.String() + "…"
+}
@@ -0,0 +1,52 @@
+package cli
+
+const (
+	// Fallback dimensions when the terminal size cannot be queried
+	defaultColumns = 80
+	defaultRows    = 25
+
+	// Lines used around the text: blank + page number, blank + two help lines
+	footerLines = 5
+	headerLines = 0
+
+	minLineWidth = 20
+	minPageSize  = 5
+	columnStep   = 4
+
+	// Columns moved per key press when scrolling code sideways
+	codeScrollStep = 8
+)
+
+// updateLayoutSize derives the line width and lines per page from the
+// current terminal dimensions, margins and column width limit
+func (v *CLIViewer) updateLayoutSize() {
+	cols, rows, err := terminalSize()
+	if err != nil || cols <= 0 || rows <= 0 {
+		cols, rows = defaultColumns, defaultRows
+	}
+	v.termCols, v.termRows = cols, rows
+
+	width := cols - 2*v.margin
+	if v.maxWidth > 0 && width > v.maxWidth {
+		width = v.maxWidth
+	}
+	v.lineWidth = max(width, minLineWidth)
+
+	// Keep one spare row so the cursor line does not scroll the page
+	v.pageSize = max(rows-headerLines-footerLines-1, minPageSize)
+}
+
+// leftPadding centers the text column in the terminal, never going below
+// the configured margin
+func (v *CLIViewer) leftPadding() int {
+	return max(v.margin, (v.termCols-v.lineWidth)/2)
+}
+
+// resizeColumn changes the column width limit by delta and re-derives the layout
+func (v *CLIViewer) resizeColumn(delta int) {
+	if v.maxWidth == 0 {
+		v.maxWidth = v.lineWidth
+	}
+	v.maxWidth = max(v.maxWidth+delta, minLineWidth)
+	v.updateLayoutSize()
+}
@@ -0,0 +1,47 @@
+//go:build !windows
+
+package cli
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// terminalSize returns the number of columns and rows of the terminal
+func terminalSize() (int, int, error) {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
+
+// watchResize notifies whenever the terminal receives SIGWINCH
+func watchResize() (<-chan struct{}, func()) {
+	signals := make(chan os.Signal, 1)
+	resized := make(chan struct{}, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGWINCH)
+
+	go func() {
+		for {
+			select {
+			case <-signals:
+				select {
+				case resized <- struct{}{}:
+				default: // A resize is already pending
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return resized, func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
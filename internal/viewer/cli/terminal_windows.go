@@ -0,0 +1,52 @@
+//go:build windows
+
+package cli
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// terminalSize returns the number of columns and rows of the console window
+func terminalSize() (int, int, error) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(os.Stdout.Fd()), &info); err != nil {
+		return 0, 0, err
+	}
+	cols := int(info.Window.Right-info.Window.Left) + 1
+	rows := int(info.Window.Bottom-info.Window.Top) + 1
+	return cols, rows, nil
+}
+
+// watchResize polls the console size since Windows has no SIGWINCH
+func watchResize() (<-chan struct{}, func()) {
+	resized := make(chan struct{}, 1)
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+
+		lastCols, lastRows, _ := terminalSize()
+		for {
+			select {
+			case <-ticker.C:
+				cols, rows, err := terminalSize()
+				if err != nil || (cols == lastCols && rows == lastRows) {
+					continue
+				}
+				lastCols, lastRows = cols, rows
+				select {
+				case resized <- struct{}{}:
+				default:
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return resized, func() { close(done) }
+}
@@ -1,13 +1,11 @@
 package cli
 
 import (
-	"bytes"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
-
-	"golang.org/x/net/html"
+	"unicode"
 )
 
 func clearScreen() {
@@ -21,35 +19,12 @@ func clearScreen() {
 	cmd.Run()
 }
 
-// formatContent
-func (v *CLIViewer) formatContent(content string) string {
-	doc, err := html.Parse(strings.NewReader(content))
-	if err != nil {
-		return content
-	}
-
-	var buf bytes.Buffer
-	var extract func(*html.Node)
-
-	extract = func(n *html.Node) {
-		if n.Type == html.TextNode {
-			buf.WriteString(n.Data)
-			buf.WriteString(" ")
-		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			extract(c)
-		}
-		if n.Type == html.ElementNode && (n.Data == "p" || n.Data == "div") {
-			buf.WriteString("\n\n")
-		}
-	}
-
-	extract(doc)
-	return strings.TrimSpace(buf.String())
+func (v *CLIViewer) paginateContent(content string) []string {
+	return paginate(content, v.lineWidth, v.pageSize)
 }
 
-func (v *CLIViewer) paginateContent(content string) []string {
-	const maxWidth = 80
+// paginate wraps content to maxWidth columns and groups the lines into pages
+func paginate(content string, maxWidth, pageSize int) []string {
 	lines := make([]string, 0)
 	paragraphs := strings.Split(content, "\n\n")
 
@@ -58,34 +33,7 @@ func (v *CLIViewer) paginateContent(content string) []string {
 			continue
 		}
 
-		words := strings.Fields(paragraph)
-		if len(words) == 0 {
-			continue
-		}
-
-		var currentLine strings.Builder
-		currentLine.WriteString(words[0])
-		lineLength := len(words[0])
-
-		for i := 1; i < len(words); i++ {
-			word := words[i]
-			wordLen := len(word)
-
-			if lineLength+wordLen+1 > maxWidth {
-				lines = append(lines, currentLine.String())
-				currentLine.Reset()
-				currentLine.WriteString(word)
-				lineLength = wordLen
-			} else {
-				currentLine.WriteString(" ")
-				currentLine.WriteString(word)
-				lineLength += wordLen + 1
-			}
-		}
-
-		if currentLine.Len() > 0 {
-			lines = append(lines, currentLine.String())
-		}
+		lines = append(lines, wrapParagraph(paragraph, maxWidth)...)
 		lines = append(lines, "") // Add blank line between paragraphs
 	}
 
@@ -95,7 +43,7 @@ func (v *CLIViewer) paginateContent(content string) []string {
 	lineCount := 0
 
 	for _, line := range lines {
-		if lineCount >= v.pageSize {
+		if lineCount >= pageSize {
 			pages = append(pages, strings.TrimSpace(currentPage.String()))
 			currentPage.Reset()
 			lineCount = 0
@@ -112,3 +60,24 @@ func (v *CLIViewer) paginateContent(content string) []string {
 
 	return pages
 }
+
+// truncateLines keeps at most n lines of text, marking the cut with an ellipsis
+func truncateLines(text string, n int) string {
+	lines := strings.Split(text, "\n")
+	if len(lines) <= n {
+		return text
+	}
+	return strings.Join(lines[:n], "\n") + " …"
+}
+
+// textOffsetLen counts the non-space characters of s, the unit used for
+// layout independent text offsets
+func textOffsetLen(s string) int {
+	count := 0
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			count++
+		}
+	}
+	return count
+}
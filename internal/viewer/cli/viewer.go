@@ -4,66 +4,144 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/edfun317/ereader/internal/annotation"
+	colors "github.com/edfun317/ereader/internal/color"
+	"github.com/edfun317/ereader/internal/core"
+	"github.com/edfun317/ereader/internal/storage"
 	"github.com/eiannone/keyboard"
 	"github.com/fatih/color"
 )
 
 func (v *CLIViewer) eventLoop() error {
+	keys, err := keyboard.GetKeys(10)
+	if err != nil {
+		return fmt.Errorf("keyboard error: %w", err)
+	}
+
+	resized, stopWatching := watchResize()
+	defer stopWatching()
+
 	for !v.shouldExit {
 		if err := v.displayCurrentPage(); err != nil {
 			return err
 		}
 
-		char, key, err := keyboard.GetKey()
-		if err != nil {
-			return fmt.Errorf("keyboard error: %w", err)
-		}
-
-		if err := v.handleKeyPress(char, key); err != nil {
-			return err
+		select {
+		case event := <-keys:
+			if event.Err != nil {
+				return fmt.Errorf("keyboard error: %w", event.Err)
+			}
+			if err := v.handleKeyPress(event.Rune, event.Key); err != nil {
+				return err
+			}
+		case <-resized:
+			if err := v.relayout(v.updateLayoutSize); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
 func (v *CLIViewer) displayCurrentPage() error {
-	chapter, err := v.reader.GetChapter(v.currentPos.Chapter)
+	layout, err := v.chapterLayout(v.currentPos.Chapter)
 	if err != nil {
 		return err
 	}
 
 	clearScreen()
 
-	content := v.formatContent(chapter.Content)
-	pages := v.paginateContent(content)
-
+	pages := layout.pages
 	if v.currentPos.Page >= len(pages) {
 		v.currentPos.Page = len(pages) - 1
 	}
 
+	if v.currentPos != v.scrollPage {
+		v.codeScroll = 0
+		v.scrollPage = v.currentPos
+	}
+
+	padding := strings.Repeat(" ", v.leftPadding())
+	scrollable := false
 	if v.currentPos.Page >= 0 && v.currentPos.Page < len(pages) {
-		fmt.Println(pages[v.currentPos.Page])
+		page := pages[v.currentPos.Page]
+		scrollable = maxScroll(page, v.lineWidth) > 0
+		for _, line := range page {
+			if len(line.spans) == 0 {
+				fmt.Println()
+				continue
+			}
+			line = v.markAnnotations(line, v.currentPos.Chapter)
+			fmt.Println(padding + line.clip(v.codeScroll, v.lineWidth).String())
+		}
 	}
 
 	footer := color.New(color.FgYellow)
-	footer.Printf("\nPage %d of %d\n", v.currentPos.Page+1, len(pages))
-	footer.Println("\nUse arrow keys to navigate (←/→ pages, ↑/↓ chapters)")
-	footer.Println("Press 'h' for help, 'q' to quit")
+	footer.Printf("\n%sPage %d of %d", padding, v.currentPos.Page+1, len(pages))
+	if page, total, ok := v.bookPosition(); ok {
+		footer.Printf(" · page %d of %d in book", page, total)
+	}
+	if scrollable {
+		footer.Print(" · < / > scrolls code")
+	}
+	if v.pageBookmarked(layout) {
+		footer.Print(" · bookmarked")
+	}
+	if v.search != nil {
+		footer.Printf(" · match %d of %d (n/N)", v.search.current+1, len(v.search.hits))
+	}
+	footer.Println()
+	if v.selection != nil {
+		unit := "sentence"
+		if v.selection.paragraphs {
+			unit = "paragraph"
+		}
+		footer.Printf("\n%sSelecting by %s: ←/→ move, ↓/↑ extend or shrink, Tab switches unit\n", padding, unit)
+		footer.Printf("%s1-%d color, Enter to highlight, Esc to cancel\n", padding, len(annotation.Colors))
+		return nil
+	}
+	footer.Printf("\n%sUse arrow keys to navigate (←/→ pages, ↑/↓ chapters)\n", padding)
+	footer.Printf("%sPress 'h' for help, 'q' to quit\n", padding)
 	return nil
 }
 
-func (v *CLIViewer) nextPage() error {
-	chapter, err := v.reader.GetChapter(v.currentPos.Chapter)
+// scrollCode moves the code lines of the current page sideways by delta columns
+func (v *CLIViewer) scrollCode(delta int) error {
+	layout, err := v.chapterLayout(v.currentPos.Chapter)
 	if err != nil {
 		return err
 	}
+	if v.currentPos.Page < 0 || v.currentPos.Page >= len(layout.pages) {
+		return nil
+	}
+	limit := maxScroll(layout.pages[v.currentPos.Page], v.lineWidth)
+	v.codeScroll = min(max(v.codeScroll+delta, 0), limit)
+	return nil
+}
 
-	content := v.formatContent(chapter.Content)
-	pages := v.paginateContent(content)
+// nextColorScheme switches to the following predefined color scheme
+func (v *CLIViewer) nextColorScheme() {
+	names := make([]string, 0, len(colors.PredefinedSchemes))
+	for name := range colors.PredefinedSchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	next := (sort.SearchStrings(names, v.theme) + 1) % len(names)
+	v.theme = names[next]
+}
+
+func (v *CLIViewer) nextPage() error {
+	layout, err := v.chapterLayout(v.currentPos.Chapter)
+	if err != nil {
+		return err
+	}
 
-	if v.currentPos.Page < len(pages)-1 {
+	if v.currentPos.Page < len(layout.pages)-1 {
 		v.currentPos.Page++
 	} else {
 		return v.nextChapter()
@@ -74,15 +152,20 @@ func (v *CLIViewer) nextPage() error {
 func (v *CLIViewer) previousPage() error {
 	if v.currentPos.Page > 0 {
 		v.currentPos.Page--
-	} else {
-		if err := v.previousChapter(); err != nil {
-			return err
-		}
-		chapter, _ := v.reader.GetChapter(v.currentPos.Chapter)
-		content := v.formatContent(chapter.Content)
-		pages := v.paginateContent(content)
-		v.currentPos.Page = len(pages) - 1
+		return nil
+	}
+	if v.currentPos.Chapter == 0 {
+		return nil
+	}
+
+	if err := v.previousChapter(); err != nil {
+		return err
+	}
+	layout, err := v.chapterLayout(v.currentPos.Chapter)
+	if err != nil {
+		return err
 	}
+	v.currentPos.Page = len(layout.pages) - 1
 	return nil
 }
 
@@ -110,21 +193,110 @@ func (v *CLIViewer) goToChapter(num int) error {
 	return nil
 }
 
-func (v *CLIViewer) showTableOfContents() {
-	clearScreen()
-	toc := color.New(color.FgCyan)
-	toc.Println("=== Table of Contents ===")
-	fmt.Println()
+// goToLocation jumps to a chapter and, when given, the page holding the anchor
+func (v *CLIViewer) goToLocation(chapterIndex int, fragment string) error {
+	layout, err := v.chapterLayout(chapterIndex)
+	if err != nil {
+		return err
+	}
+
+	v.currentPos.Chapter = chapterIndex
+	v.currentPos.Page = 0
 
+	if offset, ok := layout.anchors[fragment]; ok && fragment != "" {
+		v.currentPos.Page = layout.pageForOffset(offset)
+	}
+	return nil
+}
+
+// tocItems returns the entries to list in the table of contents, falling
+// back to one entry per chapter when the book has no navigation document
+func (v *CLIViewer) tocItems() ([]core.TOCEntry, []int) {
+	if toc := v.reader.GetNavigation().TOC; len(toc) > 0 {
+		return core.FlattenTOC(toc)
+	}
+
+	var entries []core.TOCEntry
+	var depths []int
 	for i := 0; i < v.reader.GetTotalChapters(); i++ {
 		chapter, err := v.reader.GetChapter(i)
 		if err != nil {
 			continue
 		}
-		fmt.Printf("%3d. %s\n", i+1, chapter.Title)
+		entries = append(entries, core.TOCEntry{Title: chapter.Title, SpineIndex: i})
+		depths = append(depths, 0)
+	}
+	return entries, depths
+}
+
+// currentTOCEntry returns the index of the last entry pointing at the current chapter
+func currentTOCEntry(entries []core.TOCEntry, chapter int) int {
+	selected := 0
+	for i, entry := range entries {
+		if entry.SpineIndex == chapter {
+			return i
+		}
+		if entry.HasTarget() && entry.SpineIndex < chapter {
+			selected = i
+		}
+	}
+	return selected
+}
+
+func (v *CLIViewer) showTableOfContents() error {
+	entries, depths := v.tocItems()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	selected := currentTOCEntry(entries, v.currentPos.Chapter)
+	toc := color.New(color.FgCyan)
+	highlight := color.New(color.FgBlack, color.BgCyan)
+
+	for {
+		clearScreen()
+		toc.Println("=== Table of Contents ===")
+		fmt.Println()
+
+		// Keep the selection visible inside a window of pageSize entries
+		start := 0
+		if selected >= v.pageSize {
+			start = selected - v.pageSize + 1
+		}
+		end := min(start+v.pageSize, len(entries))
+
+		for i := start; i < end; i++ {
+			line := fmt.Sprintf("%s%s", strings.Repeat("  ", depths[i]), entries[i].Title)
+			switch {
+			case i == selected:
+				highlight.Printf("> %s\n", line)
+			case !entries[i].HasTarget():
+				color.New(color.Faint).Printf("  %s\n", line)
+			default:
+				fmt.Printf("  %s\n", line)
+			}
+		}
+
+		fmt.Println("\n↑/↓ to move, Enter to jump, Esc or 'q' to go back")
+
+		char, key, err := keyboard.GetKey()
+		if err != nil {
+			return fmt.Errorf("keyboard error: %w", err)
+		}
+
+		switch {
+		case key == keyboard.KeyArrowUp && selected > 0:
+			selected--
+		case key == keyboard.KeyArrowDown && selected < len(entries)-1:
+			selected++
+		case key == keyboard.KeyEnter:
+			if entries[selected].HasTarget() {
+				return v.goToLocation(entries[selected].SpineIndex, entries[selected].Fragment)
+			}
+		case key == keyboard.KeyEsc || char == 'q':
+			return nil
+		}
 	}
-	fmt.Println("\nPress any key to continue...")
-	keyboard.GetKey()
 }
 
 // Update the CLIViewer struct
@@ -149,10 +321,42 @@ func (v *CLIViewer) Start(filePath string) error {
 	}
 	v.currentFile = absPath
 
+	if v.storage == nil {
+		if v.storage, err = storage.OpenDefault(); err != nil {
+			return err
+		}
+		defer v.storage.Close()
+	}
+
 	if _, err := v.reader.Open(filePath); err != nil {
 		return fmt.Errorf("failed to open book: %w", err)
 	}
-	defer v.reader.Close()
+	defer func() {
+		v.stopCountingPages()
+		// Save progress before closing, while chapters can still be read
+		if err := v.saveProgress(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to save progress: %v\n", err)
+		}
+		v.reader.Close()
+	}()
+
+	metadata := v.reader.GetMetadata()
+	if v.bookID, err = core.IdentifyBook(absPath, metadata); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to identify book: %v\n", err)
+	}
+	err = v.storage.AddHistory(core.HistoryEntry{
+		Book:     v.bookID,
+		FilePath: absPath,
+		Title:    metadata.Title,
+		Author:   metadata.Author,
+		Opened:   time.Now(),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to record history: %v\n", err)
+	}
+
+	// Saved locations are turned into pages, so the layout must be known first
+	v.updateLayoutSize()
 
 	// Load saved progress
 	if err := v.loadProgress(); err != nil {
@@ -160,20 +364,20 @@ func (v *CLIViewer) Start(filePath string) error {
 
 		fmt.Fprintf(os.Stderr, "Warning: Failed to load progress: %v\n", err)
 	}
+	if err := v.loadAnnotations(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to load annotations: %v\n", err)
+	}
 
 	if err := v.showWelcomeScreen(); err != nil {
 		return err
 	}
+
+	v.countBookPages()
 	return v.eventLoop()
 }
 
 // Update the cleanup method
 func (v *CLIViewer) cleanup() {
-	// Save progress before closing
-	if err := v.saveProgress(); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: Failed to save progress: %v\n", err)
-	}
-
 	if v.input != nil {
 		v.input.Close()
 	}
@@ -204,7 +408,31 @@ func (v *CLIViewer) handleKeyPress(char rune, key keyboard.Key) error {
 	case 'h':
 		v.showHelp()
 	case 't':
-		v.showTableOfContents()
+		return v.showTableOfContents()
+	case '+':
+		return v.relayout(func() { v.resizeColumn(columnStep) })
+	case '-':
+		return v.relayout(func() { v.resizeColumn(-columnStep) })
+	case '<':
+		return v.scrollCode(-codeScrollStep)
+	case '>':
+		return v.scrollCode(codeScrollStep)
+	case 'c':
+		return v.relayout(v.nextColorScheme)
+	case '/':
+		return v.startSearch()
+	case 'b':
+		return v.addBookmark()
+	case 'B':
+		return v.showBookmarks()
+	case 'v':
+		return v.selectPassage()
+	case 'H':
+		return v.showHighlights()
+	case 'n':
+		return v.cycleSearch(1)
+	case 'N':
+		return v.cycleSearch(-1)
 	case 's':
 		// Add manual save option
 		if err := v.saveProgress(); err != nil {
@@ -233,10 +461,19 @@ func (v *CLIViewer) showHelp() {
 	fmt.Println("  ↓              - Next chapter")
 	fmt.Println("  ↑              - Previous chapter")
 	fmt.Println("  [number]       - Go to chapter number")
+	fmt.Println("  + / -          - Widen or narrow the text column")
+	fmt.Println("  < / >          - Scroll wide code blocks sideways")
+	fmt.Println("  /              - Search the book (Tab in the prompt toggles regex)")
+	fmt.Println("  n / N          - Next or previous search match")
+	fmt.Println("  b              - Bookmark this page")
+	fmt.Println("  B              - List bookmarks")
+	fmt.Println("  v              - Select a passage to highlight and annotate")
+	fmt.Println("  H              - List highlights and notes")
 	fmt.Println()
 	fmt.Println("Other commands:")
 	fmt.Println("  h              - Show this help")
 	fmt.Println("  t              - Show table of contents")
+	fmt.Println("  c              - Switch color scheme")
 	fmt.Println("  s              - Save progress manually")
 	fmt.Println("  q or ESC       - Exit the reader")
 	fmt.Println("\nProgress is automatically saved when exiting")
@@ -254,12 +491,43 @@ func (v *CLIViewer) showWelcomeScreen() error {
 	title.Println("=== EPUB Reader ===")
 	fmt.Println()
 	fmt.Printf("Title: %s\n", metadata.Title)
+	if metadata.Subtitle != "" {
+		fmt.Printf("Subtitle: %s\n", metadata.Subtitle)
+	}
 	fmt.Printf("Author: %s\n", metadata.Author)
+	printContributors("Translator", metadata.ContributorsWithRole(core.RoleTranslator))
+	printContributors("Editor", metadata.ContributorsWithRole(core.RoleEditor))
+	if metadata.Series != "" {
+		if metadata.SeriesIndex != "" {
+			fmt.Printf("Series: %s #%s\n", metadata.Series, metadata.SeriesIndex)
+		} else {
+			fmt.Printf("Series: %s\n", metadata.Series)
+		}
+	}
+	printField("Publisher", metadata.Publisher)
+	printField("Published", metadata.Published)
+	printField("Language", metadata.Language)
+	printField("ISBN", metadata.ISBN())
+	if len(metadata.Subjects) > 0 {
+		fmt.Printf("Subjects: %s\n", strings.Join(metadata.Subjects, ", "))
+	}
+	printField("Rights", metadata.Rights)
 	fmt.Printf("Total Chapters: %d\n", v.reader.GetTotalChapters())
 
+	if metadata.Description != "" {
+		fmt.Println()
+		if pages := v.paginateContent(metadata.Description); len(pages) > 0 {
+			fmt.Println(truncateLines(pages[0], 6))
+		}
+	}
+
 	if v.currentPos.Chapter > 0 || v.currentPos.Page > 0 {
-		fmt.Printf("\nResuming from Chapter %d, Page %d\n",
+		fmt.Printf("\nResuming from Chapter %d, Page %d",
 			v.currentPos.Chapter+1, v.currentPos.Page+1)
+		if v.syncedFrom != "" {
+			fmt.Printf(" (synced from %s)", v.syncedFrom)
+		}
+		fmt.Println()
 	}
 
 	fmt.Println("\nUse arrow keys to navigate")
@@ -269,3 +537,17 @@ func (v *CLIViewer) showWelcomeScreen() error {
 	_, _, err := keyboard.GetKey()
 	return err
 }
+
+func printField(label, value string) {
+	if value != "" {
+		fmt.Printf("%s: %s\n", label, value)
+	}
+}
+
+func printContributors(label string, contributors []core.Contributor) {
+	var names []string
+	for _, c := range contributors {
+		names = append(names, c.Name)
+	}
+	printField(label, strings.Join(names, ", "))
+}
@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"unicode"
+)
+
+// wideRanges lists the East Asian Wide and Fullwidth code points, along with
+// the emoji presentation blocks terminals render in two columns
+var wideRanges = []struct{ lo, hi rune }{
+	{0x1100, 0x115F}, // Hangul Jamo initial consonants
+	{0x231A, 0x231B},
+	{0x2329, 0x232A},
+	{0x23E9, 0x23EC},
+	{0x23F0, 0x23F0},
+	{0x23F3, 0x23F3},
+	{0x25FD, 0x25FE},
+	{0x2614, 0x2615},
+	{0x2648, 0x2653},
+	{0x267F, 0x267F},
+	{0x2693, 0x2693},
+	{0x26A1, 0x26A1},
+	{0x26AA, 0x26AB},
+	{0x26BD, 0x26BE},
+	{0x26C4, 0x26C5},
+	{0x26CE, 0x26CE},
+	{0x26D4, 0x26D4},
+	{0x26EA, 0x26EA},
+	{0x26F2, 0x26F3},
+	{0x26F5, 0x26F5},
+	{0x26FA, 0x26FA},
+	{0x26FD, 0x26FD},
+	{0x2705, 0x2705},
+	{0x270A, 0x270B},
+	{0x2728, 0x2728},
+	{0x274C, 0x274C},
+	{0x274E, 0x274E},
+	{0x2753, 0x2755},
+	{0x2757, 0x2757},
+	{0x2795, 0x2797},
+	{0x27B0, 0x27B0},
+	{0x27BF, 0x27BF},
+	{0x2B1B, 0x2B1C},
+	{0x2B50, 0x2B50},
+	{0x2B55, 0x2B55},
+	{0x2E80, 0x303E},   // CJK radicals, Kangxi, CJK symbols and punctuation
+	{0x3041, 0x33FF},   // Hiragana, Katakana, Bopomofo, compatibility
+	{0x3400, 0x4DBF},   // CJK Unified Ideographs Extension A
+	{0x4E00, 0x9FFF},   // CJK Unified Ideographs
+	{0xA000, 0xA4CF},   // Yi
+	{0xA960, 0xA97F},   // Hangul Jamo Extended-A
+	{0xAC00, 0xD7A3},   // Hangul syllables
+	{0xF900, 0xFAFF},   // CJK compatibility ideographs
+	{0xFE10, 0xFE19},   // Vertical forms
+	{0xFE30, 0xFE6F},   // CJK compatibility forms, small form variants
+	{0xFF00, 0xFF60},   // Fullwidth forms
+	{0xFFE0, 0xFFE6},   // Fullwidth signs
+	{0x16FE0, 0x16FE4}, // Ideographic symbols
+	{0x17000, 0x18AFF}, // Tangut
+	{0x1B000, 0x1B2FF}, // Kana supplement and extensions
+	{0x1F004, 0x1F004},
+	{0x1F0CF, 0x1F0CF},
+	{0x1F18E, 0x1F18E},
+	{0x1F191, 0x1F19A},
+	{0x1F200, 0x1F251}, // Enclosed ideographic supplement
+	{0x1F300, 0x1F64F}, // Misc symbols and pictographs, emoticons
+	{0x1F680, 0x1F6FF}, // Transport and map symbols
+	{0x1F7E0, 0x1F7EB},
+	{0x1F900, 0x1F9FF}, // Supplemental symbols and pictographs
+	{0x1FA70, 0x1FAFF}, // Symbols and pictographs extended-A
+	{0x20000, 0x3FFFD}, // CJK extensions B and later
+}
+
+const (
+	zeroWidthJoiner = '\u200d'
+	emojiModifierLo = 0x1F3FB
+	emojiModifierHi = 0x1F3FF
+)
+
+// runeWidth returns the number of terminal columns r occupies
+func runeWidth(r rune) int {
+	switch {
+	case r == 0 || r == zeroWidthJoiner:
+		return 0
+	case r < 0x20 || (r >= 0x7F && r < 0xA0):
+		return 0
+	case r < 0x1100:
+		if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) {
+			return 0
+		}
+		return 1
+	case unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) || unicode.Is(unicode.Cf, r):
+		return 0
+	case unicode.Is(unicode.Variation_Selector, r):
+		return 0
+	case r >= emojiModifierLo && r <= emojiModifierHi:
+		return 0 // Skin tone modifiers merge into the preceding emoji
+	}
+
+	// Binary search the wide ranges
+	lo, hi := 0, len(wideRanges)-1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		switch {
+		case r < wideRanges[mid].lo:
+			hi = mid - 1
+		case r > wideRanges[mid].hi:
+			lo = mid + 1
+		default:
+			return 2
+		}
+	}
+	return 1
+}
+
+// displayWidth returns the number of terminal columns s occupies
+func displayWidth(s string) int {
+	width := 0
+	for _, c := range splitClusters(s) {
+		width += c.width
+	}
+	return width
+}
+
+// cluster is a user-perceived character: a base rune plus the combining
+// marks, variation selectors and ZWJ-joined runes that render with it
+type cluster struct {
+	text  string
+	width int
+}
+
+// splitClusters splits s into clusters, attaching zero-width runes and the
+// rune following a zero width joiner to the preceding cluster
+func splitClusters(s string) []cluster {
+	var clusters []cluster
+	joinNext := false
+
+	for _, r := range s {
+		w := runeWidth(r)
+		if len(clusters) > 0 && (w == 0 || joinNext) {
+			last := &clusters[len(clusters)-1]
+			last.text += string(r)
+			joinNext = r == zeroWidthJoiner
+			continue
+		}
+		clusters = append(clusters, cluster{text: string(r), width: w})
+		joinNext = false
+	}
+	return clusters
+}
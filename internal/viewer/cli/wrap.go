@@ -0,0 +1,339 @@
+package cli
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Kinsoku shori: characters that must not start a line, and characters
+// that must not end one, following the common JIS X 4051 / CLREQ subsets
+const (
+	noLineStartChars = "、。，．・：；？！゛゜ヽヾゝゞ々〻ー’”）〕］｝〉》」』】〙〗〟｠»" +
+		"ぁぃぅぇぉっゃゅょゎゕゖァィゥェォッャュョヮヵヶㇰㇱㇲㇳㇴㇵㇶㇷㇸㇹㇺㇻㇼㇽㇾㇿ" +
+		"‐゠–〜～…‥﹐﹑﹒﹔﹕﹖﹗％‰℃,.:;?!)]}%"
+	noLineEndChars = "‘“（〔［｛〈《「『【〘〖〝｟«([{＄￥£"
+)
+
+// styledCluster is a cluster with its style and the text offset before it
+type styledCluster struct {
+	cluster
+	style  textStyle
+	offset int
+}
+
+// wrapToken is an unbreakable run of clusters
+type wrapToken struct {
+	clusters    []styledCluster
+	width       int
+	spaceBefore bool // Separated from the previous token by whitespace
+	lineBreak   bool // Forced line break, carries no clusters
+}
+
+func (t *wrapToken) append(other wrapToken) {
+	t.clusters = append(t.clusters, other.clusters...)
+	t.width += other.width
+}
+
+// isBreakableEverywhere reports whether a line may break on either side of
+// the cluster, as with CJK ideographs, kana, hangul and emoji
+func isBreakableEverywhere(c cluster) bool {
+	return c.width == 2
+}
+
+func isSpace(c cluster) bool {
+	r := []rune(c.text)[0]
+	// No-break spaces keep their neighbours together
+	return unicode.IsSpace(r) && r != '\u00a0' && r != '\u202f'
+}
+
+func firstRune(t wrapToken) rune {
+	return []rune(t.clusters[0].text)[0]
+}
+
+// lastRune returns the base rune of the token's final cluster
+func lastRune(t wrapToken) rune {
+	return []rune(t.clusters[len(t.clusters)-1].text)[0]
+}
+
+// styledClusters splits spans into clusters, tracking the text offset
+func styledClusters(spans []span) []styledCluster {
+	var result []styledCluster
+	for _, s := range spans {
+		offset := s.offset
+		for _, c := range splitClusters(s.text) {
+			result = append(result, styledCluster{cluster: c, style: s.style, offset: offset})
+			if offset >= 0 {
+				offset += textOffsetLen(c.text)
+			}
+		}
+	}
+	return result
+}
+
+// tokenize splits styled text into unbreakable tokens: words for scripts
+// separated by spaces, single clusters for CJK, glued by kinsoku rules
+func tokenize(spans []span) []wrapToken {
+	var tokens []wrapToken
+	var current *wrapToken
+	pendingSpace := false
+
+	flush := func() {
+		if current != nil {
+			tokens = append(tokens, *current)
+			current = nil
+		}
+	}
+
+	for _, c := range styledClusters(spans) {
+		switch {
+		case c.text == "\n" && c.offset < 0:
+			flush()
+			tokens = append(tokens, wrapToken{lineBreak: true})
+			pendingSpace = false
+		case isSpace(c.cluster):
+			flush()
+			pendingSpace = true
+		case isBreakableEverywhere(c.cluster):
+			flush()
+			tokens = append(tokens, wrapToken{clusters: []styledCluster{c}, width: c.width, spaceBefore: pendingSpace})
+			pendingSpace = false
+		default:
+			if current == nil {
+				current = &wrapToken{spaceBefore: pendingSpace}
+				pendingSpace = false
+			}
+			current.clusters = append(current.clusters, c)
+			current.width += c.width
+		}
+	}
+	flush()
+
+	return applyKinsoku(tokens)
+}
+
+// applyKinsoku glues tokens so that prohibited characters never begin or
+// end a line; only tokens not separated by whitespace are joined
+func applyKinsoku(tokens []wrapToken) []wrapToken {
+	var result []wrapToken
+	for _, token := range tokens {
+		if len(result) > 0 && !token.spaceBefore && !token.lineBreak && !result[len(result)-1].lineBreak {
+			prev := &result[len(result)-1]
+			if strings.ContainsRune(noLineStartChars, firstRune(token)) ||
+				strings.ContainsRune(noLineEndChars, lastRune(*prev)) {
+				prev.append(token)
+				continue
+			}
+		}
+		result = append(result, token)
+	}
+	return result
+}
+
+// lineBuilder accumulates styled clusters into a line, merging runs of the
+// same style into spans
+type lineBuilder struct {
+	spans     []span
+	width     int
+	offset    int
+	hasOffset bool // Offset comes from book text rather than the default
+	empty     bool
+	joined    bool // See line.joined
+}
+
+func newLineBuilder(offset int) *lineBuilder {
+	return &lineBuilder{offset: offset, empty: true}
+}
+
+func (b *lineBuilder) add(c styledCluster) {
+	if !b.hasOffset && c.offset >= 0 {
+		b.offset = c.offset
+		b.hasOffset = true
+	}
+	b.empty = false
+	b.width += c.width
+
+	if n := len(b.spans); n > 0 && b.spans[n-1].style == c.style {
+		// Spaces carry no text offset, so they can join book text either way
+		last := &b.spans[n-1]
+		lastBlank := strings.TrimSpace(last.text) == ""
+		switch {
+		case (last.offset < 0) == (c.offset < 0),
+			last.offset >= 0 && strings.TrimSpace(c.text) == "":
+			last.text += c.text
+			return
+		case last.offset < 0 && lastBlank:
+			last.text += c.text
+			last.offset = c.offset
+			return
+		}
+	}
+	b.spans = append(b.spans, span{text: c.text, style: c.style, offset: c.offset})
+}
+
+func (b *lineBuilder) line() line {
+	return line{spans: b.spans, offset: b.offset, joined: b.joined}
+}
+
+// wrapSpans breaks styled text into lines no wider than maxWidth columns
+func wrapSpans(spans []span, maxWidth int) []line {
+	var lines []line
+	nextOffset := 0
+	for _, s := range spans {
+		if s.offset >= 0 {
+			nextOffset = s.offset
+			break
+		}
+	}
+
+	current := newLineBuilder(nextOffset)
+	var lastCluster *styledCluster
+
+	// joined tells whether the next line continues the text without
+	// whitespace between, as when breaking inside a word or a CJK run
+	flush := func(joined bool) {
+		lines = append(lines, current.line())
+		end := current.offset
+		if lastCluster != nil && lastCluster.offset >= 0 {
+			end = lastCluster.offset + textOffsetLen(lastCluster.text)
+		}
+		current = newLineBuilder(end)
+		current.joined = joined
+	}
+
+	for _, token := range tokenize(spans) {
+		if token.lineBreak {
+			flush(false)
+			continue
+		}
+
+		gap := 0
+		if token.spaceBefore && !current.empty {
+			gap = 1
+		}
+
+		if !current.empty && current.width+gap+token.width > maxWidth {
+			flush(!token.spaceBefore)
+			gap = 0
+		}
+
+		if token.width > maxWidth {
+			// Hard break tokens that cannot fit on any line
+			for i := range token.clusters {
+				c := token.clusters[i]
+				if !current.empty && current.width+c.width > maxWidth {
+					flush(i > 0 || !token.spaceBefore)
+				}
+				current.add(c)
+				lastCluster = &token.clusters[i]
+			}
+			continue
+		}
+
+		if gap > 0 {
+			// The space takes the style shared by both sides, e.g. an underlined link
+			gapStyle := textStyle{}
+			if lastCluster != nil && lastCluster.style == token.clusters[0].style {
+				gapStyle = lastCluster.style
+			}
+			current.add(styledCluster{cluster: cluster{text: " ", width: 1}, style: gapStyle, offset: -1})
+		}
+		for i := range token.clusters {
+			current.add(token.clusters[i])
+			lastCluster = &token.clusters[i]
+		}
+	}
+
+	if !current.empty {
+		flush(false)
+	}
+	return lines
+}
+
+// clip returns the part of a verbatim line visible when scrolled by scroll
+// columns on a page width columns wide. Hidden text is marked by « and ».
+func (l line) clip(scroll, width int) line {
+	if !l.verbatim {
+		return l
+	}
+	fixed := l.spans[:l.fixed]
+	clusters := styledClusters(l.spans[l.fixed:])
+	total := 0
+	for _, c := range clusters {
+		total += c.width
+	}
+
+	b := newLineBuilder(l.offset)
+	for _, c := range styledClusters(fixed) {
+		b.add(c)
+	}
+	room := width - b.width
+	if total == 0 || scroll == 0 && total <= room {
+		return l
+	}
+
+	if scroll > 0 {
+		room--
+		b.add(markerCluster("«"))
+	}
+	more := total-scroll > room
+	if more {
+		room--
+	}
+
+	col, used := 0, 0
+	for _, c := range clusters {
+		start := col
+		col += c.width
+		if start < scroll {
+			// A wide character cut by the left edge leaves a gap
+			for ; col > scroll && used < col-scroll; used++ {
+				b.add(markerCluster(" "))
+			}
+			continue
+		}
+		if used+c.width > room {
+			break
+		}
+		b.add(c)
+		used += c.width
+	}
+
+	if more {
+		for ; used < room; used++ {
+			b.add(markerCluster(" "))
+		}
+		b.add(markerCluster("»"))
+	}
+	return line{spans: b.spans, offset: l.offset}
+}
+
+// maxScroll returns how far the verbatim lines of a page can scroll
+// sideways before their ends are in view
+func maxScroll(lines []line, width int) int {
+	result := 0
+	for _, l := range lines {
+		if !l.verbatim {
+			continue
+		}
+		content := line{spans: l.spans[l.fixed:]}.width()
+		room := width - line{spans: l.spans[:l.fixed]}.width()
+		if content > room {
+			// One column goes to the « marker once scrolled
+			result = max(result, content-room+1)
+		}
+	}
+	return result
+}
+
+func markerCluster(text string) styledCluster {
+	return styledCluster{cluster: cluster{text: text, width: 1}, style: textStyle{faint: true}, offset: -1}
+}
+
+// wrapParagraph breaks plain text into lines no wider than maxWidth columns
+func wrapParagraph(paragraph string, maxWidth int) []string {
+	var result []string
+	for _, l := range wrapSpans([]span{{text: paragraph}}, maxWidth) {
+		result = append(result, l.plain())
+	}
+	return result
+}